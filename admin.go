@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Limits on the values accepted by the admin API.
+const (
+	minTickInterval = 20 * time.Millisecond
+	maxTickInterval = 10 * time.Second
+	maxNoticeLen    = 500
+)
+
+// adminCommands maps the name of each admin API command to a function that
+// converts a request into the message to send to gol.
+var adminCommands = map[string]func(r *http.Request) (interface{}, error){
+	"pause": func(r *http.Request) (interface{}, error) {
+		return &pause{}, nil
+	},
+	"resume": func(r *http.Request) (interface{}, error) {
+		return &resume{}, nil
+	},
+	"step": func(r *http.Request) (interface{}, error) {
+		return &step{}, nil
+	},
+	"clear": func(r *http.Request) (interface{}, error) {
+		return &clearGrid{}, nil
+	},
+	// interval takes the new tick interval in milliseconds from the "ms"
+	// query parameter.
+	"interval": func(r *http.Request) (interface{}, error) {
+		ms, err := intQueryParam(r.URL.Query().Get("ms"))
+		if err != nil {
+			return nil, err
+		}
+		d := time.Duration(ms) * time.Millisecond
+		if d < minTickInterval || d > maxTickInterval {
+			return nil, errors.New("tick interval is out of range")
+		}
+		return &setTickInterval{d}, nil
+	},
+	// notice takes the text of the notice from the request body.
+	"notice": func(r *http.Request) (interface{}, error) {
+		text, err := io.ReadAll(io.LimitReader(r.Body, maxNoticeLen+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(text) == 0 || len(text) > maxNoticeLen {
+			return nil, errors.New("notice must be between 1 and 500 bytes long")
+		}
+		return &notice{string(text)}, nil
+	},
+}
+
+// handleAdmin serves the admin API, which allows the server's operator to
+// control the simulation. Each command is a POST request to /admin/<command>,
+// where <command> is a key of adminCommands. Commands are sent to gol, so
+// they are ordered with respect to ticks and new connections.
+func handleAdmin(pl *pipeline) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		cmd, ok := adminCommands[strings.TrimPrefix(r.URL.Path, "/admin/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		m, err := cmd(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		pl.golChan <- m
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Each admin command should send the corresponding message to gol.
+func Test_handleAdmin(t *testing.T) {
+	golChan := make(chan interface{})
+	h := handleAdmin(&pipeline{golChan: golChan})
+
+	tests := []struct {
+		target   string
+		body     string
+		expected interface{}
+	}{
+		{"/admin/pause", "", &pause{}},
+		{"/admin/resume", "", &resume{}},
+		{"/admin/step", "", &step{}},
+		{"/admin/clear", "", &clearGrid{}},
+		{"/admin/interval?ms=500", "", &setTickInterval{500 * time.Millisecond}},
+		{"/admin/notice", "hello", &notice{"hello"}},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		done := make(chan struct{})
+		go func() {
+			h(rec, httptest.NewRequest("POST", tt.target, strings.NewReader(tt.body)))
+			close(done)
+		}()
+		m := recv(t, golChan)
+		recv(t, done)
+		if rec.Code != http.StatusNoContent {
+			t.Errorf("%v: expected status %v but got %v", tt.target, http.StatusNoContent, rec.Code)
+		}
+		switch expected := tt.expected.(type) {
+		case *setTickInterval:
+			if got, ok := m.(*setTickInterval); !ok || *got != *expected {
+				t.Errorf("%v: expected %+v but got %+v", tt.target, expected, m)
+			}
+		case *notice:
+			if got, ok := m.(*notice); !ok || *got != *expected {
+				t.Errorf("%v: expected %+v but got %+v", tt.target, expected, m)
+			}
+		default:
+			if got, want := fmt.Sprintf("%T", m), fmt.Sprintf("%T", expected); got != want {
+				t.Errorf("%v: expected %v but got %v", tt.target, want, got)
+			}
+		}
+	}
+}
+
+func Test_handleAdminInvalid(t *testing.T) {
+	h := handleAdmin(&pipeline{})
+
+	tests := []struct {
+		method   string
+		target   string
+		body     string
+		expected int
+	}{
+		{"GET", "/admin/pause", "", http.StatusMethodNotAllowed},
+		{"POST", "/admin/nonexistent", "", http.StatusNotFound},
+		{"POST", "/admin/interval?ms=1", "", http.StatusBadRequest},
+		{"POST", "/admin/interval", "", http.StatusBadRequest},
+		{"POST", "/admin/notice", "", http.StatusBadRequest},
+		{"POST", "/admin/notice", strings.Repeat("a", maxNoticeLen+1), http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body)))
+		if rec.Code != tt.expected {
+			t.Errorf("%v %v: expected status %v but got %v", tt.method, tt.target,
+				tt.expected, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Directions in which an ant can face. Moving north decreases X, which is
+// toward the top of the grid as the client renders it.
+const (
+	north = iota
+	east
+	south
+	west
+)
+
+// defaultAntRule is the rule followed by Langton's Ant.
+const defaultAntRule = "RL"
+
+// defaultMaxAnts is the default value of config.maxAnts.
+const defaultMaxAnts = 100
+
+// ant is a turmite that walks the grid. Each generation, it turns according
+// to the state of the cell it is on, flips that cell between dead and its own
+// species, and moves forward one cell.
+type ant struct {
+	X       int     `json:"x"`
+	Y       int     `json:"y"`
+	Dir     int     `json:"dir"`
+	Species species `json:"species"`
+	// mirrored is true if the ant has crossed twisted edges an odd number of
+	// times, so that its left and right are swapped as seen on the grid.
+	mirrored bool
+}
+
+// validateAntRule checks that a rule gives a turn for each of the two cell
+// states, dead and live. A turn is one of "L" (left), "R" (right), "N" (no
+// turn), or "U" (U-turn). E.g., Langton's Ant is "RL": it turns right on a
+// dead cell and left on a live cell.
+func validateAntRule(rule string) error {
+	if len(rule) != 2 {
+		return fmt.Errorf("ant rule must have one turn for each of 2 cell states (%v)", rule)
+	}
+	for _, r := range rule {
+		if !strings.ContainsRune("LRNU", r) {
+			return fmt.Errorf("ant rule contains an invalid turn (%c)", r)
+		}
+	}
+	return nil
+}
+
+// validateAnt checks an ant that a client asked to spawn.
+func validateAnt(a *ant) error {
+	if a.X < 0 || a.X >= gridDimX {
+		return errors.New("ant exceeds grid's X dimension")
+	}
+	if a.Y < 0 || a.Y >= gridDimY {
+		return errors.New("ant exceeds grid's Y dimension")
+	}
+	if a.Dir < north || a.Dir > west {
+		return fmt.Errorf("ant has an invalid direction (%v)", a.Dir)
+	}
+	if !hexColorCode.MatchString(a.Species) {
+		return fmt.Errorf("ant contains a species that is not a "+
+			"hexadecimal color code (%v)", a.Species)
+	}
+	return nil
+}
+
+// moveAnts advances each ant by one generation, writing the cells that they
+// flip into df. The ants see the grid as it will be once df is applied, so
+// they act after the Game of Life rules and after changes from clients, and
+// their flips in turn feed into the following generation. A flip that leaves
+// a cell as it is in g, which can happen when two ants share a cell, is
+// removed from df. Ants treat dying cells as dead. See moveForward for how
+// ants move across the edges of the grid.
+func moveAnts(ants []*ant, rule string, topo topology, g *grid, df diff) {
+	for _, a := range ants {
+		current, ok := df[a.X][a.Y]
+		if !ok {
+			current = g[a.X][a.Y]
+		}
+		var turn byte
+		var next species
+		if !isLive(current) {
+			turn, next = rule[0], a.Species
+		} else {
+			turn, next = rule[1], ""
+		}
+		if next == g[a.X][a.Y] {
+			delete(df[a.X], a.Y)
+			if len(df[a.X]) == 0 {
+				delete(df, a.X)
+			}
+		} else {
+			getOrMakeYDiff(df, a.X)[a.Y] = next
+		}
+		right, left := 1, 3
+		if a.mirrored {
+			right, left = left, right
+		}
+		switch turn {
+		case 'R':
+			a.Dir = (a.Dir + right) % 4
+		case 'L':
+			a.Dir = (a.Dir + left) % 4
+		case 'U':
+			a.Dir = (a.Dir + 2) % 4
+		}
+		moveForward(a, topo)
+	}
+}
+
+// antSteps maps each direction to the offset of the cell in front of an ant.
+var antSteps = [4]point{north: {-1, 0}, east: {0, 1}, south: {1, 0}, west: {0, -1}}
+
+// moveForward moves an ant forward one cell. An ant that reaches an edge of a
+// bounded grid turns around instead of moving. An ant that crosses a twisted
+// edge keeps its direction, because a half-twist only mirrors the axis
+// parallel to the edge, but the mirroring swaps its left and right.
+func moveForward(a *ant, topo topology) {
+	o := antSteps[a.Dir]
+	p, ok := topo.wrap(a.X+o.x, a.Y+o.y)
+	if !ok {
+		a.Dir = (a.Dir + 2) % 4
+		return
+	}
+	if topo.mirrors(a.X+o.x, a.Y+o.y) {
+		a.mirrored = !a.mirrored
+	}
+	a.X, a.Y = p.x, p.y
+}
+
+// spawnAnt adds an ant to the grid.
+type spawnAnt struct {
+	a *ant
+}
+
+// copyAnts returns a copy of a list of ants, so that it can be encoded after
+// gol has moved on.
+func copyAnts(ants []*ant) []ant {
+	c := make([]ant, len(ants))
+	for i, a := range ants {
+		c[i] = *a
+	}
+	return c
+}
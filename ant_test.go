@@ -0,0 +1,76 @@
+package main
+
+import "testing"
+
+func Test_validateAntRule(t *testing.T) {
+	for _, rule := range []string{"RL", "LR", "NU", "RR"} {
+		if err := validateAntRule(rule); err != nil {
+			t.Errorf("Expected %q to be valid but got %v", rule, err)
+		}
+	}
+	for _, rule := range []string{"", "R", "RLR", "RX"} {
+		if err := validateAntRule(rule); err == nil {
+			t.Errorf("Expected %q to be invalid", rule)
+		}
+	}
+}
+
+// Langton's Ant should retrace its steps, erasing the cells it filled, after
+// it turns around.
+func Test_moveAnts(t *testing.T) {
+	g, df := &grid{}, make(diff)
+	a := &ant{10, 20, north, "#aaaaaa", false}
+
+	// On dead cells, the ant fills the cell and turns right, so four moves
+	// take it around a 2x2 square back to where it started.
+	for i := 0; i < 4; i++ {
+		moveAnts([]*ant{a}, "RL", torus, g, df)
+		flush(df, g)
+	}
+	if *a != (ant{10, 20, north, "#aaaaaa", false}) {
+		t.Errorf("Expected ant to return to its starting point but got %+v", *a)
+	}
+	for _, p := range []point{{10, 20}, {10, 21}, {11, 21}, {11, 20}} {
+		if g[p.x][p.y] != "#aaaaaa" {
+			t.Errorf("Expected (%v, %v) to be filled", p.x, p.y)
+		}
+	}
+
+	// On a live cell, the ant empties the cell and turns left.
+	moveAnts([]*ant{a}, "RL", torus, g, df)
+	if v, ok := df[10][20]; !ok || v != "" {
+		t.Errorf("Expected (10, 20) to be emptied but got %q", v)
+	}
+	if *a != (ant{10, 19, west, "#aaaaaa", false}) {
+		t.Errorf("Expected ant to move west but got %+v", *a)
+	}
+}
+
+// Ants should see changes that are already in the diff, and a flip that
+// undoes a pending change should be removed from the diff.
+func Test_moveAntsSeesDiff(t *testing.T) {
+	g, df := &grid{}, make(diff)
+	df[5] = map[int]species{5: "#bbbbbb"}
+	a := &ant{5, 5, north, "#aaaaaa", false}
+
+	moveAnts([]*ant{a}, "RL", torus, g, df)
+
+	if len(df) != 0 {
+		t.Errorf("Expected diff to be empty but got %v", df)
+	}
+	if *a != (ant{5, 4, west, "#aaaaaa", false}) {
+		t.Errorf("Expected ant to turn left and move west but got %+v", *a)
+	}
+}
+
+// Ants should wrap around the edges of the grid.
+func Test_moveAntsWraps(t *testing.T) {
+	g, df := &grid{}, make(diff)
+	a := &ant{0, gridDimY - 1, north, "#aaaaaa", false}
+
+	moveAnts([]*ant{a}, "NN", torus, g, df)
+
+	if a.X != gridDimX-1 || a.Y != gridDimY-1 {
+		t.Errorf("Expected ant to wrap to the bottom edge but got %+v", *a)
+	}
+}
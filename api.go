@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// apiBurst is the number of API requests that a client can make in quick
+	// succession.
+	apiBurst = 20
+	// apiRefill is the time it takes a client to earn another API request
+	// after using up its burst.
+	apiRefill = 500 * time.Millisecond
+	// maxAPIDiffSize is the maximum size in bytes of a diff submitted to the
+	// API.
+	maxAPIDiffSize = 1 << 20
+)
+
+// gridReport is a copy of the grid along with its generation. See
+// history.count.
+type gridReport struct {
+	g   *grid
+	gen int
+}
+
+// getGridReport requests a gridReport, which gol sends on reply. On the plane,
+// the grid is the grid-sized window at the origin. reply should be buffered
+// so that gol doesn't block.
+type getGridReport struct {
+	reply chan<- *gridReport
+}
+
+func (pl *pipeline) currentGridReport() *gridReport {
+	reply := make(chan *gridReport, 1)
+	pl.golChan <- &getGridReport{reply}
+	return <-reply
+}
+
+// cellReport is the JSON representation of a cell served by the API, along
+// with the generation as of which it is reported.
+type cellReport struct {
+	Generation int     `json:"generation"`
+	Species    species `json:"species"`
+}
+
+// getCell requests a cellReport for cell (x, y), which gol sends on reply.
+// On the grid, the cell must be within the grid. reply should be buffered so
+// that gol doesn't block.
+type getCell struct {
+	x     int64
+	y     int64
+	reply chan<- cellReport
+}
+
+func (pl *pipeline) currentCell(x int64, y int64) cellReport {
+	reply := make(chan cellReport, 1)
+	pl.golChan <- &getCell{x, y, reply}
+	return <-reply
+}
+
+// submitDiff is a diff submitted to the API. change is a *mergeDiff or, on the
+// plane, a *mergeDiff or a *mergePlaneDiff. gol merges it as usual, and sends
+// the generation that the diff will be applied at on reply, which should be
+// buffered so that gol doesn't block.
+type submitDiff struct {
+	change interface{}
+	reply  chan<- int
+}
+
+// apiGridMessage and apiSparseGridMessage are the JSON representations of the
+// grid served by the API.
+type apiGridMessage struct {
+	Generation int   `json:"generation"`
+	Grid       *grid `json:"grid"`
+}
+
+type apiSparseGridMessage struct {
+	Generation int  `json:"generation"`
+	Cells      diff `json:"cells"`
+}
+
+// apiDiffMessage is the JSON representation of the response to a submitted
+// diff.
+type apiDiffMessage struct {
+	Generation int `json:"generation"`
+}
+
+// handleAPI wraps an API handler so that each client address is limited by
+// limiter, and so that requests must carry token as a bearer token unless
+// token is empty.
+func handleAPI(token string, limiter *keyedLimiter, h http.HandlerFunc) http.HandlerFunc {
+	if token != "" {
+		h = requireToken(token, h)
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		if !limiter.allow(host, time.Now()) {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// allowMethod responds with an error and returns false if a request doesn't
+// use the given method.
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	return true
+}
+
+// handleAPIGrid serves the current grid along with its generation. The
+// "format" query parameter selects the format: "json", the default, for the
+// grid as sent to clients, or "sparse" for the cells that aren't dead, in the
+// format of a diff. It isn't supported on the plane, where clients can ask for
+// cells with handleAPICell.
+func handleAPIGrid(pl *pipeline) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !allowMethod(w, r, http.MethodGet) || refusePlane(w, pl) {
+			return
+		}
+		switch format := r.URL.Query().Get("format"); format {
+		case "", "json":
+			report := pl.currentGridReport()
+			serveJSON(w, &apiGridMessage{report.gen, report.g})
+		case "sparse":
+			report := pl.currentGridReport()
+			cells := make(diff)
+			for x := range report.g {
+				for y, s := range report.g[x] {
+					if s != "" {
+						getOrMakeYDiff(cells, x)[y] = s
+					}
+				}
+			}
+			serveJSON(w, &apiSparseGridMessage{report.gen, cells})
+		default:
+			http.Error(w, fmt.Sprintf("unknown grid format (%v)", format), http.StatusBadRequest)
+		}
+	}
+}
+
+// handleAPICell serves the cell given by the "x" and "y" query parameters,
+// along with the current generation.
+func handleAPICell(pl *pipeline) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !allowMethod(w, r, http.MethodGet) {
+			return
+		}
+		q := r.URL.Query()
+		x, err := strconv.ParseInt(q.Get("x"), 10, 64)
+		if err != nil {
+			http.Error(w, "malformed or missing x", http.StatusBadRequest)
+			return
+		}
+		y, err := strconv.ParseInt(q.Get("y"), 10, 64)
+		if err != nil {
+			http.Error(w, "malformed or missing y", http.StatusBadRequest)
+			return
+		}
+		if pl.cfg.topology != plane && (x < 0 || x >= gridDimX || y < 0 || y >= gridDimY) {
+			http.Error(w, "cell is outside the grid", http.StatusBadRequest)
+			return
+		}
+		serveJSON(w, pl.currentCell(x, y))
+	}
+}
+
+// handleAPIDiff merges a diff in the request body into the next generation,
+// and responds with the generation that it will be applied at. The body is
+// decoded and validated by decodeMessage as a message from a version 1
+// client, so it may be a diff in either version's format, or a stamp. The
+// diff is attributed to the player whose session token is given by the
+// "session" query parameter or cookie, if any.
+func handleAPIDiff(pl *pipeline) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !allowMethod(w, r, http.MethodPost) {
+			return
+		}
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxAPIDiffSize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		var player playerID
+		if token, isNew := requestSession(r); !isNew {
+			player = sessionPlayerID(token)
+		}
+		m, err := decodeMessage(data, pl.cfg, &listener{}, player)
+		if err == nil {
+			switch m.(type) {
+			case *mergeDiff, *mergePlaneDiff:
+			default:
+				err = errors.New("only diffs and stamps can be submitted")
+			}
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		reply := make(chan int, 1)
+		pl.readPumpOut <- &submitDiff{m, reply}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(&apiDiffMessage{<-reply})
+	}
+}
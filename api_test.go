@@ -0,0 +1,137 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A diff submitted to the API should be reported with the generation that it
+// will be applied at, and should then be visible in the grid and its cells.
+func Test_api(t *testing.T) {
+	golChan := make(chan interface{})
+	pl := startPipelineInternal(defaultConfig(), golChan, golChan)
+	limiter := newKeyedLimiter(apiBurst, apiRefill)
+	gridHandler := handleAPI("", limiter, handleAPIGrid(pl))
+	cellHandler := handleAPI("", limiter, handleAPICell(pl))
+	diffHandler := handleAPI("", limiter, handleAPIDiff(pl))
+
+	rec := httptest.NewRecorder()
+	diffHandler(rec, httptest.NewRequest("POST", "/api/diff", strings.NewReader("{\"10\":{\"20\":\"#aaaaaa\",\"21\":\"#aaaaaa\"}}")))
+	if rec.Code != http.StatusAccepted || rec.Body.String() != "{\"generation\":1}\n" {
+		t.Errorf("Got incorrect response: %v %v", rec.Code, rec.Body.String())
+	}
+	send[interface{}](t, golChan, &tick{})
+
+	rec = httptest.NewRecorder()
+	cellHandler(rec, httptest.NewRequest("GET", "/api/cell?x=10&y=21", nil))
+	if rec.Body.String() != "{\"generation\":1,\"species\":\"#aaaaaa\"}\n" {
+		t.Errorf("Got incorrect response: %v", rec.Body.String())
+	}
+	rec = httptest.NewRecorder()
+	gridHandler(rec, httptest.NewRequest("GET", "/api/grid?format=sparse", nil))
+	if rec.Body.String() != "{\"generation\":1,\"cells\":{\"10\":{\"20\":\"#aaaaaa\",\"21\":\"#aaaaaa\"}}}\n" {
+		t.Errorf("Got incorrect response: %v", rec.Body.String())
+	}
+	rec = httptest.NewRecorder()
+	gridHandler(rec, httptest.NewRequest("GET", "/api/grid", nil))
+	if !strings.HasPrefix(rec.Body.String(), "{\"generation\":1,\"grid\":[[") {
+		t.Errorf("Got incorrect response: %v", rec.Body.String())
+	}
+
+	// Diffs in the version 2 format are accepted too.
+	rec = httptest.NewRecorder()
+	diffHandler(rec, httptest.NewRequest("POST", "/api/diff", strings.NewReader("{\"type\":\"diff\",\"cells\":{\"50\":{\"50\":\"#bbbbbb\"}}}")))
+	if rec.Code != http.StatusAccepted || rec.Body.String() != "{\"generation\":2}\n" {
+		t.Errorf("Got incorrect response: %v %v", rec.Code, rec.Body.String())
+	}
+}
+
+// On the plane, a diff in the version 1 format should be merged like any
+// other.
+func Test_apiPlane(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.topology = plane
+	golChan := make(chan interface{})
+	pl := startPipelineInternal(cfg, golChan, golChan)
+	limiter := newKeyedLimiter(apiBurst, apiRefill)
+	cellHandler := handleAPI("", limiter, handleAPICell(pl))
+	diffHandler := handleAPI("", limiter, handleAPIDiff(pl))
+
+	rec := httptest.NewRecorder()
+	diffHandler(rec, httptest.NewRequest("POST", "/api/diff", strings.NewReader("{\"10\":{\"20\":\"#aaaaaa\"}}")))
+	if rec.Code != http.StatusAccepted || rec.Body.String() != "{\"generation\":1}\n" {
+		t.Errorf("Got incorrect response: %v %v", rec.Code, rec.Body.String())
+	}
+	send[interface{}](t, golChan, &tick{})
+
+	rec = httptest.NewRecorder()
+	cellHandler(rec, httptest.NewRequest("GET", "/api/cell?x=10&y=20", nil))
+	if rec.Body.String() != "{\"generation\":1,\"species\":\"#aaaaaa\"}\n" {
+		t.Errorf("Got incorrect response: %v", rec.Body.String())
+	}
+}
+
+func Test_apiInvalid(t *testing.T) {
+	golChan := make(chan interface{})
+	pl := startPipelineInternal(defaultConfig(), golChan, golChan)
+	tests := []struct {
+		h      http.HandlerFunc
+		method string
+		target string
+		body   string
+		code   int
+	}{
+		{handleAPIDiff(pl), "POST", "/api/diff", "{\"10\":{\"200\":\"#aaaaaa\"}}", http.StatusBadRequest},
+		{handleAPIDiff(pl), "POST", "/api/diff", "{\"-1\":{\"0\":\"#aaaaaa\"}}", http.StatusBadRequest},
+		{handleAPIDiff(pl), "POST", "/api/diff", "{\"0\":{\"-1\":\"#aaaaaa\"}}", http.StatusBadRequest},
+		{handleAPIDiff(pl), "POST", "/api/diff", "{\"type\":\"diff\",\"cells\":{\"-1\":{\"0\":\"#aaaaaa\"}}}", http.StatusBadRequest},
+		{handleAPIDiff(pl), "POST", "/api/diff", "{\"type\":\"chat\",\"text\":\"hi\"}", http.StatusBadRequest},
+		{handleAPIDiff(pl), "GET", "/api/diff", "", http.StatusMethodNotAllowed},
+		{handleAPICell(pl), "GET", "/api/cell?x=10", "", http.StatusBadRequest},
+		{handleAPICell(pl), "GET", "/api/cell?x=10&y=120", "", http.StatusBadRequest},
+		{handleAPIGrid(pl), "GET", "/api/grid?format=png", "", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		test.h(rec, httptest.NewRequest(test.method, test.target, strings.NewReader(test.body)))
+		if rec.Code != test.code {
+			t.Errorf("%v %v %v: expected status %v but got %v", test.method, test.target, test.body, test.code, rec.Code)
+		}
+	}
+}
+
+// The API should require the token if there is one, and limit the rate of
+// requests from each client.
+func Test_apiTokenAndRateLimit(t *testing.T) {
+	golChan := make(chan interface{})
+	pl := startPipelineInternal(defaultConfig(), golChan, golChan)
+	h := handleAPI("secret", newKeyedLimiter(2, time.Hour), handleAPICell(pl))
+
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest("GET", "/api/cell?x=0&y=0", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %v but got %v", http.StatusUnauthorized, rec.Code)
+	}
+	req := httptest.NewRequest("GET", "/api/cell?x=0&y=0", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec = httptest.NewRecorder()
+	h(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected status %v but got %v", http.StatusOK, rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	h(rec, req)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected status %v but got %v", http.StatusTooManyRequests, rec.Code)
+	}
+	// Other clients have their own limit.
+	req.RemoteAddr = "192.0.2.2:1234"
+	rec = httptest.NewRecorder()
+	h(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected status %v but got %v", http.StatusOK, rec.Code)
+	}
+}
@@ -333,6 +333,10 @@ function newMousePan(view) {
 // submitting the diff produced by function flush to the server.
 function newWs(processor, filledOverlayCells) {
   let websocket;
+  // Spectators can watch the board, but the server closes the connection if
+  // they submit anything.
+  const isSpectator =
+    new URLSearchParams(document.location.search).get("spectate") === "1";
   let scheme;
   if (document.location.protocol === "https:") {
     scheme = "wss";
@@ -341,7 +345,8 @@ function newWs(processor, filledOverlayCells) {
   }
 
   function connect() {
-    websocket = new WebSocket(`${scheme}://${document.location.host}`);
+    const query = isSpectator ? "/?caps=messages&spectate=1" : "/?caps=messages";
+    websocket = new WebSocket(`${scheme}://${document.location.host}${query}`);
     websocket.addEventListener("message", processor.enqueue);
   }
 
@@ -351,11 +356,12 @@ function newWs(processor, filledOverlayCells) {
   }
 
   function submit() {
-    if (filledOverlayCells.size === 0 ||
+    if (isSpectator ||
+      filledOverlayCells.size === 0 ||
       websocket === undefined ||
       websocket.readyState !== 1) {
-      // There are no changes to submit, or connect hasn't been called yet,
-      // or the connection is not in the OPEN state.
+      // We're spectating, there are no changes to submit, connect hasn't
+      // been called yet, or the connection is not in the OPEN state.
       return;
     }
     const diff = flush(filledOverlayCells);
@@ -398,10 +404,20 @@ function newProcessor() {
         dequeueIntervalID = setInterval(dequeue, dequeueInterval);
       }
       if (json.startsWith("[")) {
-        // Apply the grid message to the board immediately.
+        // Apply the grid message to the board immediately. A grid that
+        // arrives mid-stream, which the server may send when the client falls
+        // behind, supersedes any diffs still in the buffer.
+        buffer = [];
+        checkForBufferOverflow();
         update(json);
         return;
       }
+      if (json.startsWith("{\"type\"")) {
+        // Messages other than grids and diffs are handled immediately rather
+        // than buffered.
+        handleTypedMessage(json);
+        return;
+      }
       buffer.push(json);
       checkForBufferOverflow();
     });
@@ -569,6 +585,27 @@ function initVisChangeHandling(ws, balancer) {
   });
 }
 
+// handleTypedMessage handles a message that is neither a grid nor a diff.
+// These messages have a "type" field. See protocol.md.
+function handleTypedMessage(json) {
+  const message = JSON.parse(json);
+  if (message.type === "notice") {
+    showNotice(message.text);
+  }
+}
+
+// showNotice displays a notice from the server's operator for a few seconds.
+let noticeTimeoutID;
+function showNotice(text) {
+  const notice = document.getElementById("notice");
+  notice.textContent = text;
+  notice.hidden = false;
+  clearTimeout(noticeTimeoutID);
+  noticeTimeoutID = setTimeout(() => {
+    notice.hidden = true;
+  }, 10000);
+}
+
 // update applies a grid or diff to the board.
 function update(json) {
   const change = JSON.parse(json);
@@ -576,7 +613,12 @@ function update(json) {
     for (const y in change[x]) {
       const cell = document.getElementById(`${x},${y}`);
       const species = change[x][y];
-      if (species !== "") {
+      if (species.includes(":")) {
+        // A dying cell under a Generations rule is its color code followed by
+        // its state. See protocol.md.
+        cell.className = "board_cell_dying";
+        cell.style.backgroundColor = species.split(":")[0];
+      } else if (species !== "") {
         cell.className = "board_cell_filled";
         cell.style.backgroundColor = species;
       } else {
@@ -0,0 +1,255 @@
+package main
+
+import "encoding/json"
+
+// board holds the cells that gol evolves, along with the state that follows
+// them, such as ants and the population of each species. gol evolves a
+// gridBoard, or a planeBoard on the plane topology, and handles everything
+// that doesn't depend on how the cells are stored itself.
+type board interface {
+	// merge merges a change from a client, a *mergeDiff or *mergePlaneDiff,
+	// into the next generation.
+	merge(change interface{})
+	// next advances the board by one generation. It returns the message to
+	// broadcast to Listeners and the diff to record in the history, or a nil
+	// message if the board has stopped evolving.
+	next() (message interface{}, recorded diff)
+	// init encodes the message that initializes a Listener at generation
+	// gen.
+	init(li *listener, gen int) []byte
+	// catchUp encodes one diff that brings a Listener up to date from the
+	// diffs that it missed, or nil if it missed none. ok is false if the
+	// Listener must be initialized instead.
+	catchUp(li *listener, diffs []diff) (message []byte, ok bool)
+	// subscribe answers a *subscribe or *subscribeChunks message with the
+	// message that carries the subscription to hub.
+	subscribe(m interface{}) interface{}
+	// window returns a copy of the cells in the grid.
+	window() *grid
+	// get returns the species of cell (x, y), which must be in the grid.
+	get(x int64, y int64) species
+	// top returns the most populous species for the leaderboard.
+	top() []speciesCount
+	// stats returns the statistics for every player.
+	stats() []playerStats
+	// spawnAnt adds an ant, removing the oldest ant if need be.
+	spawnAnt(a *ant)
+	// clear kills every cell in the next generation.
+	clear()
+}
+
+// gridBoard is the board for every topology but the plane.
+type gridBoard struct {
+	cfg  *config
+	g    *grid
+	df   diff
+	ages *ageGrid
+	cl   *cleaner
+	rs   *ruleset
+	ants []*ant
+	at   *attribution
+	pop  population
+	// counted holds the cells counted by pop. See population.advance.
+	counted *grid
+}
+
+func newGridBoard(cfg *config) *gridBoard {
+	cl := newCleaner(cfg.cleanup, cfg.topology)
+	rs := newRuleset(cfg)
+	if cl.enabled() {
+		rs.heritable = cl.heritable
+	}
+	return &gridBoard{
+		cfg:     cfg,
+		g:       &grid{},
+		df:      make(diff),
+		ages:    &ageGrid{},
+		cl:      cl,
+		rs:      rs,
+		at:      newAttribution(),
+		pop:     make(population),
+		counted: &grid{},
+	}
+}
+
+func (b *gridBoard) merge(change interface{}) {
+	if m, ok := change.(*mergeDiff); ok {
+		merge(m.df, b.df)
+		b.at.place(m.df, m.player)
+	}
+}
+
+func (b *gridBoard) next() (interface{}, diff) {
+	moveAnts(b.ants, b.cfg.antRule, b.cfg.topology, b.g, b.df)
+	var message interface{}
+	var recorded diff
+	if len(b.df) != 0 {
+		dfCopy, antsCopy := copyDiff(b.df), copyAnts(b.ants)
+		encode := func(caps capabilities) []byte {
+			return marshalDiff(dfCopy, antsCopy, caps)
+		}
+		message = &broadcastDiff{broadcast: broadcast{encode: encode}, df: dfCopy, ants: antsCopy}
+		recorded = dfCopy
+		if b.cl.enabled() {
+			b.cl.advance(b.df)
+		}
+		advanceAges(b.ages, b.g, b.df)
+		b.at.advance(b.g, b.df, b.rs)
+		b.pop.advance(b.counted, b.df, b.cl.isFading)
+		flush(b.df, b.g)
+		nextState(b.g, b.df, b.rs)
+	} else if b.cl.enabled() {
+		// The grid has stopped evolving, but we keep counting generations
+		// so that debris is eventually removed.
+		b.cl.advance(b.df)
+	}
+	if b.cl.enabled() {
+		b.cl.sweep(b.g, b.df)
+	}
+	// Note: Using len(diff) to determine whether the grid has stopped
+	// evolving hinges on the assumption that a diff never contains a change
+	// that would leave a cell as it is. clearGrid upholds this by removing
+	// changes to dead cells rather than setting them to "".
+	return message, recorded
+}
+
+func (b *gridBoard) init(li *listener, gen int) []byte {
+	return marshalInit(b.g, b.ages, gen, b.cfg, li.caps)
+}
+
+func (b *gridBoard) catchUp(li *listener, diffs []diff) ([]byte, bool) {
+	// The catch-up diff has age 0 for every live cell, which would be wrong
+	// for clients with the ages capability.
+	if li.caps.ages {
+		return nil, false
+	}
+	if len(diffs) == 0 {
+		return nil, true
+	}
+	caughtUp := make(diff)
+	for _, df := range diffs {
+		merge(df, caughtUp)
+	}
+	return marshalCoalescedDiff(caughtUp, copyAnts(b.ants), li.caps, len(diffs)), true
+}
+
+func (b *gridBoard) subscribe(m interface{}) interface{} {
+	sub := m.(*subscribe)
+	gridCopy, agesCopy := *b.g, *b.ages
+	return &subscribed{sub.li, sub.region, &gridCopy, &agesCopy}
+}
+
+func (b *gridBoard) window() *grid {
+	gridCopy := *b.g
+	return &gridCopy
+}
+
+func (b *gridBoard) get(x int64, y int64) species {
+	return b.g[x][y]
+}
+
+func (b *gridBoard) top() []speciesCount {
+	return b.pop.top(leaderboardLen)
+}
+
+func (b *gridBoard) stats() []playerStats {
+	return b.at.stats(b.counted)
+}
+
+func (b *gridBoard) spawnAnt(a *ant) {
+	if b.cfg.maxAnts == 0 {
+		return
+	}
+	if len(b.ants) == b.cfg.maxAnts {
+		// Make room by removing the oldest ant.
+		b.ants = b.ants[1:]
+	}
+	b.ants = append(b.ants, a)
+}
+
+func (b *gridBoard) clear() {
+	clearAll(b.g, b.df)
+	b.ants = nil
+}
+
+// planeBoard is the board for the plane topology. It evolves a world rather
+// than a grid. Features that depend on the grid's fixed size, namely ants,
+// debris cleanup, cell ages, and player statistics, are refused before they
+// reach it. See config.validate and decodePlaneMessage.
+type planeBoard struct {
+	w   *world
+	df  planeDiff
+	rs  *ruleset
+	cfg *config
+	// pop counts the live cells of each species on the plane.
+	pop population
+}
+
+func newPlaneBoard(cfg *config) *planeBoard {
+	return &planeBoard{newWorld(), make(planeDiff), newRuleset(cfg), cfg, make(population)}
+}
+
+func (b *planeBoard) merge(change interface{}) {
+	switch m := change.(type) {
+	case *mergePlaneDiff:
+		mergePlane(m.df, b.df)
+	case *mergeDiff:
+		// Imports arrive as diffs of the grid.
+		mergePlane(planeDiffOf(m.df), b.df)
+	}
+}
+
+func (b *planeBoard) next() (interface{}, diff) {
+	limitChunks(b.w, b.df)
+	if len(b.df) == 0 {
+		return nil, nil
+	}
+	message := &broadcastPlaneDiff{df: copyPlaneDiff(b.df)}
+	recorded := windowDiff(b.df)
+	b.pop.advancePlane(b.w, b.df)
+	flushPlane(b.df, b.w)
+	nextPlaneState(b.w, b.df, b.rs)
+	return message, recorded
+}
+
+func (b *planeBoard) init(li *listener, gen int) []byte {
+	message, _ := json.Marshal(&initMessage{"init", nil, gen, newWorldInfo(b.cfg)})
+	return message
+}
+
+func (b *planeBoard) catchUp(li *listener, diffs []diff) ([]byte, bool) {
+	// A resumed client has yet to subscribe to any chunks, so it is sent the
+	// init message, after which it subscribes again.
+	return nil, false
+}
+
+func (b *planeBoard) subscribe(m interface{}) interface{} {
+	sub := m.(*subscribeChunks)
+	var cells planeDiff
+	if sub.cr != nil {
+		cells = b.w.cellsIn(*sub.cr)
+	}
+	return &subscribedChunks{sub.li, sub.cr, cells}
+}
+
+func (b *planeBoard) window() *grid {
+	return b.w.window()
+}
+
+func (b *planeBoard) get(x int64, y int64) species {
+	return b.w.get(x, y)
+}
+
+func (b *planeBoard) top() []speciesCount {
+	return b.pop.top(leaderboardLen)
+}
+
+func (b *planeBoard) stats() []playerStats {
+	return []playerStats{}
+}
+
+func (b *planeBoard) spawnAnt(a *ant) {}
+
+func (b *planeBoard) clear() {
+	clearPlane(b.w, b.df)
+}
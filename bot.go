@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// botStrategy is how a bot chooses what to place on the grid.
+type botStrategy string
+
+const (
+	// soupBot scatters a random soup of cells over an empty part of the
+	// grid.
+	soupBot botStrategy = "soup"
+	// gunBot stamps a Gosper glider gun, which shoots a glider every 30
+	// generations, onto an empty part of the grid.
+	gunBot botStrategy = "glider"
+	// blockBot places blocks near the bot's own cells, where they stop
+	// incoming gliders and other debris.
+	blockBot botStrategy = "blocks"
+)
+
+var botStrategies = []botStrategy{soupBot, gunBot, blockBot}
+
+const (
+	// defaultBotInterval is the default value of botConfig.interval.
+	defaultBotInterval = 10 * time.Second
+	// soupSize is the width and height of the square that soupBot fills.
+	soupSize = 16
+	// botAttempts is the number of places that a bot tries before giving up
+	// on a move because the grid is too crowded.
+	botAttempts = 10
+	// blockDistance is the distance from a bot's cells at which blockBot
+	// places blocks.
+	blockDistance = 5
+)
+
+// botConfig configures a bot, which is a player run by the server so that
+// there is something to watch when few humans are playing.
+type botConfig struct {
+	strategy botStrategy
+	species  species
+	// interval is the time between the bot's moves.
+	interval time.Duration
+	// maxHumans causes the bot to move only while fewer than maxHumans
+	// players are connected. If it is 0, the bot always moves.
+	maxHumans int
+}
+
+// parseBot parses a bot description of the form strategy[:key=value,...],
+// e.g. "glider:species=#ff0000,every=30s,humans=2". The keys are species,
+// every (the interval between moves), and humans (see botConfig.maxHumans).
+// The species defaults to a random color.
+func parseBot(desc string) (botConfig, error) {
+	name, options, hasOptions := strings.Cut(desc, ":")
+	b := botConfig{interval: defaultBotInterval}
+	for _, s := range botStrategies {
+		if string(s) == name {
+			b.strategy = s
+		}
+	}
+	if b.strategy == "" {
+		return botConfig{}, fmt.Errorf("unknown bot strategy (%v)", name)
+	}
+	if hasOptions {
+		for _, option := range strings.Split(options, ",") {
+			key, value, _ := strings.Cut(option, "=")
+			switch key {
+			case "species":
+				if !hexColorCode.MatchString(value) {
+					return botConfig{}, fmt.Errorf("bot species is not a hexadecimal color code (%v)", value)
+				}
+				b.species = value
+			case "every":
+				d, err := time.ParseDuration(value)
+				if err != nil || d <= 0 {
+					return botConfig{}, fmt.Errorf("malformed bot interval (%v)", value)
+				}
+				b.interval = d
+			case "humans":
+				n, err := strconv.Atoi(value)
+				if err != nil || n < 0 {
+					return botConfig{}, fmt.Errorf("malformed bot human limit (%v)", value)
+				}
+				b.maxHumans = n
+			default:
+				return botConfig{}, fmt.Errorf("unknown bot option (%v)", key)
+			}
+		}
+	}
+	if b.species == "" {
+		b.species = fmt.Sprintf("#%06x", rand.Intn(1<<24))
+	}
+	return b, nil
+}
+
+// botFlag is a flag.Value that collects a botConfig for each use of the flag.
+type botFlag []botConfig
+
+func (f *botFlag) String() string {
+	return fmt.Sprintf("%d bots", len(*f))
+}
+
+func (f *botFlag) Set(desc string) error {
+	b, err := parseBot(desc)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, b)
+	return nil
+}
+
+// startBots runs each of the config's bots in its own goroutine, as player
+// "bot-1", "bot-2", and so on.
+func startBots(pl *pipeline) {
+	for i, b := range pl.cfg.bots {
+		player := playerID(fmt.Sprintf("bot-%d", i+1))
+		rng := rand.New(rand.NewSource(time.Now().UnixNano() + int64(i)))
+		go runBot(pl, b, player, rng, time.NewTicker(b.interval).C)
+	}
+}
+
+// registerBot registers a Listener for a bot with hub and asks gol to send it
+// the grid, as attachConn does for a connection.
+func registerBot(pl *pipeline) *listener {
+	li := &listener{make(chan *lazyMessage, pl.cfg.sendBufferLen), newErrorSignal(), capabilities{}}
+	pl.hubChan <- &register{li}
+	pl.golChan <- &initListener{li}
+	return li
+}
+
+// runBot runs a loop that keeps a bot's copy of the grid up-to-date and makes
+// a move whenever it receives from moves. The bot is a Listener like any
+// connection, and its moves go to gol via pl.readPumpOut as diffs from player.
+// If hub drops the bot for falling behind, the bot registers again.
+func runBot(pl *pipeline, b botConfig, player playerID, rng *rand.Rand, moves <-chan time.Time) {
+	g := &grid{}
+	li := registerBot(pl)
+	for {
+		select {
+		case <-li.errSig.signal():
+			li = registerBot(pl)
+		case message := <-li.sendChan:
+			updateBotGrid(g, message.bytes())
+		case <-moves:
+			if b.maxHumans > 0 && pl.conns.snapshot().Players >= b.maxHumans {
+				continue
+			}
+			if df := b.move(g, rng, pl.cfg); len(df) != 0 {
+				pl.readPumpOut <- &mergeDiff{df, player}
+			}
+		}
+	}
+}
+
+// updateBotGrid applies a message sent to a bot to its copy of the grid. Bots
+// receive messages in version 1 of the protocol, and ignore every message but
+// grids and diffs.
+func updateBotGrid(g *grid, message []byte) {
+	if len(message) == 0 || string(message) == "{}" {
+		return
+	}
+	if message[0] == '[' {
+		json.Unmarshal(message, g)
+		return
+	}
+	if strings.HasPrefix(string(message), "{\"type\"") {
+		return
+	}
+	df := make(diff)
+	if err := json.Unmarshal(message, &df); err == nil {
+		apply(df, g)
+	}
+}
+
+// move returns the diff that a bot places on the grid next, which is empty if
+// the bot can't find room.
+func (b *botConfig) move(g *grid, rng *rand.Rand, cfg *config) diff {
+	switch b.strategy {
+	case soupBot:
+		return b.soup(g, rng, cfg.topology)
+	case gunBot:
+		return b.gun(g, rng, cfg)
+	case blockBot:
+		return b.blocks(g, rng, cfg.topology)
+	}
+	return nil
+}
+
+// isClear reports whether every cell in a rectangle of the grid, with its
+// top-left corner at (x, y), is dead. Cells beyond the edges of a bounded grid
+// count as dead.
+func isClear(g *grid, x int, y int, width int, height int, topo topology) bool {
+	for i := 0; i < height; i++ {
+		for j := 0; j < width; j++ {
+			if q, ok := topo.wrap(x+i, y+j); ok && g[q.x][q.y] != "" {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// soup fills about half of the cells in an empty square of the grid.
+func (b *botConfig) soup(g *grid, rng *rand.Rand, topo topology) diff {
+	for attempt := 0; attempt < botAttempts; attempt++ {
+		x, y := rng.Intn(gridDimX), rng.Intn(gridDimY)
+		if !isClear(g, x, y, soupSize, soupSize, topo) {
+			continue
+		}
+		df := make(diff)
+		for i := 0; i < soupSize; i++ {
+			for j := 0; j < soupSize; j++ {
+				if q, ok := topo.wrap(x+i, y+j); ok && rng.Intn(2) == 0 {
+					getOrMakeYDiff(df, q.x)[q.y] = b.species
+				}
+			}
+		}
+		return df
+	}
+	return nil
+}
+
+// gun stamps a glider gun, in a random orientation, onto an empty part of the
+// grid with a margin around it.
+func (b *botConfig) gun(g *grid, rng *rand.Rand, cfg *config) diff {
+	const name, margin = "gosper-glider-gun", 2
+	p, ok := cfg.patterns[name]
+	if !ok {
+		return nil
+	}
+	for attempt := 0; attempt < botAttempts; attempt++ {
+		st := &stamp{
+			Name:    name,
+			X:       int64(rng.Intn(gridDimX)),
+			Y:       int64(rng.Intn(gridDimY)),
+			Rotate:  90 * rng.Intn(4),
+			Reflect: rng.Intn(2) == 0,
+			Species: b.species,
+		}
+		width, height := p.width, p.height
+		if st.Rotate == 90 || st.Rotate == 270 {
+			width, height = height, width
+		}
+		if isClear(g, int(st.X)-margin, int(st.Y)-margin, width+2*margin, height+2*margin, cfg.topology) {
+			return expandStamp(st, cfg.patterns, cfg.topology)
+		}
+	}
+	return nil
+}
+
+// blocks places a block, a still life of four cells, blockDistance cells from
+// one of the bot's live cells, or anywhere if the bot has none.
+func (b *botConfig) blocks(g *grid, rng *rand.Rand, topo topology) diff {
+	var own []point
+	for x := range g {
+		for y, s := range g[x] {
+			if s == b.species {
+				own = append(own, point{x, y})
+			}
+		}
+	}
+	for attempt := 0; attempt < botAttempts; attempt++ {
+		var x, y int
+		if len(own) == 0 {
+			x, y = rng.Intn(gridDimX), rng.Intn(gridDimY)
+		} else {
+			p := own[rng.Intn(len(own))]
+			x = p.x + (rng.Intn(3)-1)*blockDistance
+			y = p.y + (rng.Intn(3)-1)*blockDistance
+		}
+		// Leave a ring of dead cells around the block, so that it starts
+		// out as a still life.
+		if !isClear(g, x-1, y-1, 4, 4, topo) {
+			continue
+		}
+		df := make(diff)
+		for i := 0; i < 2; i++ {
+			for j := 0; j < 2; j++ {
+				if q, ok := topo.wrap(x+i, y+j); ok {
+					getOrMakeYDiff(df, q.x)[q.y] = b.species
+				}
+			}
+		}
+		return df
+	}
+	return nil
+}
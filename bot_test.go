@@ -0,0 +1,122 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func Test_parseBot(t *testing.T) {
+	b, err := parseBot("glider:species=#ff0000,every=30s,humans=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := botConfig{gunBot, "#ff0000", 30 * time.Second, 2}
+	if b != want {
+		t.Errorf("Expected %+v but got %+v", want, b)
+	}
+	b, err = parseBot("soup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.strategy != soupBot || b.interval != defaultBotInterval || b.maxHumans != 0 || !hexColorCode.MatchString(b.species) {
+		t.Errorf("Got incorrect defaults: %+v", b)
+	}
+	for _, desc := range []string{"", "nonexistent", "soup:", "soup:species=red", "soup:every=0s", "soup:humans=-1", "soup:color=#ff0000"} {
+		if _, err := parseBot(desc); err == nil {
+			t.Errorf("Expected an error for %q", desc)
+		}
+	}
+}
+
+// Each strategy should place the bot's species on an empty grid.
+func Test_botMove(t *testing.T) {
+	cfg := defaultConfig()
+	for _, s := range botStrategies {
+		b := &botConfig{strategy: s, species: "#aaaaaa"}
+		df := b.move(&grid{}, rand.New(rand.NewSource(1)), cfg)
+		if len(df) == 0 {
+			t.Errorf("Expected the %v bot to move", s)
+		}
+		for _, ydiff := range df {
+			for _, v := range ydiff {
+				if v != b.species {
+					t.Errorf("Expected the %v bot to place only its species but got %v", s, v)
+				}
+			}
+		}
+	}
+}
+
+// blockBot should place blocks near its own cells, clear of other cells.
+func Test_botBlocks(t *testing.T) {
+	g := &grid{}
+	g[50][50] = "#aaaaaa"
+	b := &botConfig{strategy: blockBot, species: "#aaaaaa"}
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 10; i++ {
+		df := b.blocks(g, rng, torus)
+		count := 0
+		for x, ydiff := range df {
+			for y := range ydiff {
+				count++
+				if x < 50-blockDistance || x > 51+blockDistance || y < 50-blockDistance || y > 51+blockDistance {
+					t.Errorf("Expected a block near (50, 50) but got a cell at (%v, %v)", x, y)
+				}
+			}
+		}
+		if count != 4 {
+			t.Errorf("Expected a block but got %v", df)
+		}
+	}
+	// There is no room on a full grid.
+	for x := range g {
+		for y := range g[x] {
+			g[x][y] = "#bbbbbb"
+		}
+	}
+	if df := b.blocks(g, rng, torus); len(df) != 0 {
+		t.Errorf("Expected no move on a full grid but got %v", df)
+	}
+}
+
+func Test_updateBotGrid(t *testing.T) {
+	g := &grid{}
+	updateBotGrid(g, []byte("{\"10\":{\"20\":\"#aaaaaa\"}}"))
+	updateBotGrid(g, []byte("{}"))
+	updateBotGrid(g, []byte("{\"type\":\"chat\",\"text\":\"hi\"}"))
+	if g[10][20] != "#aaaaaa" {
+		t.Errorf("Expected the diff to be applied")
+	}
+	updateBotGrid(g, marshalInit(&grid{}, nil, 0, defaultConfig(), capabilities{}))
+	if g[10][20] != "" {
+		t.Errorf("Expected the grid to be replaced")
+	}
+}
+
+// A bot should send its moves to gol as diffs from its player, except while
+// too many humans are connected.
+func Test_runBot(t *testing.T) {
+	readPumpOut := make(chan interface{})
+	golChan := make(chan interface{})
+	pl := startPipelineInternal(defaultConfig(), readPumpOut, golChan)
+	moves := make(chan time.Time)
+	b := botConfig{strategy: soupBot, species: "#aaaaaa", maxHumans: 1}
+	go runBot(pl, b, "bot-1", rand.New(rand.NewSource(1)), moves)
+
+	send(t, moves, time.Time{})
+	m, ok := recv(t, readPumpOut).(*mergeDiff)
+	if !ok || m.player != "bot-1" || len(m.df) == 0 {
+		t.Fatalf("Expected a diff from the bot but got %+v", m)
+	}
+
+	pl.conns.acquire(false)
+	// The second move can only be received if the first one sent nothing.
+	send(t, moves, time.Time{})
+	send(t, moves, time.Time{})
+	select {
+	case m := <-readPumpOut:
+		t.Errorf("Expected the bot not to move but got %+v", m)
+	default:
+	}
+}
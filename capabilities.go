@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// capabilities holds the optional protocol features that a client has asked
+// for. Clients that don't ask for any features receive messages in the
+// original format. See protocol.md.
+type capabilities struct {
+	// ages causes each live cell in grids and diffs to be sent along with its
+	// age.
+	ages bool
+	// ants causes the positions of ants to be sent along with each diff.
+	ants bool
+	// world causes a description of the world, including whether the grid
+	// is hexagonal, to be sent immediately after the grid.
+	world bool
+	// messages causes typed messages that the client didn't ask for, such as
+	// notices and chat messages, to be sent to a version 1 client. See
+	// acceptsTyped.
+	messages bool
+	// version is the protocol version, which is negotiated with a WebSocket
+	// subprotocol rather than asked for by name.
+	version protocolVersion
+	// spectator causes the connection to be read-only: the client can watch
+	// the game, but can't send any messages. It is asked for with a query
+	// parameter rather than by name.
+	spectator bool
+}
+
+// diffFormat returns only the capabilities that affect how diffs are
+// encoded. A broadcast is encoded once for each diff format.
+func (c capabilities) diffFormat() capabilities {
+	return capabilities{ages: c.ages, ants: c.ants, version: c.version}
+}
+
+// acceptsTyped reports whether a client can be sent typed messages that it
+// didn't ask for, such as notices, the leaderboard, chat messages, and
+// presence snapshots. Version 1 clients must ask for them with the messages
+// capability, so that clients written before they existed aren't sent
+// messages that they don't understand.
+func (c capabilities) acceptsTyped() bool {
+	return c.version == protocolV2 || c.messages
+}
+
+// parseCapabilities parses a comma-separated list of capability names.
+// Unknown names are ignored, so that clients may ask for features that only
+// newer servers support.
+func parseCapabilities(list string) capabilities {
+	var caps capabilities
+	for _, name := range strings.Split(list, ",") {
+		switch strings.TrimSpace(name) {
+		case "ages":
+			caps.ages = true
+		case "ants":
+			caps.ants = true
+		case "world":
+			caps.world = true
+		case "messages":
+			caps.messages = true
+		}
+	}
+	return caps
+}
+
+// agedCell returns the representation of a cell when the ages capability is
+// in use: "" for a dead cell, and [species, age] for a live cell.
+func agedCell(s species, age int) interface{} {
+	if s == "" {
+		return ""
+	}
+	return []interface{}{s, age}
+}
+
+// marshalGridWithAges encodes a grid for clients with the ages capability.
+func marshalGridWithAges(g *grid, a *ageGrid) []byte {
+	cells := make([][]interface{}, gridDimX)
+	for x := range cells {
+		cells[x] = make([]interface{}, gridDimY)
+		for y := range cells[x] {
+			cells[x][y] = agedCell(g[x][y], a[x][y])
+		}
+	}
+	message, _ := json.Marshal(cells)
+	return message
+}
+
+// marshalDiff encodes a diff, along with the ants that are on the grid, for
+// clients with the given capabilities. Every cell in a diff was just born,
+// changed species, or died, so every live cell in the diff has age 0. Version
+// 2 clients receive the cells in a diffMessage.
+func marshalDiff(df diff, ants []ant, caps capabilities) []byte {
+	return marshalCoalescedDiff(df, ants, caps, 1)
+}
+
+// marshalCoalescedDiff is marshalDiff for a diff that spans the given number
+// of generations, which only version 2 clients are told. Every live cell still
+// has age 0, so clients with the ages capability are sent a fresh grid rather
+// than a diff that spans more than one generation.
+func marshalCoalescedDiff(df diff, ants []ant, caps capabilities, generations int) []byte {
+	cells := make(map[string]interface{}, len(df)+1)
+	for x, ydiff := range df {
+		row := make(map[int]interface{}, len(ydiff))
+		for y, s := range ydiff {
+			if caps.ages {
+				row[y] = agedCell(s, 0)
+			} else {
+				row[y] = s
+			}
+		}
+		cells[strconv.Itoa(x)] = row
+	}
+	var antsField interface{}
+	if caps.ants {
+		if ants == nil {
+			ants = []ant{}
+		}
+		antsField = ants
+	}
+	if caps.version == protocolV2 {
+		if generations == 1 {
+			// Leave generations out of ordinary diffs.
+			generations = 0
+		}
+		message, _ := json.Marshal(&diffMessage{"diff", cells, antsField, generations})
+		return message
+	}
+	if antsField != nil {
+		cells["ants"] = antsField
+	}
+	message, _ := json.Marshal(cells)
+	return message
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// maxChatLen is the maximum length of a chat message in characters.
+	maxChatLen = 280
+	// chatHistoryLen is the number of recent chat messages sent to new
+	// listeners.
+	chatHistoryLen = 50
+	// chatBurst is the number of chat messages that a connection can send in
+	// quick succession.
+	chatBurst = 5
+	// chatRefill is the time it takes a connection to earn another chat
+	// message after using up its burst.
+	chatRefill = 2 * time.Second
+)
+
+// chat is a chat message from a player, sent by readPump to hub for
+// broadcast.
+type chat struct {
+	player playerID
+	text   string
+}
+
+// chatMessage is the JSON representation of a chat message sent to clients.
+type chatMessage struct {
+	Type   string    `json:"type"`
+	Player playerID  `json:"player"`
+	Text   string    `json:"text"`
+	Time   time.Time `json:"time"`
+}
+
+// validateChat checks the text of a chat message, returning it without
+// leading and trailing whitespace.
+func validateChat(text string) (string, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return "", errors.New("chat message is empty")
+	}
+	if !utf8.ValidString(text) {
+		return "", errors.New("chat message is not valid UTF-8")
+	}
+	if utf8.RuneCountInString(text) > maxChatLen {
+		return "", errors.New("chat message is too long")
+	}
+	return text, nil
+}
+
+// newChatLimiter returns a tokenBucket that limits the rate at which a
+// connection can send chat messages.
+func newChatLimiter() *tokenBucket {
+	return newTokenBucket(chatBurst, chatRefill)
+}
+
+// chatRoom is the state that hub keeps for chat.
+type chatRoom struct {
+	// recent is a ring buffer of the most recent chat messages, encoded for
+	// sending. next is the index at which the next message will be stored.
+	recent [chatHistoryLen][]byte
+	next   int
+	// ready holds the listeners that have been sent their initialization
+	// data and accept typed messages, and so can be sent chat messages and
+	// presence snapshots.
+	ready map[*listener]bool
+}
+
+func newChatRoom() *chatRoom {
+	return &chatRoom{ready: make(map[*listener]bool)}
+}
+
+// post encodes a chat message and adds it to the recent messages, returning
+// the encoded message.
+func (room *chatRoom) post(c *chat, now time.Time) []byte {
+	message, _ := json.Marshal(&chatMessage{"chat", c.player, c.text, now})
+	room.recent[room.next] = message
+	room.next = (room.next + 1) % chatHistoryLen
+	return message
+}
+
+// history returns the recent messages, oldest first.
+func (room *chatRoom) history() [][]byte {
+	var messages [][]byte
+	for i := 0; i < chatHistoryLen; i++ {
+		if m := room.recent[(room.next+i)%chatHistoryLen]; m != nil {
+			messages = append(messages, m)
+		}
+	}
+	return messages
+}
+
+// initialized tells hub that gol has sent initialization data to a listener,
+// so hub may now send its own data, such as recent chat messages.
+type initialized struct {
+	li *listener
+}
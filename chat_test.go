@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_validateChat(t *testing.T) {
+	if text, err := validateChat("  hello  "); err != nil || text != "hello" {
+		t.Errorf("Expected \"hello\" but got %q, %v", text, err)
+	}
+	if _, err := validateChat(strings.Repeat("é", maxChatLen)); err != nil {
+		t.Errorf("Expected a message of maxChatLen characters to be valid but got %v", err)
+	}
+	for _, text := range []string{"", "   ", strings.Repeat("a", maxChatLen+1), "\xff"} {
+		if _, err := validateChat(text); err == nil {
+			t.Errorf("Expected %q to be rejected", text)
+		}
+	}
+}
+
+func Test_chatLimiter(t *testing.T) {
+	l := newChatLimiter()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < chatBurst; i++ {
+		if !l.allow(now) {
+			t.Errorf("Expected message %v of the burst to be allowed", i)
+		}
+	}
+	if l.allow(now) {
+		t.Errorf("Expected a message beyond the burst to be rejected")
+	}
+	now = now.Add(chatRefill)
+	if !l.allow(now) {
+		t.Errorf("Expected a message to be allowed after chatRefill")
+	}
+	if l.allow(now) {
+		t.Errorf("Expected only one message to be allowed after chatRefill")
+	}
+}
+
+func Test_chatRoomHistory(t *testing.T) {
+	room := newChatRoom()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if len(room.history()) != 0 {
+		t.Errorf("Expected no history")
+	}
+	for i := 0; i < chatHistoryLen+2; i++ {
+		room.post(&chat{"p", strings.Repeat("a", i+1)}, now)
+	}
+	h := room.history()
+	if len(h) != chatHistoryLen {
+		t.Fatalf("Expected %v messages but got %v", chatHistoryLen, len(h))
+	}
+	// The two oldest messages have been overwritten.
+	if !strings.Contains(string(h[0]), "\"text\":\"aaa\"") {
+		t.Errorf("Got incorrect oldest message: %s", h[0])
+	}
+	if !strings.Contains(string(h[len(h)-1]), strings.Repeat("a", chatHistoryLen+2)) {
+		t.Errorf("Got incorrect newest message: %s", h[len(h)-1])
+	}
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"sort"
+)
+
+const (
+	// chunkShift is the base 2 logarithm of chunkSize.
+	chunkShift = 6
+	// chunkSize is the width and height of a chunk in cells.
+	chunkSize = 1 << chunkShift
+	// maxSubscribedChunks is the largest number of chunks that a client may
+	// subscribe to at once.
+	maxSubscribedChunks = 64
+	// maxDiffChunks is the largest number of chunks that a client's diff may
+	// set cells in. It is enough for a stamp of the largest pattern, which
+	// spans one more chunk than it fills in each direction.
+	maxDiffChunks = (maxPatternDim/chunkSize + 1) * (maxPatternDim/chunkSize + 1)
+	// maxWorldChunks is the largest number of chunks that the world may hold.
+	maxWorldChunks = 1024
+)
+
+// point64 is the position of a cell on the plane. As on the grid, x selects a
+// row and y selects a column.
+type point64 struct {
+	x int64
+	y int64
+}
+
+// chunkKey identifies a chunk: chunk (x, y) holds the cells from
+// (x*chunkSize, y*chunkSize) up to, but not including,
+// ((x+1)*chunkSize, (y+1)*chunkSize).
+type chunkKey struct {
+	x int64
+	y int64
+}
+
+// chunk is a square of cells on the plane.
+type chunk struct {
+	cells [chunkSize][chunkSize]species
+	// occupied is the number of cells that aren't dead.
+	occupied int
+}
+
+// world is the unbounded plane used by the plane topology. Only the chunks
+// that hold a live or dying cell are stored: a chunk is allocated when one of
+// its cells is set, and freed when all of its cells are dead again.
+type world struct {
+	chunks map[chunkKey]*chunk
+}
+
+func newWorld() *world {
+	return &world{chunks: make(map[chunkKey]*chunk)}
+}
+
+// locate returns the chunk that holds cell (x, y), along with the position of
+// the cell within the chunk.
+func locate(x int64, y int64) (chunkKey, int, int) {
+	// Shifting rounds toward negative infinity, so cells at negative
+	// coordinates land in the right chunk.
+	return chunkKey{x >> chunkShift, y >> chunkShift},
+		int(x & (chunkSize - 1)), int(y & (chunkSize - 1))
+}
+
+func (w *world) get(x int64, y int64) species {
+	k, cx, cy := locate(x, y)
+	if c, ok := w.chunks[k]; ok {
+		return c.cells[cx][cy]
+	}
+	return ""
+}
+
+func (w *world) set(x int64, y int64, s species) {
+	k, cx, cy := locate(x, y)
+	c, ok := w.chunks[k]
+	if !ok {
+		if s == "" {
+			return
+		}
+		c = &chunk{}
+		w.chunks[k] = c
+	}
+	if old := c.cells[cx][cy]; old == "" && s != "" {
+		c.occupied++
+	} else if old != "" && s == "" {
+		c.occupied--
+	}
+	c.cells[cx][cy] = s
+	if c.occupied == 0 {
+		delete(w.chunks, k)
+	}
+}
+
+// forEach calls f for each cell that isn't dead.
+func (w *world) forEach(f func(x int64, y int64, s species)) {
+	for k, c := range w.chunks {
+		for cx := 0; cx < chunkSize; cx++ {
+			for cy := 0; cy < chunkSize; cy++ {
+				if s := c.cells[cx][cy]; s != "" {
+					f(k.x<<chunkShift+int64(cx), k.y<<chunkShift+int64(cy), s)
+				}
+			}
+		}
+	}
+}
+
+// window returns the part of the plane that starts at the origin, as a grid,
+// for code that works on the grid.
+func (w *world) window() *grid {
+	g := &grid{}
+	for x := 0; x < gridDimX; x++ {
+		for y := 0; y < gridDimY; y++ {
+			g[x][y] = w.get(int64(x), int64(y))
+		}
+	}
+	return g
+}
+
+// planeDiff is a diff of the plane.
+type planeDiff = map[int64]map[int64]species
+
+func getOrMakePlaneYDiff(df planeDiff, x int64) map[int64]species {
+	ydiff, ok := df[x]
+	if !ok {
+		ydiff = make(map[int64]species)
+		df[x] = ydiff
+	}
+	return ydiff
+}
+
+// mergePlane copies a new diff into an existing diff.
+func mergePlane(newDiff planeDiff, df planeDiff) {
+	for x, newYDiff := range newDiff {
+		ydiff := getOrMakePlaneYDiff(df, x)
+		for y, v := range newYDiff {
+			ydiff[y] = v
+		}
+	}
+}
+
+// copyPlaneDiff returns a deep copy of a diff.
+func copyPlaneDiff(df planeDiff) planeDiff {
+	c := make(planeDiff, len(df))
+	mergePlane(df, c)
+	return c
+}
+
+// planeDiffOf converts a diff of the grid into a diff of the part of the plane
+// that starts at the origin.
+func planeDiffOf(df diff) planeDiff {
+	c := make(planeDiff, len(df))
+	for x, ydiff := range df {
+		for y, v := range ydiff {
+			getOrMakePlaneYDiff(c, int64(x))[int64(y)] = v
+		}
+	}
+	return c
+}
+
+// limitChunks removes the cells from a diff that would allocate chunks beyond
+// maxWorldChunks if the diff were applied to the world, so that the world
+// can't grow without bound. It doesn't count chunks that the diff frees.
+func limitChunks(w *world, df planeDiff) {
+	added := make(map[chunkKey]bool)
+	for x, ydiff := range df {
+		for y, v := range ydiff {
+			k, _, _ := locate(x, y)
+			if _, ok := w.chunks[k]; ok || added[k] || v == "" {
+				continue
+			}
+			if len(w.chunks)+len(added) >= maxWorldChunks {
+				delete(ydiff, y)
+				continue
+			}
+			added[k] = true
+		}
+		if len(ydiff) == 0 {
+			delete(df, x)
+		}
+	}
+}
+
+// flushPlane copies a diff into the world and empties the diff.
+func flushPlane(df planeDiff, w *world) {
+	for x, ydiff := range df {
+		for y, v := range ydiff {
+			w.set(x, y, v)
+		}
+		delete(df, x)
+	}
+}
+
+// clearPlane modifies a diff so that applying it to the world kills every
+// cell.
+func clearPlane(w *world, df planeDiff) {
+	for x := range df {
+		delete(df, x)
+	}
+	w.forEach(func(x int64, y int64, s species) {
+		getOrMakePlaneYDiff(df, x)[y] = ""
+	})
+}
+
+// validatePlaneDiff is the counterpart of validateDiff for the plane, where
+// any 64-bit coordinates are allowed, as long as the diff spans no more than
+// maxDiffChunks chunks.
+func validatePlaneDiff(df planeDiff) error {
+	if len(df) == 0 {
+		return errors.New("diff is empty")
+	}
+	chunks := make(map[chunkKey]bool)
+	for x, ydiff := range df {
+		if len(ydiff) == 0 {
+			return errors.New("diff includes an X coordinate with no Y coordinate")
+		}
+		for y, v := range ydiff {
+			if err := validateCell(v); err != nil {
+				return err
+			}
+			k, _, _ := locate(x, y)
+			chunks[k] = true
+		}
+	}
+	if len(chunks) > maxDiffChunks {
+		return errors.New("diff spans too many chunks")
+	}
+	return nil
+}
+
+// planeNeighbors is the counterpart of neighbors for the plane.
+func planeNeighbors(w *world, x int64, y int64, rs *ruleset) (int, species, []species) {
+	var live []species
+	for _, o := range rs.neighborhood.offsets {
+		if s := w.get(x+int64(o.x), y+int64(o.y)); isLive(s) {
+			live = append(live, s)
+		}
+	}
+	return len(live), mostPopulous(live, rs.rng), live
+}
+
+// nextPlaneState is the counterpart of nextState for the plane. Rather than
+// visiting every cell, it visits only the cells that can change: those that
+// aren't dead, and those that have a live neighbor, since rules can't give
+// birth to a cell with no live neighbors. It visits them in order so that a
+// seeded rng gives deterministic results.
+func nextPlaneState(w *world, df planeDiff, rs *ruleset) {
+	candidates := make(map[point64]bool)
+	w.forEach(func(x int64, y int64, s species) {
+		candidates[point64{x, y}] = true
+		if !isLive(s) {
+			return
+		}
+		// The cells that count (x, y) as a neighbor.
+		for _, o := range rs.neighborhood.offsets {
+			candidates[point64{x - int64(o.x), y - int64(o.y)}] = true
+		}
+	})
+	sorted := make([]point64, 0, len(candidates))
+	for p := range candidates {
+		sorted = append(sorted, p)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].x != sorted[j].x {
+			return sorted[i].x < sorted[j].x
+		}
+		return sorted[i].y < sorted[j].y
+	})
+	for _, p := range sorted {
+		n, sMax, parents := planeNeighbors(w, p.x, p.y, rs)
+		if next, ok := transition(w.get(p.x, p.y), n, sMax, parents, rs); ok {
+			getOrMakePlaneYDiff(df, p.x)[p.y] = next
+		}
+	}
+}
+
+// chunkRange is a rectangle of chunks, which a client subscribes to on the
+// plane. It spans height chunks along X and width chunks along Y, starting at
+// chunk (x, y).
+type chunkRange struct {
+	x      int64
+	y      int64
+	width  int64
+	height int64
+}
+
+// coveringChunks returns the smallest chunkRange that covers a rectangle of
+// cells with its first cell at (x, y).
+func coveringChunks(x int64, y int64, width int64, height int64) (chunkRange, error) {
+	if width <= 0 || height <= 0 {
+		return chunkRange{}, errors.New("viewport is empty")
+	}
+	if width > maxSubscribedChunks*chunkSize || height > maxSubscribedChunks*chunkSize ||
+		x > math.MaxInt64-height+1 || y > math.MaxInt64-width+1 {
+		return chunkRange{}, errors.New("viewport covers too many chunks")
+	}
+	first, _, _ := locate(x, y)
+	last, _, _ := locate(x+height-1, y+width-1)
+	cr := chunkRange{first.x, first.y, last.y - first.y + 1, last.x - first.x + 1}
+	if cr.width*cr.height > maxSubscribedChunks {
+		return chunkRange{}, errors.New("viewport covers too many chunks")
+	}
+	return cr, nil
+}
+
+func (cr chunkRange) contains(k chunkKey) bool {
+	return k.x >= cr.x && k.x < cr.x+cr.height && k.y >= cr.y && k.y < cr.y+cr.width
+}
+
+// cells returns the rectangle of cells covered by the chunkRange.
+func (cr chunkRange) cells() viewportJSON {
+	return viewportJSON{
+		X:      cr.x << chunkShift,
+		Y:      cr.y << chunkShift,
+		Width:  cr.width << chunkShift,
+		Height: cr.height << chunkShift,
+	}
+}
+
+// cellsIn returns the cells in a chunkRange that aren't dead.
+func (w *world) cellsIn(cr chunkRange) planeDiff {
+	df := make(planeDiff)
+	for k, c := range w.chunks {
+		if !cr.contains(k) {
+			continue
+		}
+		for cx := 0; cx < chunkSize; cx++ {
+			for cy := 0; cy < chunkSize; cy++ {
+				if s := c.cells[cx][cy]; s != "" {
+					getOrMakePlaneYDiff(df, k.x<<chunkShift+int64(cx))[k.y<<chunkShift+int64(cy)] = s
+				}
+			}
+		}
+	}
+	return df
+}
+
+// filterPlaneDiff returns the part of a diff that is in a chunkRange.
+func filterPlaneDiff(df planeDiff, cr chunkRange) planeDiff {
+	filtered := make(planeDiff)
+	for x, ydiff := range df {
+		for y, s := range ydiff {
+			if k, _, _ := locate(x, y); cr.contains(k) {
+				getOrMakePlaneYDiff(filtered, x)[y] = s
+			}
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_locate(t *testing.T) {
+	tests := []struct {
+		x, y   int64
+		k      chunkKey
+		cx, cy int
+	}{
+		{0, 0, chunkKey{0, 0}, 0, 0},
+		{chunkSize, chunkSize - 1, chunkKey{1, 0}, 0, chunkSize - 1},
+		{-1, -chunkSize, chunkKey{-1, -1}, chunkSize - 1, 0},
+		{-chunkSize - 1, 1 << 40, chunkKey{-2, 1 << (40 - chunkShift)}, chunkSize - 1, 0},
+	}
+	for _, test := range tests {
+		k, cx, cy := locate(test.x, test.y)
+		if k != test.k || cx != test.cx || cy != test.cy {
+			t.Errorf("Expected (%v, %v) to be at %v (%v, %v) but got %v (%v, %v)",
+				test.x, test.y, test.k, test.cx, test.cy, k, cx, cy)
+		}
+	}
+}
+
+// Chunks should be allocated when a cell in them is set, and freed when all of
+// their cells are dead.
+func Test_worldChunks(t *testing.T) {
+	w := newWorld()
+	w.set(-1, -1, "")
+	if len(w.chunks) != 0 {
+		t.Errorf("Expected killing a dead cell not to allocate a chunk")
+	}
+	w.set(-1, -1, "#aaaaaa")
+	w.set(-2, -1, "#aaaaaa:2")
+	w.set(1<<50, 3, "#bbbbbb")
+	if len(w.chunks) != 2 {
+		t.Errorf("Expected 2 chunks but got %v", len(w.chunks))
+	}
+	if s := w.get(-1, -1); s != "#aaaaaa" {
+		t.Errorf("Expected #aaaaaa but got %q", s)
+	}
+	if s := w.get(1<<50, 4); s != "" {
+		t.Errorf("Expected a dead cell but got %q", s)
+	}
+	w.set(-1, -1, "")
+	if len(w.chunks) != 2 {
+		t.Errorf("Expected a chunk with a dying cell to be kept")
+	}
+	w.set(-2, -1, "")
+	w.set(1<<50, 3, "")
+	if len(w.chunks) != 0 {
+		t.Errorf("Expected every chunk to be freed but got %v", len(w.chunks))
+	}
+}
+
+// A glider should travel across the corner where four chunks meet, far from
+// the origin, just as it would on the grid.
+func Test_nextPlaneState(t *testing.T) {
+	const base = -(1 << 40)
+	w, df := newWorld(), make(planeDiff)
+	glider := []point64{{0, 1}, {1, 2}, {2, 0}, {2, 1}, {2, 2}}
+	for _, p := range glider {
+		w.set(base+p.x-2, base+p.y-2, "#aaaaaa")
+	}
+	rs := testRuleset()
+	for i := 0; i < 4; i++ {
+		nextPlaneState(w, df, rs)
+		flushPlane(df, w)
+	}
+	// After 4 generations, a glider has moved one cell down and to the right.
+	expected := newWorld()
+	for _, p := range glider {
+		expected.set(base+p.x-1, base+p.y-1, "#aaaaaa")
+	}
+	if !reflect.DeepEqual(w, expected) {
+		t.Errorf("Expected the glider to have moved one cell down and to the right")
+	}
+}
+
+func Test_coveringChunks(t *testing.T) {
+	cr, err := coveringChunks(-1, 10, 60, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cr != (chunkRange{x: -1, y: 0, width: 2, height: 2}) {
+		t.Errorf("Got incorrect chunkRange: %+v", cr)
+	}
+	if v := cr.cells(); v != (viewportJSON{-chunkSize, 0, 2 * chunkSize, 2 * chunkSize}) {
+		t.Errorf("Got incorrect viewport: %+v", v)
+	}
+	invalid := [][4]int64{
+		{0, 0, 0, 1},
+		{0, 0, 9 * chunkSize, 8 * chunkSize},
+		{1<<63 - 1, 0, 1, 2},
+	}
+	for _, v := range invalid {
+		if _, err := coveringChunks(v[0], v[1], v[2], v[3]); err == nil {
+			t.Errorf("Expected %v to be rejected", v)
+		}
+	}
+}
+
+func Test_validatePlaneDiff(t *testing.T) {
+	if err := validatePlaneDiff(planeDiff{-(1 << 62): {1 << 62: "#aaaaaa"}}); err != nil {
+		t.Errorf("Expected far away cells to be valid but got %v", err)
+	}
+	spread := make(planeDiff)
+	for i := int64(0); i <= maxDiffChunks; i++ {
+		spread[i*chunkSize] = map[int64]species{0: "#aaaaaa"}
+	}
+	invalid := []planeDiff{
+		{},
+		{0: {}},
+		{0: {0: ""}},
+		{0: {0: "#aaaaaa:2"}},
+		spread,
+	}
+	for _, df := range invalid {
+		if err := validatePlaneDiff(df); err == nil {
+			t.Errorf("Expected %v to be rejected", df)
+		}
+	}
+}
+
+// Cells that would allocate chunks beyond maxWorldChunks should be removed from
+// a diff, while cells in chunks that the world already holds, and dead cells,
+// are kept.
+func Test_limitChunks(t *testing.T) {
+	w := newWorld()
+	for i := int64(0); i < maxWorldChunks; i++ {
+		w.set(i*chunkSize, 0, "#aaaaaa")
+	}
+	df := planeDiff{
+		1:  {1: "#bbbbbb"},
+		-1: {-1: "#bbbbbb", 1: ""},
+	}
+	limitChunks(w, df)
+	expected := planeDiff{1: {1: "#bbbbbb"}, -1: {1: ""}}
+	if !reflect.DeepEqual(df, expected) {
+		t.Errorf("Expected %v but got %v", expected, df)
+	}
+
+	w.set(0, 0, "")
+	df = planeDiff{-1: {-1: "#bbbbbb"}, -chunkSize - 1: {-1: "#bbbbbb"}}
+	limitChunks(w, df)
+	if len(df) != 1 {
+		t.Errorf("Expected room for one new chunk but got %v", df)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+// cleanupPolicy configures the automatic removal of debris: live cells that
+// have stopped changing, such as blocks and beehives.
+type cleanupPolicy struct {
+	// after is the number of generations that a live cell must remain
+	// unchanged before it is considered debris. If after is 0, debris is
+	// never removed.
+	after int
+	// components causes debris to be removed only as whole connected
+	// components of live cells, and only once every cell in the component is
+	// debris. Otherwise, debris cells are removed individually, which may
+	// leave behind fragments that start evolving again.
+	components bool
+	// fadeSteps is the number of generations over which debris fades to the
+	// color of a dead cell before it is removed. If fadeSteps is 0 or 1,
+	// debris is removed in a single generation.
+	fadeSteps int
+}
+
+// cleaner removes debris from a grid according to a cleanupPolicy. Removals,
+// and the color changes of fading cells, are written into the diff for the
+// next generation, so that they reach clients as ordinary diffs.
+type cleaner struct {
+	policy cleanupPolicy
+	// topology determines which cells are connected across the edges of the
+	// grid.
+	topology topology
+	// unchanged holds the number of generations that each cell has gone
+	// without changing.
+	unchanged [gridDimX][gridDimY]int
+	// fading holds the cells that are fading out.
+	fading map[point]*fade
+}
+
+// fade tracks the progress of a cell that is fading out.
+type fade struct {
+	original species
+	// current is the species that the cell was last set to. If the cell is
+	// found to have any other species, then something else has changed it,
+	// and the fade is abandoned.
+	current species
+	step    int
+}
+
+func newCleaner(policy cleanupPolicy, topo topology) *cleaner {
+	return &cleaner{policy: policy, topology: topo, fading: make(map[point]*fade)}
+}
+
+func (c *cleaner) enabled() bool {
+	return c.policy.after > 0
+}
+
+// advance records that a generation has passed, in which the cells in df
+// changed and all other cells remained unchanged.
+func (c *cleaner) advance(df diff) {
+	for x := 0; x < gridDimX; x++ {
+		ydiff := df[x]
+		for y := 0; y < gridDimY; y++ {
+			if _, ok := ydiff[y]; ok {
+				c.unchanged[x][y] = 0
+			} else {
+				c.unchanged[x][y]++
+			}
+		}
+	}
+}
+
+// sweep finds debris in g and writes its removal into df, which must contain
+// the changes for the next generation. Cells that already have a change in df
+// are not treated as debris.
+func (c *cleaner) sweep(g *grid, df diff) {
+	for p := range c.debris(g, df) {
+		c.fading[p] = &fade{original: g[p.x][p.y], current: g[p.x][p.y]}
+	}
+	for p, f := range c.fading {
+		if _, ok := df[p.x][p.y]; ok || g[p.x][p.y] != f.current {
+			delete(c.fading, p)
+			continue
+		}
+		f.step++
+		if f.step >= c.policy.fadeSteps {
+			getOrMakeYDiff(df, p.x)[p.y] = ""
+			delete(c.fading, p)
+			continue
+		}
+		t := float64(f.step) / float64(c.policy.fadeSteps)
+		s := colorSpecies(blend(speciesColor(f.original), deadCellColor, t))
+		if s != f.current {
+			getOrMakeYDiff(df, p.x)[p.y] = s
+			f.current = s
+		}
+	}
+}
+
+// isFading reports whether s is a color that the cell at p was given by sweep
+// as it fades out, rather than the cell's own species.
+func (c *cleaner) isFading(p point, s species) bool {
+	f, ok := c.fading[p]
+	return ok && s == f.current && s != f.original
+}
+
+// heritable returns the species that the live cell at p, which has species s,
+// passes on to its neighbors: its species from before it started fading, if s
+// is a color that sweep gave it, and otherwise s. Fade colors are real colors,
+// so otherwise cells born next to fading debris would inherit them as a new
+// species.
+func (c *cleaner) heritable(p point, s species) species {
+	if f, ok := c.fading[p]; ok && s == f.current {
+		return f.original
+	}
+	return s
+}
+
+// debris returns the live cells that should start being removed.
+func (c *cleaner) debris(g *grid, df diff) map[point]bool {
+	isDebris := func(p point) bool {
+		_, isChanging := df[p.x][p.y]
+		_, isFading := c.fading[p]
+		return isLive(g[p.x][p.y]) && !isChanging && !isFading &&
+			c.unchanged[p.x][p.y] >= c.policy.after
+	}
+	debris := make(map[point]bool)
+	if !c.policy.components {
+		for x := 0; x < gridDimX; x++ {
+			for y := 0; y < gridDimY; y++ {
+				if p := (point{x, y}); isDebris(p) {
+					debris[p] = true
+				}
+			}
+		}
+		return debris
+	}
+	visited := make(map[point]bool)
+	for x := 0; x < gridDimX; x++ {
+		for y := 0; y < gridDimY; y++ {
+			p := point{x, y}
+			if !isLive(g[x][y]) || visited[p] {
+				continue
+			}
+			component := liveComponent(g, p, visited, c.topology)
+			isComponentDebris := true
+			for _, q := range component {
+				if !isDebris(q) {
+					isComponentDebris = false
+					break
+				}
+			}
+			if isComponentDebris {
+				for _, q := range component {
+					debris[q] = true
+				}
+			}
+		}
+	}
+	return debris
+}
+
+// liveComponent returns the connected component of live cells containing the
+// live cell p, and marks its cells as visited. Cells are connected if they
+// touch, including diagonally, whatever neighborhood the rule uses.
+func liveComponent(g *grid, p point, visited map[point]bool, topo topology) []point {
+	component := []point{p}
+	visited[p] = true
+	for i := 0; i < len(component); i++ {
+		q := component[i]
+		for _, o := range mooreOffsets {
+			n, ok := topo.wrap(q.x+o.x, q.y+o.y)
+			if ok && isLive(g[n.x][n.y]) && !visited[n] {
+				visited[n] = true
+				component = append(component, n)
+			}
+		}
+	}
+	return component
+}
@@ -0,0 +1,153 @@
+package main
+
+import "testing"
+
+// newBlockGrid returns a grid containing a block, a still life, with its
+// top-left cell at (10,10).
+func newBlockGrid() *grid {
+	g := &grid{}
+	g[10][10] = "#000000"
+	g[10][11] = "#000000"
+	g[11][10] = "#000000"
+	g[11][11] = "#000000"
+	return g
+}
+
+func Test_cleanerCells(t *testing.T) {
+	g, df := newBlockGrid(), make(diff)
+	cl := newCleaner(cleanupPolicy{after: 3}, torus)
+	// (10,10) changed two generations after the others.
+	cl.advance(df)
+	cl.advance(df)
+	cl.advance(diff{10: {10: "#000000"}})
+	cl.advance(df)
+
+	cl.sweep(g, df)
+
+	if len(df[10]) != 1 || len(df[11]) != 2 {
+		t.Errorf("Expected 3 cells to be removed but got %v", df)
+	}
+	if v, ok := df[10][11]; !ok || v != "" {
+		t.Errorf("Expected (10, 11) to be removed but got %v", df)
+	}
+}
+
+func Test_cleanerComponents(t *testing.T) {
+	g, df := newBlockGrid(), make(diff)
+	cl := newCleaner(cleanupPolicy{after: 3, components: true}, torus)
+	cl.advance(df)
+	cl.advance(df)
+	cl.advance(diff{10: {10: "#000000"}})
+	cl.advance(df)
+
+	// Not every cell in the block is debris yet.
+	cl.sweep(g, df)
+	if len(df) != 0 {
+		t.Errorf("Expected no cells to be removed but got %v", df)
+	}
+
+	// Now they are.
+	cl.advance(df)
+	cl.advance(df)
+	cl.sweep(g, df)
+	if len(df[10]) != 2 || len(df[11]) != 2 {
+		t.Errorf("Expected the block to be removed but got %v", df)
+	}
+}
+
+func Test_cleanerFade(t *testing.T) {
+	g, df := newBlockGrid(), make(diff)
+	cl := newCleaner(cleanupPolicy{after: 1, fadeSteps: 3}, torus)
+	cl.advance(df)
+
+	// Cells should fade a third of the way towards the dead cell color each
+	// generation, and then be removed.
+	for _, expected := range []species{"#464646", "#8d8d8d", ""} {
+		cl.sweep(g, df)
+		if len(df[10]) != 2 || len(df[11]) != 2 {
+			t.Fatalf("Expected the block to change but got %v", df)
+		}
+		if v := df[10][10]; v != expected {
+			t.Errorf("Expected (10, 10) to be %q but got %q", expected, v)
+		}
+		cl.advance(df)
+		flush(df, g)
+	}
+	if len(cl.fading) != 0 {
+		t.Errorf("Expected no cells to be fading")
+	}
+}
+
+// If a fading cell is changed by something other than the cleaner, it should
+// stop fading.
+func Test_cleanerFadeInterrupted(t *testing.T) {
+	g, df := newBlockGrid(), make(diff)
+	cl := newCleaner(cleanupPolicy{after: 1, fadeSteps: 3}, torus)
+	cl.advance(df)
+	cl.sweep(g, df)
+	cl.advance(df)
+	flush(df, g)
+
+	g[10][10] = "#ffffff"
+	cl.sweep(g, df)
+
+	if _, ok := df[10][10]; ok {
+		t.Errorf("Expected (10, 10) to stop fading")
+	}
+	if len(cl.fading) != 3 {
+		t.Errorf("Expected 3 cells to be fading but got %v", len(cl.fading))
+	}
+}
+
+// Cells that are fading out shouldn't be counted as a species of their own, or
+// as their original species.
+func Test_cleanerFadeNotCounted(t *testing.T) {
+	g, df := &grid{}, make(diff)
+	merge(diff{10: {10: "#000000", 11: "#000000"}, 11: {10: "#000000", 11: "#000000"}}, df)
+	pop, counted := make(population), &grid{}
+	cl := newCleaner(cleanupPolicy{after: 1, fadeSteps: 3}, torus)
+	for i := 0; i < 5; i++ {
+		cl.advance(df)
+		pop.advance(counted, df, cl.isFading)
+		flush(df, g)
+		cl.sweep(g, df)
+		// The block becomes debris after one generation, and its first fade
+		// color is applied in the next.
+		if i < 2 {
+			if pop["#000000"] != 4 || len(pop) != 1 {
+				t.Errorf("Expected the block to be counted but got %v", pop)
+			}
+		} else if len(pop) != 0 {
+			t.Errorf("Expected fading cells not to be counted but got %v in generation %v", pop, i)
+		}
+	}
+	at := newAttribution()
+	at.owner[10][10] = "a"
+	at.placed["a"] = 4
+	if stats := at.stats(counted); stats[0].Alive != 0 {
+		t.Errorf("Expected fading cells not to be counted but got %+v", stats)
+	}
+}
+
+// Cells born next to fading cells should inherit the species that the fading
+// cells had before they started fading, rather than a fade color, and fading
+// cells that survive shouldn't be recolored.
+func Test_cleanerHeritable(t *testing.T) {
+	g, df := &grid{}, make(diff)
+	cl := newCleaner(cleanupPolicy{after: 1, fadeSteps: 3}, torus)
+	for y := 10; y < 13; y++ {
+		g[10][y] = "#464646"
+		cl.fading[point{10, y}] = &fade{original: "#000000", current: "#464646", step: 1}
+	}
+	rs := newRuleset(defaultConfig())
+	rs.heritable = cl.heritable
+
+	nextState(g, df, rs)
+
+	if s := df[11][11]; s != "#000000" {
+		t.Errorf("Expected (11, 11) to be born as #000000 but got %q", s)
+	}
+	if s, ok := df[10][11]; ok {
+		t.Errorf("Expected (10, 11) to be unchanged but got %q", s)
+	}
+}
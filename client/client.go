@@ -0,0 +1,376 @@
+// Package client implements the client side of the multi-life protocol, so
+// that Go programs such as bots and load tests don't have to. A Client speaks
+// version 2 of the protocol and keeps its own copy of the grid, which it
+// updates with each grid, diff, and end of stream that the server sends. See
+// protocol.md in the repository root.
+//
+// Servers running on the plane topology aren't supported.
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// subprotocol is the WebSocket subprotocol for version 2 of the protocol.
+const subprotocol = "multi-life.v2"
+
+// Diff is a change to the grid: a map from X coordinate (the row) to Y
+// coordinate (the column) to the new value of the cell. A value is "" for a
+// dead cell, a hexadecimal color code such as "#aaaaaa" for a live cell of that
+// species, or a color code followed by ":" and a state of 2 or more for a
+// dying cell.
+type Diff map[int]map[int]string
+
+// World describes the server's world. Width is the number of columns, and
+// Height is the number of rows. See the World section of protocol.md.
+type World struct {
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+	Topology     string `json:"topology"`
+	Rule         string `json:"rule"`
+	Neighborhood string `json:"neighborhood"`
+	Radius       int    `json:"radius"`
+	Hexagonal    bool   `json:"hexagonal"`
+}
+
+// UpdateKind is the kind of an Update.
+type UpdateKind int
+
+const (
+	// GridUpdate means the whole grid was replaced, which happens when the
+	// server sends a fresh grid to a client that fell behind.
+	GridUpdate UpdateKind = iota
+	// DiffUpdate means the cells in Update.Diff changed.
+	DiffUpdate
+	// StreamEnd means the grid has stopped evolving. No further diffs
+	// arrive until a player changes the grid.
+	StreamEnd
+)
+
+// Update describes a change to a Client's grid.
+type Update struct {
+	Kind UpdateKind
+	// Diff holds the cells that changed, for a DiffUpdate.
+	Diff Diff
+	// Generation is the generation of the grid after the update: the number
+	// of diffs that the server has applied to it.
+	Generation int
+}
+
+// Options configures a Client. The zero value is valid.
+type Options struct {
+	// Session is a secret token of 16 to 128 letters, digits, "-", and "_"
+	// that identifies the player to play as, so that a program can keep its
+	// player across connections. Other clients see the player ID that the
+	// server derives from it, never the token itself. If it is empty, the
+	// server starts a new session. See NewSession.
+	Session string
+	// Spectate connects as a spectator, which can watch but not Submit.
+	Spectate bool
+	// OnUpdate, if not nil, is called after each change to the grid. Like
+	// OnMessage, it is called on the goroutine that reads from the
+	// connection, so it shouldn't block for long, or the client falls behind
+	// and the server may close the connection.
+	OnUpdate func(Update)
+	// OnMessage, if not nil, is called with every other message from the
+	// server, such as a notice or chat message, along with its type.
+	OnMessage func(msgType string, message []byte)
+}
+
+// ServerError is the error that the server sends before it closes the
+// connection, e.g. because the client sent an invalid message.
+type ServerError struct {
+	Text string
+}
+
+func (err *ServerError) Error() string {
+	return "server error: " + err.Text
+}
+
+// Client is a connection to a multi-life server. Its methods can be called
+// concurrently.
+type Client struct {
+	conn  *websocket.Conn
+	opts  Options
+	world World
+
+	mu         sync.Mutex
+	grid       [][]string
+	generation int
+	err        error
+
+	// writeMu serializes writes, since the connection allows only one
+	// writer at a time.
+	writeMu sync.Mutex
+	// done is closed when the connection is closed.
+	done chan struct{}
+}
+
+// NewSession returns a random session token for Options.Session.
+func NewSession() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Dial connects to the server at rawURL, such as "ws://localhost:8080/", and
+// returns once it has received the grid.
+func Dial(ctx context.Context, rawURL string, opts *Options) (*Client, error) {
+	c := &Client{done: make(chan struct{})}
+	if opts != nil {
+		c.opts = *opts
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	if c.opts.Session != "" {
+		q.Set("session", c.opts.Session)
+	}
+	if c.opts.Spectate {
+		q.Set("spectate", "1")
+	}
+	u.RawQuery = q.Encode()
+
+	dialer := *websocket.DefaultDialer
+	dialer.Subprotocols = []string{subprotocol}
+	conn, _, err := dialer.DialContext(ctx, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	c.conn = conn
+	if conn.Subprotocol() != subprotocol {
+		conn.Close()
+		return nil, errors.New("server doesn't speak protocol version 2")
+	}
+	_, message, err := conn.ReadMessage()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	var init initMessage
+	if err := json.Unmarshal(message, &init); err != nil || init.Type != "init" {
+		conn.Close()
+		return nil, fmt.Errorf("expected an init message but got %s", message)
+	}
+	if init.Grid == nil {
+		conn.Close()
+		return nil, fmt.Errorf("unsupported topology (%v)", init.World.Topology)
+	}
+	c.world = init.World
+	c.grid = init.Grid
+	c.generation = init.Generation
+	go c.readLoop()
+	return c, nil
+}
+
+// initMessage is the first message that the server sends, and may send again
+// with a fresh grid.
+type initMessage struct {
+	Type       string     `json:"type"`
+	Grid       [][]string `json:"grid"`
+	Generation int        `json:"generation"`
+	World      World      `json:"world"`
+}
+
+// diffMessage is a diff sent by the server. Generations is 0 unless the diff
+// covers more than one generation.
+type diffMessage struct {
+	Type        string `json:"type"`
+	Cells       Diff   `json:"cells"`
+	Generations int    `json:"generations"`
+}
+
+// readLoop reads messages from the connection until it is closed, keeping the
+// grid up-to-date.
+func (c *Client) readLoop() {
+	defer close(c.done)
+	for {
+		_, message, err := c.conn.ReadMessage()
+		if err != nil {
+			c.mu.Lock()
+			if c.err == nil {
+				c.err = err
+			}
+			c.mu.Unlock()
+			c.conn.Close()
+			return
+		}
+		var envelope struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		}
+		if err := json.Unmarshal(message, &envelope); err != nil {
+			continue
+		}
+		switch envelope.Type {
+		case "init":
+			var init initMessage
+			if err := json.Unmarshal(message, &init); err != nil || init.Grid == nil {
+				continue
+			}
+			c.mu.Lock()
+			c.grid = init.Grid
+			c.generation = init.Generation
+			c.mu.Unlock()
+			c.update(Update{GridUpdate, nil, init.Generation})
+		case "diff":
+			var d diffMessage
+			if err := json.Unmarshal(message, &d); err != nil {
+				continue
+			}
+			if d.Generations == 0 {
+				d.Generations = 1
+			}
+			c.mu.Lock()
+			for x, ydiff := range d.Cells {
+				for y, v := range ydiff {
+					if x >= 0 && x < len(c.grid) && y >= 0 && y < len(c.grid[x]) {
+						c.grid[x][y] = v
+					}
+				}
+			}
+			c.generation += d.Generations
+			gen := c.generation
+			c.mu.Unlock()
+			c.update(Update{DiffUpdate, d.Cells, gen})
+		case "streamEnd":
+			c.update(Update{StreamEnd, nil, c.Generation()})
+		case "error":
+			c.mu.Lock()
+			c.err = &ServerError{envelope.Text}
+			c.mu.Unlock()
+		default:
+			if c.opts.OnMessage != nil {
+				c.opts.OnMessage(envelope.Type, message)
+			}
+		}
+	}
+}
+
+func (c *Client) update(u Update) {
+	if c.opts.OnUpdate != nil {
+		c.opts.OnUpdate(u)
+	}
+}
+
+// World returns the server's world.
+func (c *Client) World() World {
+	return c.world
+}
+
+// Grid returns a copy of the grid.
+func (c *Client) Grid() [][]string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	g := make([][]string, len(c.grid))
+	for x := range c.grid {
+		g[x] = append([]string(nil), c.grid[x]...)
+	}
+	return g
+}
+
+// Cell returns the value of cell (x, y), or "" if it is outside the grid.
+func (c *Client) Cell(x int, y int) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if x < 0 || x >= len(c.grid) || y < 0 || y >= len(c.grid[x]) {
+		return ""
+	}
+	return c.grid[x][y]
+}
+
+// Generation returns the generation of the grid.
+func (c *Client) Generation() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation
+}
+
+var hexColorCode = regexp.MustCompile(`\A#[0-9a-f]{6}\z`)
+
+// Validate checks a diff against the rules that the server applies to diffs
+// from clients, so that Submit can report mistakes without the server closing
+// the connection: the diff must not be empty, every cell must be within the
+// grid, and every value must be the color code of a live cell.
+func (c *Client) Validate(df Diff) error {
+	if len(df) == 0 {
+		return errors.New("diff is empty")
+	}
+	for x, ydiff := range df {
+		if x < 0 || x >= c.world.Height {
+			return fmt.Errorf("diff exceeds grid's X dimension (%v)", x)
+		}
+		if len(ydiff) == 0 {
+			return errors.New("diff includes an X coordinate with no Y coordinate")
+		}
+		for y, v := range ydiff {
+			if y < 0 || y >= c.world.Width {
+				return fmt.Errorf("diff exceeds grid's Y dimension (%v)", y)
+			}
+			if !hexColorCode.MatchString(v) {
+				return fmt.Errorf("diff contains a cell value that is not a "+
+					"hexadecimal color code (%v)", v)
+			}
+		}
+	}
+	return nil
+}
+
+// Submit validates a diff and sends it to the server, which merges it into
+// the next generation.
+func (c *Client) Submit(df Diff) error {
+	if c.opts.Spectate {
+		return errors.New("spectators may not send messages")
+	}
+	if err := c.Validate(df); err != nil {
+		return err
+	}
+	message, err := json.Marshal(&struct {
+		Type  string `json:"type"`
+		Cells Diff   `json:"cells"`
+	}{"diff", df})
+	if err != nil {
+		return err
+	}
+	return c.send(message)
+}
+
+func (c *Client) send(message []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.conn.WriteMessage(websocket.BinaryMessage, message)
+}
+
+// Done returns a channel that is closed when the connection has closed.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns the reason that the connection closed, once Done is closed. If
+// the server sent an error message, Err returns a *ServerError.
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Close closes the connection.
+func (c *Client) Close() error {
+	c.writeMu.Lock()
+	c.conn.WriteMessage(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	c.writeMu.Unlock()
+	return c.conn.Close()
+}
@@ -0,0 +1,202 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// initJSON is an init message for a 2x3 grid at generation 5.
+const initJSON = "{\"type\":\"init\",\"grid\":[[\"\",\"\",\"\"],[\"\",\"#aaaaaa\",\"\"]],\"generation\":5,\"world\":{\"width\":3,\"height\":2,\"topology\":\"torus\",\"rule\":\"B3/S23\",\"neighborhood\":\"moore\",\"radius\":1,\"hexagonal\":false}}"
+
+// newFakeServer starts a server that stands in for a multi-life server, so
+// that tests decide exactly which messages a Client receives, and when. The
+// server sends init to each connection, then every message sent on out,
+// closing the connection when out is closed. It passes on each message from
+// the client on in.
+func newFakeServer(t *testing.T, init string) (url string, out chan<- string, in <-chan []byte) {
+	outChan := make(chan string)
+	inChan := make(chan []byte, 16)
+	upgrader := websocket.Upgrader{Subprotocols: []string{subprotocol}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("Unexpected error upgrading: %v", err)
+			return
+		}
+		defer conn.Close()
+		go func() {
+			for {
+				_, message, err := conn.ReadMessage()
+				if err != nil {
+					return
+				}
+				inChan <- message
+			}
+		}()
+		conn.WriteMessage(websocket.BinaryMessage, []byte(init))
+		for message := range outChan {
+			conn.WriteMessage(websocket.BinaryMessage, []byte(message))
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http"), outChan, inChan
+}
+
+// A Client should start from the grid in the init message, and keep it up to
+// date with each message from the server.
+func Test_Client(t *testing.T) {
+	url, out, _ := newFakeServer(t, initJSON)
+	updates := make(chan Update, 16)
+	messages := make(chan string, 16)
+	c, err := Dial(context.Background(), url, &Options{
+		OnUpdate: func(u Update) {
+			updates <- u
+		},
+		OnMessage: func(msgType string, message []byte) {
+			messages <- msgType
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if w := c.World(); w.Width != 3 || w.Height != 2 || w.Topology != "torus" {
+		t.Errorf("Got incorrect world: %+v", w)
+	}
+	if c.Cell(1, 1) != "#aaaaaa" || c.Generation() != 5 {
+		t.Errorf("Expected the grid in the init message but got %v in generation %v",
+			c.Grid(), c.Generation())
+	}
+
+	send(t, out, "{\"type\":\"diff\",\"cells\":{\"0\":{\"2\":\"#bbbbbb\"},\"1\":{\"1\":\"\"}}}")
+	u := recv(t, updates)
+	expected := Update{DiffUpdate, Diff{0: {2: "#bbbbbb"}, 1: {1: ""}}, 6}
+	if !reflect.DeepEqual(u, expected) {
+		t.Errorf("Expected %+v but got %+v", expected, u)
+	}
+	if g := c.Grid(); !reflect.DeepEqual(g, [][]string{{"", "", "#bbbbbb"}, {"", "", ""}}) {
+		t.Errorf("Got incorrect grid: %v", g)
+	}
+
+	// A coalesced diff advances the generation by each generation that it
+	// covers.
+	send(t, out, "{\"type\":\"diff\",\"cells\":{\"0\":{\"0\":\"#cccccc\"}},\"generations\":3}")
+	if u := recv(t, updates); u.Kind != DiffUpdate || u.Generation != 9 {
+		t.Errorf("Expected a diff ending at generation 9 but got %+v", u)
+	}
+
+	send(t, out, "{\"type\":\"streamEnd\"}")
+	if u := recv(t, updates); u.Kind != StreamEnd || u.Generation != 9 {
+		t.Errorf("Expected the end of the stream at generation 9 but got %+v", u)
+	}
+
+	// Other messages are passed on as they are.
+	send(t, out, "{\"type\":\"notice\",\"text\":\"Hello\"}")
+	if msgType := recv(t, messages); msgType != "notice" {
+		t.Errorf("Expected a notice but got %v", msgType)
+	}
+
+	// A fresh grid replaces the grid.
+	send(t, out, initJSON)
+	if u := recv(t, updates); u.Kind != GridUpdate || u.Generation != 5 {
+		t.Errorf("Expected a fresh grid at generation 5 but got %+v", u)
+	}
+	if c.Cell(0, 0) != "" || c.Cell(1, 1) != "#aaaaaa" {
+		t.Errorf("Expected the fresh grid but got %v", c.Grid())
+	}
+}
+
+// Submit should send valid diffs to the server, and report invalid ones
+// without sending them.
+func Test_ClientSubmit(t *testing.T) {
+	url, _, in := newFakeServer(t, initJSON)
+	c, err := Dial(context.Background(), url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	invalid := []Diff{
+		{},
+		{0: {}},
+		{2: {0: "#aaaaaa"}},
+		{0: {-1: "#aaaaaa"}},
+		{0: {0: "#aaaaaa:2"}},
+		{0: {0: ""}},
+	}
+	for _, df := range invalid {
+		if err := c.Submit(df); err == nil {
+			t.Errorf("Expected %v to be rejected", df)
+		}
+	}
+	if err := c.Submit(Diff{1: {2: "#aaaaaa"}}); err != nil {
+		t.Fatal(err)
+	}
+	if json := string(recv(t, in)); json != "{\"type\":\"diff\",\"cells\":{\"1\":{\"2\":\"#aaaaaa\"}}}" {
+		t.Errorf("Got incorrect JSON: %v", json)
+	}
+
+	spectator, err := Dial(context.Background(), url, &Options{Spectate: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spectator.Close()
+	if err := spectator.Submit(Diff{1: {2: "#aaaaaa"}}); err == nil {
+		t.Errorf("Expected an error for a spectator")
+	}
+}
+
+// When the server sends an error and closes the connection, Err should return
+// the error once Done is closed.
+func Test_ClientServerError(t *testing.T) {
+	url, out, _ := newFakeServer(t, initJSON)
+	c, err := Dial(context.Background(), url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	send(t, out, "{\"type\":\"error\",\"text\":\"message has no type\"}")
+	close(out)
+	recv(t, c.Done())
+	if err, ok := c.Err().(*ServerError); !ok || err.Text != "message has no type" {
+		t.Errorf("Expected the server's error but got %v", c.Err())
+	}
+}
+
+// Dial should refuse servers whose init message has no grid, such as those
+// running on the plane.
+func Test_DialPlane(t *testing.T) {
+	url, _, _ := newFakeServer(t, "{\"type\":\"init\",\"generation\":0,\"world\":{\"width\":0,\"height\":0,\"topology\":\"plane\"}}")
+	if _, err := Dial(context.Background(), url, nil); err == nil {
+		t.Errorf("Expected an error for the plane")
+	}
+}
+
+// recv performs the channel receive operation with a timeout.
+func recv[U any](t *testing.T, ch <-chan U) U {
+	select {
+	case <-time.After(2 * time.Second):
+		t.Errorf("Channel receive operation timed out")
+		var zero U
+		return zero
+	case v := <-ch:
+		return v
+	}
+}
+
+// send performs the channel send operation with a timeout.
+func send[U any](t *testing.T, ch chan<- U, v U) {
+	select {
+	case <-time.After(2 * time.Second):
+		t.Errorf("Channel send operation timed out")
+	case ch <- v:
+	}
+}
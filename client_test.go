@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/alex-nicoll/multi-life/client"
+)
+
+// The client package should keep its grid in step with the server's, and
+// validate diffs before submitting them.
+func Test_client(t *testing.T) {
+	readPumpOut := make(chan interface{})
+	golChan := make(chan interface{})
+	pl := startPipelineInternal(defaultConfig(), readPumpOut, golChan)
+	srv := httptest.NewServer(handleWebSocket(pl))
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	updates := make(chan client.Update, 100)
+	c1, err := client.Dial(context.Background(), url, &client.Options{Session: client.NewSession()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c1.Close()
+	c2, err := client.Dial(context.Background(), url, &client.Options{
+		OnUpdate: func(u client.Update) {
+			updates <- u
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c2.Close()
+	if w := c2.World(); w.Width != gridDimY || w.Height != gridDimX || w.Topology != "torus" {
+		t.Errorf("Got incorrect world: %+v", w)
+	}
+
+	if err := c1.Submit(client.Diff{10: {200: "#aaaaaa"}}); err == nil {
+		t.Errorf("Expected an error for a cell outside the grid")
+	}
+	if err := c1.Submit(client.Diff{10: {20: "#aaaaaa:2"}}); err == nil {
+		t.Errorf("Expected an error for a dying cell")
+	}
+	// A block, which is a still life, so the stream ends after it is placed.
+	block := client.Diff{10: {20: "#aaaaaa", 21: "#aaaaaa"}, 11: {20: "#aaaaaa", 21: "#aaaaaa"}}
+	if err := c1.Submit(block); err != nil {
+		t.Fatal(err)
+	}
+	fwd(t, golChan, readPumpOut)
+	send[interface{}](t, golChan, &tick{})
+	u := recv(t, updates)
+	if u.Kind != client.DiffUpdate || !reflect.DeepEqual(u.Diff, block) {
+		t.Errorf("Expected the block but got %+v", u)
+	}
+	send[interface{}](t, golChan, &tick{})
+	if u := recv(t, updates); u.Kind != client.StreamEnd {
+		t.Errorf("Expected the end of the stream but got %+v", u)
+	}
+	if c2.Cell(11, 21) != "#aaaaaa" || c2.Generation() != 1 {
+		t.Errorf("Expected the block in generation 1 but got %v in generation %v",
+			c2.Cell(11, 21), c2.Generation())
+	}
+	g := pl.currentGrid()
+	for x, row := range c2.Grid() {
+		if !reflect.DeepEqual(row, g[x][:]) {
+			t.Errorf("Expected row %v to match the server's grid", x)
+		}
+	}
+
+	spectator, err := client.Dial(context.Background(), url, &client.Options{Spectate: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := spectator.Submit(block); err == nil {
+		t.Errorf("Expected an error for a spectator")
+	}
+	spectator.Close()
+	recv(t, spectator.Done())
+}
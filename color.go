@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"strconv"
+)
+
+// speciesColor converts a species, which must be a live or dying cell, to a
+// color. A dying cell's color is halfway between its color code and the color
+// of a dead cell.
+func speciesColor(s species) color.RGBA {
+	code, state := cellState(s)
+	v, _ := strconv.ParseUint(code[1:], 16, 32)
+	c := color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0xff}
+	if state > 1 {
+		return blend(c, deadCellColor, 0.5)
+	}
+	return c
+}
+
+// colorSpecies converts a color to a species.
+func colorSpecies(c color.RGBA) species {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// blend returns the color that is the fraction t of the way from a to b.
+func blend(a color.RGBA, b color.RGBA, t float64) color.RGBA {
+	mix := func(x uint8, y uint8) uint8 {
+		return uint8(math.Round(float64(x) + (float64(y)-float64(x))*t))
+	}
+	return color.RGBA{mix(a.R, b.R), mix(a.G, b.G), mix(a.B, b.B), 0xff}
+}
+
+// averageColor returns the mean of one or more colors, channel by channel.
+func averageColor(colors []color.RGBA) color.RGBA {
+	var r, g, b float64
+	for _, c := range colors {
+		r += float64(c.R)
+		g += float64(c.G)
+		b += float64(c.B)
+	}
+	n := float64(len(colors))
+	return color.RGBA{
+		uint8(math.Round(r / n)),
+		uint8(math.Round(g / n)),
+		uint8(math.Round(b / n)),
+		0xff,
+	}
+}
+
+// shiftHue rotates the hue of a color by the given number of degrees, keeping
+// its saturation and value.
+func shiftHue(c color.RGBA, degrees float64) color.RGBA {
+	h, s, v := rgbToHSV(c)
+	h = math.Mod(h+degrees, 360)
+	if h < 0 {
+		h += 360
+	}
+	return hsvToRGB(h, s, v)
+}
+
+// rgbToHSV converts a color to hue in degrees [0, 360), and saturation and
+// value in [0, 1].
+func rgbToHSV(c color.RGBA) (h float64, s float64, v float64) {
+	r, g, b := float64(c.R)/255, float64(c.G)/255, float64(c.B)/255
+	max := math.Max(r, math.Max(g, b))
+	min := math.Min(r, math.Min(g, b))
+	delta := max - min
+	v = max
+	if max > 0 {
+		s = delta / max
+	}
+	switch {
+	case delta == 0:
+		h = 0
+	case max == r:
+		h = 60 * math.Mod((g-b)/delta, 6)
+	case max == g:
+		h = 60 * ((b-r)/delta + 2)
+	default:
+		h = 60 * ((r-g)/delta + 4)
+	}
+	if h < 0 {
+		h += 360
+	}
+	return h, s, v
+}
+
+// hsvToRGB is the inverse of rgbToHSV.
+func hsvToRGB(h float64, s float64, v float64) color.RGBA {
+	c := v * s
+	x := c * (1 - math.Abs(math.Mod(h/60, 2)-1))
+	m := v - c
+	var r, g, b float64
+	switch {
+	case h < 60:
+		r, g, b = c, x, 0
+	case h < 120:
+		r, g, b = x, c, 0
+	case h < 180:
+		r, g, b = 0, c, x
+	case h < 240:
+		r, g, b = 0, x, c
+	case h < 300:
+		r, g, b = x, 0, c
+	default:
+		r, g, b = c, 0, x
+	}
+	return color.RGBA{
+		uint8(math.Round((r + m) * 255)),
+		uint8(math.Round((g + m) * 255)),
+		uint8(math.Round((b + m) * 255)),
+		0xff,
+	}
+}
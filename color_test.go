@@ -0,0 +1,77 @@
+package main
+
+import (
+	"image/color"
+	"testing"
+)
+
+func Test_averageColor(t *testing.T) {
+	c := averageColor([]color.RGBA{
+		{0xff, 0x00, 0x00, 0xff},
+		{0x00, 0xff, 0x00, 0xff},
+		{0x00, 0x00, 0xfe, 0xff},
+	})
+	if want := (color.RGBA{0x55, 0x55, 0x55, 0xff}); c != want {
+		t.Errorf("Expected %v but got %v", want, c)
+	}
+}
+
+func Test_rgbToHSV(t *testing.T) {
+	cases := []struct {
+		c       color.RGBA
+		h, s, v float64
+	}{
+		{color.RGBA{0xff, 0x00, 0x00, 0xff}, 0, 1, 1},
+		{color.RGBA{0x00, 0xff, 0x00, 0xff}, 120, 1, 1},
+		{color.RGBA{0x00, 0x00, 0xff, 0xff}, 240, 1, 1},
+		{color.RGBA{0xff, 0x00, 0xff, 0xff}, 300, 1, 1},
+		{color.RGBA{0x00, 0x00, 0x00, 0xff}, 0, 0, 0},
+	}
+	for _, c := range cases {
+		h, s, v := rgbToHSV(c.c)
+		if h != c.h || s != c.s || v != c.v {
+			t.Errorf("Expected %v to be (%v, %v, %v) but got (%v, %v, %v)",
+				c.c, c.h, c.s, c.v, h, s, v)
+		}
+	}
+}
+
+func Test_hsvRoundTrip(t *testing.T) {
+	for _, s := range []species{"#000000", "#ffffff", "#123456", "#abcdef", "#ff8000", "#7f007f"} {
+		c := speciesColor(s)
+		h, sat, v := rgbToHSV(c)
+		if got := colorSpecies(hsvToRGB(h, sat, v)); got != s {
+			t.Errorf("Expected %q to survive a round trip through HSV but got %q", s, got)
+		}
+	}
+}
+
+func Test_shiftHue(t *testing.T) {
+	red := color.RGBA{0xff, 0x00, 0x00, 0xff}
+	cases := []struct {
+		degrees float64
+		want    species
+	}{
+		{120, "#00ff00"},
+		{-120, "#0000ff"},
+		{360, "#ff0000"},
+		{-60, "#ff00ff"},
+	}
+	for _, c := range cases {
+		if got := colorSpecies(shiftHue(red, c.degrees)); got != c.want {
+			t.Errorf("Expected shifting red by %v degrees to give %q but got %q",
+				c.degrees, c.want, got)
+		}
+	}
+	gray := color.RGBA{0x80, 0x80, 0x80, 0xff}
+	if got := shiftHue(gray, 90); got != gray {
+		t.Errorf("Expected shifting gray to have no effect but got %v", got)
+	}
+}
+
+func Test_speciesColorDying(t *testing.T) {
+	want := blend(color.RGBA{0xaa, 0x00, 0x00, 0xff}, deadCellColor, 0.5)
+	if got := speciesColor("#aa0000:2"); got != want {
+		t.Errorf("Expected %v but got %v", want, got)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// config holds the settings shared by the stages of a pipeline.
+type config struct {
+	// patterns is the library of patterns that clients may stamp onto the
+	// grid.
+	patterns patternLibrary
+	// adminToken is the bearer token required by privileged HTTP endpoints.
+	// If it is empty, those endpoints are disabled.
+	adminToken string
+	// historyLen is the number of diffs that gol retains so that recent
+	// generations can be replayed.
+	historyLen int
+	// tickInterval is the initial interval between generations.
+	tickInterval time.Duration
+	// rule determines which cells are born and which survive.
+	rule lifeRule
+	// neighborhood is the set of cells that rule counts.
+	neighborhood neighborhood
+	// topology determines how the edges of the grid are joined together.
+	topology topology
+	// cleanup is the policy for removing debris. The default policy never
+	// removes debris.
+	cleanup cleanupPolicy
+	// genetics configures the inheritance of species. It is disabled by
+	// default.
+	genetics genetics
+	// seed seeds the random number generator used to evolve the grid. If it
+	// is 0, a seed is chosen based on the current time.
+	seed int64
+	// leaderboardInterval is the number of generations between broadcasts of
+	// the leaderboard. If it is 0, the leaderboard is never broadcast.
+	leaderboardInterval int
+	// antRule is the turmite rule that ants follow. See validateAntRule.
+	antRule string
+	// maxAnts is the maximum number of ants on the grid. When a client spawns
+	// an ant beyond the limit, the oldest ant is removed. If it is 0, clients
+	// cannot spawn ants.
+	maxAnts int
+	// sendBufferLen is the number of messages that can wait to be sent on a
+	// connection before the overflow policy applies.
+	sendBufferLen int
+	// overflowPolicy determines what happens when a connection's send buffer
+	// is full.
+	overflowPolicy overflowPolicy
+	// maxPlayers and maxSpectators limit the number of players and
+	// spectators that can be connected at once. If either is 0, there is no
+	// limit on that kind of connection.
+	maxPlayers    int
+	maxSpectators int
+	// apiToken is the bearer token required by the HTTP API. If it is empty,
+	// the API is open to everyone.
+	apiToken string
+	// bots are the players that the server runs itself. See botConfig.
+	bots []botConfig
+}
+
+// defaultHistoryLen is the default value of config.historyLen. At the usual
+// tick rate, it covers about three minutes.
+const defaultHistoryLen = 1000
+
+// defaultTickInterval is the default value of config.tickInterval.
+const defaultTickInterval = 170 * time.Millisecond
+
+// defaultConfig returns a config with default settings and only the built-in
+// patterns.
+func defaultConfig() *config {
+	lib, err := loadPatterns("")
+	if err != nil {
+		// The built-in patterns are compiled into the program, so this can
+		// only happen due to a programming error.
+		panic(err)
+	}
+	nh := newNeighborhood(moore, 1)
+	rule, err := parseRule(conway, len(nh.offsets))
+	if err != nil {
+		// Likewise for the default rule.
+		panic(err)
+	}
+	return &config{
+		patterns:            lib,
+		rule:                rule,
+		neighborhood:        nh,
+		historyLen:          defaultHistoryLen,
+		tickInterval:        defaultTickInterval,
+		topology:            torus,
+		antRule:             defaultAntRule,
+		maxAnts:             defaultMaxAnts,
+		leaderboardInterval: defaultLeaderboardInterval,
+		sendBufferLen:       defaultSendBufferLen,
+		overflowPolicy:      disconnect,
+	}
+}
+
+// validate checks that the settings in a config can be used together. The
+// features that depend on the grid's fixed size can't be used on the plane.
+func (cfg *config) validate() error {
+	if cfg.topology == plane {
+		if cfg.cleanup != (cleanupPolicy{}) {
+			return errors.New("debris cleanup is not supported on the plane")
+		}
+		if cfg.maxAnts != 0 {
+			return errors.New("ants are not supported on the plane")
+		}
+		// Bots keep a copy of the grid, and place cells within it.
+		if len(cfg.bots) != 0 {
+			return errors.New("bots are not supported on the plane")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+// The features that depend on the grid's fixed size should be refused on the
+// plane.
+func Test_configValidate(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.topology = plane
+	cfg.maxAnts = 0
+	if err := cfg.validate(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	invalid := []func(cfg *config){
+		func(cfg *config) { cfg.maxAnts = 1 },
+		func(cfg *config) { cfg.cleanup = cleanupPolicy{after: 10} },
+		func(cfg *config) { cfg.bots = []botConfig{{}} },
+	}
+	for i, change := range invalid {
+		cfg := defaultConfig()
+		cfg.topology = plane
+		cfg.maxAnts = 0
+		change(cfg)
+		if err := cfg.validate(); err == nil {
+			t.Errorf("Expected config %v to be refused", i)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/websocket"
+)
+
+// handleEvents streams the game as Server-Sent Events, for viewers that can't
+// use WebSockets. Each stream is a spectator connection that speaks version 2
+// of the protocol, with capabilities taken from the "caps" query parameter as
+// on a WebSocket. Streams aren't supported on the plane, where clients must
+// subscribe to receive cells.
+func handleEvents(pl *pipeline) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+			return
+		}
+		if pl.cfg.topology == plane {
+			http.Error(w, "event streams are not supported on the plane", http.StatusBadRequest)
+			return
+		}
+		if !pl.conns.acquire(true) {
+			http.Error(w, "too many clients are connected", http.StatusServiceUnavailable)
+			return
+		}
+		defer pl.conns.release(true)
+		caps := parseCapabilities(r.URL.Query().Get("caps"))
+		caps.version = protocolV2
+		caps.spectator = true
+		gen := -1
+		if n, err := strconv.Atoi(r.Header.Get("Last-Event-ID")); err == nil && n >= 0 {
+			gen = n
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		// closed is closed by closeConn, which causes readFromConn to return,
+		// so that attachConn's goroutines stop.
+		closed := make(chan struct{})
+		wg, _ := resumeConn(
+			pl,
+			func() (messageType int, p []byte, err error) {
+				select {
+				case <-r.Context().Done():
+					return 0, nil, r.Context().Err()
+				case <-closed:
+					return 0, nil, io.EOF
+				}
+			},
+			newEventWriter(w, flusher, gen),
+			func() error {
+				close(closed)
+				return nil
+			},
+			caps,
+			"",
+			gen,
+		)
+		wg.Wait()
+	}
+}
+
+// newEventWriter adapts a Server-Sent Events stream to writeToConn. Each
+// message is sent as the data of an event. Init and diff events carry the
+// generation of the grid as their ID, so that a client that reconnects with
+// the Last-Event-ID header can be sent the diffs that it missed; gen is the
+// generation that the client has already seen, or -1 if there is none. Close
+// messages have no counterpart in the stream, which ends when the handler
+// returns.
+func newEventWriter(w io.Writer, flusher http.Flusher, gen int) writeToConn {
+	return func(messageType int, data []byte) error {
+		if messageType == websocket.CloseMessage {
+			return nil
+		}
+		var m struct {
+			Type        string `json:"type"`
+			Generation  int    `json:"generation"`
+			Generations int    `json:"generations"`
+		}
+		if err := json.Unmarshal(data, &m); err != nil {
+			return err
+		}
+		var err error
+		switch m.Type {
+		case "init":
+			gen = m.Generation
+			_, err = fmt.Fprintf(w, "id: %d\n", gen)
+		case "diff":
+			if m.Generations == 0 {
+				m.Generations = 1
+			}
+			gen += m.Generations
+			_, err = fmt.Fprintf(w, "id: %d\n", gen)
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+}
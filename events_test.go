@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// event is a Server-Sent Event as read by readEvents.
+type event struct {
+	id   string
+	data string
+}
+
+// openEvents connects to an event stream, sending lastEventID if it isn't
+// empty, and returns a channel of the events received along with a function
+// that disconnects.
+func openEvents(t *testing.T, url string, lastEventID string) (<-chan event, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Expected an event stream but got %v", ct)
+	}
+	events := make(chan event)
+	go func() {
+		defer resp.Body.Close()
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(nil, 1<<20)
+		var e event
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case line == "":
+				select {
+				case events <- e:
+				case <-ctx.Done():
+					return
+				}
+				e = event{}
+			case strings.HasPrefix(line, "id: "):
+				e.id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "data: "):
+				e.data = strings.TrimPrefix(line, "data: ")
+			}
+		}
+	}()
+	return events, cancel
+}
+
+// A client of the event stream should receive the grid and then each diff,
+// with the generation as the event ID. A client that reconnects should be
+// caught up with one diff if the diffs that it missed are still held, and
+// sent the grid otherwise.
+func Test_events(t *testing.T) {
+	golChan := make(chan interface{})
+	pl := startPipelineInternal(defaultConfig(), golChan, golChan)
+	srv := httptest.NewServer(handleEvents(pl))
+	defer srv.Close()
+
+	events, cancel := openEvents(t, srv.URL, "")
+	e := recv(t, events)
+	if e.id != "0" || !strings.HasPrefix(e.data, "{\"type\":\"init\",\"grid\":[[") {
+		t.Errorf("Got incorrect event: %+v", e)
+	}
+
+	// A blinker, which produces a diff on every tick.
+	send[interface{}](t, golChan, &mergeDiff{diff{20: {20: "#aaaaaa", 21: "#aaaaaa", 22: "#aaaaaa"}}, ""})
+	send[interface{}](t, golChan, &tick{})
+	e = recv(t, events)
+	if e.id != "1" || e.data != "{\"type\":\"diff\",\"cells\":{\"20\":{\"20\":\"#aaaaaa\",\"21\":\"#aaaaaa\",\"22\":\"#aaaaaa\"}}}" {
+		t.Errorf("Got incorrect event: %+v", e)
+	}
+	cancel()
+
+	send[interface{}](t, golChan, &tick{})
+	send[interface{}](t, golChan, &tick{})
+	events, cancel = openEvents(t, srv.URL, "1")
+	e = recv(t, events)
+	// The blinker went vertical and back, leaving only the cells that
+	// changed in between.
+	if e.id != "3" || e.data != "{\"type\":\"diff\",\"cells\":{\"19\":{\"21\":\"\"},\"20\":{\"20\":\"#aaaaaa\",\"22\":\"#aaaaaa\"},\"21\":{\"21\":\"\"}},\"generations\":2}" {
+		t.Errorf("Got incorrect event: %+v", e)
+	}
+	cancel()
+
+	events, cancel = openEvents(t, srv.URL, "100")
+	e = recv(t, events)
+	if e.id != "3" || !strings.HasPrefix(e.data, "{\"type\":\"init\",\"grid\":[[") {
+		t.Errorf("Got incorrect event: %+v", e)
+	}
+	cancel()
+}
+
+// Event streams shouldn't be available on the plane.
+func Test_eventsPlane(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.topology = plane
+	golChan := make(chan interface{})
+	pl := startPipelineInternal(cfg, golChan, golChan)
+	rec := httptest.NewRecorder()
+	handleEvents(pl)(rec, httptest.NewRequest("GET", "/events", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %v but got %v", http.StatusBadRequest, rec.Code)
+	}
+}
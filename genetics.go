@@ -0,0 +1,31 @@
+package main
+
+import (
+	"image/color"
+	"math/rand"
+)
+
+// genetics configures the inheritance of species. When it is enabled, a
+// newborn cell's color is a blend of its parents' colors, with an occasional
+// mutation that shifts the hue.
+type genetics struct {
+	enabled bool
+	// mutationRate is the probability in [0, 1] that a newborn cell mutates.
+	mutationRate float64
+	// mutationRange is the largest hue shift, in degrees, that a mutation can
+	// cause.
+	mutationRange float64
+}
+
+// offspring returns the species of a cell born to the given parents.
+func (gn *genetics) offspring(parents []species, rng *rand.Rand) species {
+	colors := make([]color.RGBA, len(parents))
+	for i, s := range parents {
+		colors[i] = speciesColor(s)
+	}
+	c := averageColor(colors)
+	if rng.Float64() < gn.mutationRate {
+		c = shiftHue(c, (rng.Float64()*2-1)*gn.mutationRange)
+	}
+	return colorSpecies(c)
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
+	"os"
+	"sort"
+)
+
+// Limits on the delay between frames of a time-lapse, in milliseconds.
+const (
+	defaultFrameDelay = 170
+	minFrameDelay     = 20
+	maxFrameDelay     = 10000
+)
+
+// encodeGIF writes an animated GIF with one frame per generation of a
+// recording. delay is the time between frames in milliseconds.
+//
+// The palette holds the colors of dead cells, grid lines, and the 254 most
+// common species. If there are more species than that, each of the remaining
+// species is drawn with the closest color in the palette.
+func encodeGIF(w io.Writer, rec *recording, opts renderOptions, delay int) error {
+	pal, index := gifPalette(rec)
+	g := *rec.base
+	anim := &gif.GIF{}
+	for i := -1; i < len(rec.diffs); i++ {
+		if i >= 0 {
+			apply(rec.diffs[i], &g)
+		}
+		img := image.NewPaletted(imageBounds(opts), pal)
+		if opts.gridLines {
+			// Index 1 is the grid line color.
+			for j := range img.Pix {
+				img.Pix[j] = 1
+			}
+		}
+		paintCells(&g, opts, func(rect image.Rectangle, s species) {
+			var idx uint8
+			if s != "" {
+				idx = index(s)
+			}
+			for y := rect.Min.Y; y < rect.Max.Y; y++ {
+				row := img.Pix[img.PixOffset(rect.Min.X, y):img.PixOffset(rect.Max.X, y)]
+				for j := range row {
+					row[j] = idx
+				}
+			}
+		})
+		anim.Image = append(anim.Image, img)
+		// GIF delays are in hundredths of a second.
+		anim.Delay = append(anim.Delay, delay/10)
+	}
+	return gif.EncodeAll(w, anim)
+}
+
+// gifPalette builds a palette for the recording, and returns it along with a
+// function that maps a species to its index in the palette.
+func gifPalette(rec *recording) (color.Palette, func(s species) uint8) {
+	count := make(map[species]int)
+	for x := 0; x < gridDimX; x++ {
+		for y := 0; y < gridDimY; y++ {
+			if s := rec.base[x][y]; s != "" {
+				count[s]++
+			}
+		}
+	}
+	for _, df := range rec.diffs {
+		for _, ydiff := range df {
+			for _, s := range ydiff {
+				if s != "" {
+					count[s]++
+				}
+			}
+		}
+	}
+	ss := make([]species, 0, len(count))
+	for s := range count {
+		ss = append(ss, s)
+	}
+	sort.Slice(ss, func(i, j int) bool {
+		if count[ss[i]] != count[ss[j]] {
+			return count[ss[i]] > count[ss[j]]
+		}
+		return ss[i] < ss[j]
+	})
+	pal := color.Palette{deadCellColor, gridLineColor}
+	indexOf := make(map[species]uint8)
+	for _, s := range ss {
+		if len(pal) == 256 {
+			break
+		}
+		indexOf[s] = uint8(len(pal))
+		pal = append(pal, speciesColor(s))
+	}
+	return pal, func(s species) uint8 {
+		idx, ok := indexOf[s]
+		if !ok {
+			// Skip the dead cell and grid line colors when searching for the
+			// closest color.
+			idx = uint8(2 + color.Palette(pal[2:]).Index(speciesColor(s)))
+			indexOf[s] = idx
+		}
+		return idx
+	}
+}
+
+// readRecording reads a recorded session, which is the sequence of messages
+// that a client receives, one per line, in either version of the protocol.
+// The first grid or diff message must be a grid. Empty diffs and other
+// messages are skipped, and a later grid (as received after reconnecting) is
+// converted into a diff from the preceding generation. Grids and diffs are
+// validated like those sent by the server, so that a corrupt recording can't
+// write outside the grid.
+func readRecording(r io.Reader) (*recording, error) {
+	sc := bufio.NewScanner(r)
+	// A grid message is larger than the scanner's default limit.
+	sc.Buffer(make([]byte, 0, 1<<20), 16<<20)
+	var rec *recording
+	var current grid
+	for sc.Scan() {
+		g, df, err := decodeRecordedMessage(sc.Bytes())
+		if err != nil {
+			return nil, err
+		}
+		if g != nil {
+			if rec == nil {
+				rec = &recording{base: g}
+				current = *g
+				continue
+			}
+			df = make(diff)
+			for x := 0; x < gridDimX; x++ {
+				for y := 0; y < gridDimY; y++ {
+					if g[x][y] != current[x][y] {
+						getOrMakeYDiff(df, x)[y] = g[x][y]
+					}
+				}
+			}
+		}
+		if df == nil {
+			continue
+		}
+		if rec == nil {
+			return nil, errors.New("recording does not begin with a grid")
+		}
+		if len(df) == 0 {
+			continue
+		}
+		apply(df, &current)
+		rec.diffs = append(rec.diffs, df)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if rec == nil {
+		return nil, errors.New("recording does not begin with a grid")
+	}
+	return rec, nil
+}
+
+// decodeRecordedMessage decodes a line of a recorded session. It returns the
+// grid if the line is a grid or a version 2 init message, the diff if it is
+// a diff, and neither if it is some other message.
+func decodeRecordedMessage(line []byte) (*grid, diff, error) {
+	if len(line) == 0 {
+		return nil, nil, nil
+	}
+	if line[0] == '[' {
+		g := &grid{}
+		if err := json.Unmarshal(line, g); err != nil {
+			return nil, nil, err
+		}
+		return g, nil, validateRecordedGrid(g)
+	}
+	var envelope struct {
+		Type  string          `json:"type"`
+		Grid  *grid           `json:"grid"`
+		Cells json.RawMessage `json:"cells"`
+	}
+	if bytes.HasPrefix(line, []byte("{\"type\"")) {
+		if err := json.Unmarshal(line, &envelope); err != nil {
+			return nil, nil, err
+		}
+		switch envelope.Type {
+		case "init":
+			if envelope.Grid == nil {
+				return nil, nil, errors.New("recording is not of a grid")
+			}
+			return envelope.Grid, nil, validateRecordedGrid(envelope.Grid)
+		case "diff":
+			line = envelope.Cells
+		default:
+			return nil, nil, nil
+		}
+	}
+	df := make(diff)
+	if err := json.Unmarshal(line, &df); err != nil {
+		return nil, nil, err
+	}
+	if len(df) == 0 {
+		return nil, df, nil
+	}
+	return nil, df, validateServerDiff(df)
+}
+
+// validateRecordedGrid checks each cell of a grid read from a recording.
+func validateRecordedGrid(g *grid) error {
+	for x := range g {
+		for _, v := range g[x] {
+			if err := validateServerCell(v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// runGIFCommand implements the "gif" command, which converts a recorded
+// session into an animated GIF.
+func runGIFCommand(args []string) error {
+	fs := flag.NewFlagSet("gif", flag.ContinueOnError)
+	in := fs.String("in", "-", "recorded session to read, or - for standard input")
+	out := fs.String("out", "-", "GIF file to write, or - for standard output")
+	generations := fs.Int("generations", 0,
+		"number of generations at the end of the recording to include (default all)")
+	cellSize := fs.Int("cell", defaultCellSize, "size of each cell in pixels")
+	gridLines := fs.Bool("lines", false, "draw grid lines")
+	delay := fs.Int("delay", defaultFrameDelay, "delay between frames in milliseconds")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %v gif [flags]\n\n"+
+			"Converts a recorded session into an animated GIF. A recorded session is\n"+
+			"the sequence of messages received by a client, one per line.\n\n",
+			os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+	opts := renderOptions{cellSize: *cellSize, gridLines: *gridLines, region: fullRegion}
+	if err := validateRenderOptions(opts); err != nil {
+		return err
+	}
+	if *delay < minFrameDelay || *delay > maxFrameDelay {
+		return errors.New("frame delay is out of range")
+	}
+
+	r := os.Stdin
+	if *in != "-" {
+		f, err := os.Open(*in)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	rec, err := readRecording(r)
+	if err != nil {
+		return err
+	}
+	if *generations > 0 {
+		rec = rec.last(*generations)
+	}
+
+	w := os.Stdout
+	if *out != "-" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	bw := bufio.NewWriter(w)
+	if err := encodeGIF(bw, rec, opts, *delay); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"image/gif"
+	"strings"
+	"testing"
+)
+
+func Test_encodeGIF(t *testing.T) {
+	base := &grid{}
+	base[0][0] = "#ff0000"
+	rec := &recording{base, []diff{
+		{0: {0: ""}, 1: {1: "#00ff00"}},
+		{2: {2: "#0000ff"}},
+	}}
+	opts := renderOptions{cellSize: 2, region: region{0, 0, 3, 3}}
+
+	var buf bytes.Buffer
+	if err := encodeGIF(&buf, rec, opts, 200); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	anim, err := gif.DecodeAll(&buf)
+	if err != nil {
+		t.Fatalf("Unexpected error decoding: %v", err)
+	}
+
+	if len(anim.Image) != 3 {
+		t.Fatalf("Expected 3 frames but got %v", len(anim.Image))
+	}
+	for i, d := range anim.Delay {
+		if d != 20 {
+			t.Errorf("Expected frame %v to have delay 20 but got %v", i, d)
+		}
+	}
+	red := color.RGBA{0xff, 0, 0, 0xff}
+	green := color.RGBA{0, 0xff, 0, 0xff}
+	blue := color.RGBA{0, 0, 0xff, 0xff}
+	tests := []struct {
+		frame    int
+		x, y     int
+		expected color.RGBA
+	}{
+		{0, 0, 0, red},
+		{0, 2, 2, deadCellColor},
+		{1, 0, 0, deadCellColor},
+		{1, 3, 3, green},
+		{2, 3, 3, green},
+		{2, 5, 5, blue},
+	}
+	for _, tt := range tests {
+		r, g, b, a := anim.Image[tt.frame].At(tt.x, tt.y).RGBA()
+		got := color.RGBA{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), uint8(a >> 8)}
+		if got != tt.expected {
+			t.Errorf("Frame %v: expected pixel (%v, %v) to be %v but got %v",
+				tt.frame, tt.x, tt.y, tt.expected, got)
+		}
+	}
+}
+
+// When there are more species than fit in the palette, the least common
+// species should be drawn with the closest color.
+func Test_gifPaletteOverflow(t *testing.T) {
+	base := &grid{}
+	for i := 0; i < 300; i++ {
+		base[i/gridDimY][i%gridDimY] = fmt.Sprintf("#%06x", i)
+	}
+	// Make #0000ff the most common species.
+	base[100][0] = "#0000ff"
+	base[100][1] = "#0000ff"
+
+	pal, index := gifPalette(&recording{base, nil})
+
+	if len(pal) != 256 {
+		t.Fatalf("Expected 256 colors but got %v", len(pal))
+	}
+	if c := pal[2]; c != (color.RGBA{0, 0, 0xff, 0xff}) {
+		t.Errorf("Expected the most common species first but got %v", c)
+	}
+	// #00012b is not in the palette, and the closest color is #00002b.
+	if c := pal[index("#00012b")]; c != (color.RGBA{0, 0, 0x2b, 0xff}) {
+		t.Errorf("Expected the closest color but got %v", c)
+	}
+}
+
+func Test_readRecording(t *testing.T) {
+	empty, _ := json.Marshal(&grid{})
+	g := &grid{}
+	g[3][3] = "#aaaaaa"
+	reconnect, _ := json.Marshal(g)
+	session := strings.Join([]string{
+		string(empty),
+		`{"1":{"1":"#bbbbbb"}}`,
+		`{}`,
+		string(reconnect),
+		`{"3":{"4":"#cccccc"}}`,
+	}, "\n")
+
+	rec, err := readRecording(strings.NewReader(session))
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(rec.diffs) != 3 {
+		t.Fatalf("Expected 3 diffs but got %v", len(rec.diffs))
+	}
+	// The second grid should become a diff that kills (1,1) and births (3,3).
+	if v, ok := rec.diffs[1][1][1]; !ok || v != "" {
+		t.Errorf("Expected (1, 1) to die")
+	}
+	if v := rec.diffs[1][3][3]; v != "#aaaaaa" {
+		t.Errorf("Expected (3, 3) to be born")
+	}
+
+	if _, err := readRecording(strings.NewReader(`{"1":{"1":"#bbbbbb"}}`)); err == nil {
+		t.Errorf("Expected an error for a recording without a grid")
+	}
+	for _, line := range []string{`{"-1":{"1":"#bbbbbb"}}`, `{"1":{"120":"#bbbbbb"}}`, `{"1":{"1":"red"}}`} {
+		if _, err := readRecording(strings.NewReader(string(empty) + "\n" + line)); err == nil {
+			t.Errorf("Expected an error for the diff %v", line)
+		}
+	}
+}
+
+// Recordings in version 2 of the protocol should be read like those in
+// version 1, skipping messages other than grids and diffs.
+func Test_readRecordingV2(t *testing.T) {
+	init := string(marshalInit(&grid{}, nil, 0, defaultConfig(), capabilities{version: protocolV2}))
+	session := strings.Join([]string{
+		init,
+		`{"type":"diff","cells":{"1":{"1":"#bbbbbb"}}}`,
+		`{"type":"chat","player":"p","text":"hi"}`,
+		`{"type":"diff","cells":{"1":{"1":"#bbbbbb:2"}},"generations":2}`,
+		`{"type":"streamEnd"}`,
+	}, "\n")
+
+	rec, err := readRecording(strings.NewReader(session))
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(rec.diffs) != 2 || rec.diffs[1][1][1] != "#bbbbbb:2" {
+		t.Errorf("Expected 2 diffs but got %v", rec.diffs)
+	}
+	bad := init + "\n" + `{"type":"diff","cells":{"-1":{"0":"#bbbbbb"}}}`
+	if _, err := readRecording(strings.NewReader(bad)); err == nil {
+		t.Errorf("Expected an error for a diff outside the grid")
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"fmt"
+	"image/png"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// maxImportSize is the maximum size in bytes of a pattern file that can be
+// imported.
+const maxImportSize = 1 << 20
+
+// requireToken wraps a handler so that it is only called for requests that
+// carry the given bearer token in the Authorization header. If token is
+// empty, every request is rejected.
+func requireToken(token string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token == "" {
+			http.Error(w, "this endpoint is disabled because no token is configured",
+				http.StatusForbidden)
+			return
+		}
+		got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "invalid or missing bearer token", http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// refusePlane responds with an error if the pipeline runs on the plane, which
+// has no grid for endpoints that serve the whole grid, and reports whether it
+// did.
+func refusePlane(w http.ResponseWriter, pl *pipeline) bool {
+	if pl.cfg.topology != plane {
+		return false
+	}
+	http.Error(w, "this endpoint serves the whole grid, so it is not supported on the plane",
+		http.StatusBadRequest)
+	return true
+}
+
+// exportFileNames maps each export format to the name of the downloaded file.
+var exportFileNames = map[string]string{
+	formatRLE:       "board.rle",
+	formatPlaintext: "board.cells",
+	formatLife106:   "board.lif",
+}
+
+// handleExport serves the current grid as a pattern file. The "format" query
+// parameter selects the format, and defaults to RLE. For RLE, species are
+// written as separate states unless the "species" query parameter is "0".
+// Exports aren't supported on the plane.
+func handleExport(pl *pipeline) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if refusePlane(w, pl) {
+			return
+		}
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = formatRLE
+		}
+		fileName, ok := exportFileNames[format]
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown pattern format (%v)", format),
+				http.StatusBadRequest)
+			return
+		}
+		withSpecies := r.URL.Query().Get("species") != "0"
+		var buf bytes.Buffer
+		if err := writeGrid(&buf, pl.currentGrid(), format, withSpecies); err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Content-Disposition", "attachment; filename="+fileName)
+		w.Write(buf.Bytes())
+	}
+}
+
+// handleImport merges a pattern file in the request body into the grid. The
+// "format" query parameter selects the format, and is detected from the file
+// if omitted. The "x" and "y" query parameters give the position of the
+// pattern's top-left corner, and default to 0. Cells that the file doesn't
+// give a species are set to the "species" query parameter.
+func handleImport(pl *pipeline) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxImportSize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		q := r.URL.Query()
+		format := q.Get("format")
+		if format == "" {
+			format = detectFormat(data)
+		}
+		x, err := intQueryParam(q.Get("x"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		y, err := intQueryParam(q.Get("y"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		p, err := parsePattern("import", format, bytes.NewReader(data))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		df, err := placePattern(p, x, y, q.Get("species"), pl.cfg.topology)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		pl.readPumpOut <- &mergeDiff{df, ""}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// defaultCellSize is the number of pixels per cell in rendered images unless
+// otherwise requested.
+const defaultCellSize = 4
+
+// handleSnapshot serves the current grid as a PNG image. The grid is copied
+// from gol and then rendered, so ticks are only held up for as long as the
+// copy takes. See renderOptionsFromQuery for the supported query parameters.
+// Images larger than maxSnapshotPixels are refused, as are snapshots of the
+// plane.
+func handleSnapshot(pl *pipeline) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if refusePlane(w, pl) {
+			return
+		}
+		opts, err := renderOptionsFromQuery(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if imagePixels(opts) > maxSnapshotPixels {
+			http.Error(w, "image is too large; reduce the cell size or region", http.StatusBadRequest)
+			return
+		}
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, renderGrid(pl.currentGrid(), opts)); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write(buf.Bytes())
+	}
+}
+
+// defaultTimeLapseLen is the number of generations in a time-lapse unless
+// otherwise requested.
+const defaultTimeLapseLen = 100
+
+// handleTimeLapse serves an animated GIF of recent generations. The query
+// parameter "generations" sets the number of frames, and "delay" sets the time
+// between frames in milliseconds. See renderOptionsFromQuery for the other
+// supported query parameters. Time-lapses larger than maxTimeLapsePixels, over
+// all frames, are refused, as are time-lapses of the plane.
+func handleTimeLapse(pl *pipeline) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if refusePlane(w, pl) {
+			return
+		}
+		q := r.URL.Query()
+		opts, err := renderOptionsFromQuery(q)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		n := defaultTimeLapseLen
+		if v := q.Get("generations"); v != "" {
+			if n, err = intQueryParam(v); err != nil || n < 1 {
+				http.Error(w, "malformed generations", http.StatusBadRequest)
+				return
+			}
+		}
+		if n > maxTimeLapsePixels/imagePixels(opts) {
+			http.Error(w, "time-lapse is too large; reduce the generations, cell size, or region",
+				http.StatusBadRequest)
+			return
+		}
+		delay := defaultFrameDelay
+		if v := q.Get("delay"); v != "" {
+			delay, err = intQueryParam(v)
+			if err != nil || delay < minFrameDelay || delay > maxFrameDelay {
+				http.Error(w, "malformed or out of range delay", http.StatusBadRequest)
+				return
+			}
+		}
+		var buf bytes.Buffer
+		if err := encodeGIF(&buf, pl.recentHistory().last(n), opts, delay); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "image/gif")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write(buf.Bytes())
+	}
+}
+
+// renderOptionsFromQuery reads renderOptions from the query parameters "cell"
+// (the cell size in pixels), "lines" ("1" to draw grid lines), and "x", "y",
+// "width", and "height" (the region of the grid to draw, which defaults to
+// the entire grid).
+func renderOptionsFromQuery(q url.Values) (renderOptions, error) {
+	opts := renderOptions{
+		cellSize:  defaultCellSize,
+		gridLines: q.Get("lines") == "1",
+		region:    fullRegion,
+	}
+	for _, p := range []struct {
+		name string
+		v    *int
+	}{
+		{"cell", &opts.cellSize},
+		{"x", &opts.region.x},
+		{"y", &opts.region.y},
+		{"width", &opts.region.width},
+		{"height", &opts.region.height},
+	} {
+		if v := q.Get(p.name); v != "" {
+			n, err := intQueryParam(v)
+			if err != nil {
+				return opts, err
+			}
+			*p.v = n
+		}
+	}
+	if q.Get("width") == "" {
+		opts.region.width = gridDimY - opts.region.y
+	}
+	if q.Get("height") == "" {
+		opts.region.height = gridDimX - opts.region.x
+	}
+	return opts, validateRenderOptions(opts)
+}
+
+// intQueryParam parses an integer query parameter, which defaults to 0.
+func intQueryParam(v string) (int, error) {
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("malformed integer (%v)", v)
+	}
+	return n, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Importing a pattern should require the admin token, and an imported
+// pattern should be included in the export after the next tick.
+func Test_importExport(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.adminToken = "secret"
+	golChan := make(chan interface{})
+	pl := startPipelineInternal(cfg, golChan, golChan)
+	importHandler := requireToken(cfg.adminToken, handleImport(pl))
+	exportHandler := handleExport(pl)
+
+	body := "#C species A #aaaaaa\nx = 3, y = 1\n3A!"
+
+	req := httptest.NewRequest("POST", "/import?x=10&y=20", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	importHandler(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %v but got %v", http.StatusUnauthorized, rec.Code)
+	}
+
+	req = httptest.NewRequest("POST", "/import?x=10&y=20", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer secret")
+	rec = httptest.NewRecorder()
+	importHandler(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("Expected status %v but got %v: %v", http.StatusNoContent,
+			rec.Code, rec.Body.String())
+	}
+
+	send[interface{}](t, golChan, &tick{})
+
+	req = httptest.NewRequest("GET", "/export?format=life106", nil)
+	rec = httptest.NewRecorder()
+	exportHandler(rec, req)
+	expected := "#Life 1.06\n20 10\n21 10\n22 10\n"
+	if rec.Body.String() != expected {
+		t.Errorf("Expected %q but got %q", expected, rec.Body.String())
+	}
+}
+
+// Snapshots and time-lapses that would take too much memory to render should
+// be refused.
+func Test_renderLimits(t *testing.T) {
+	golChan := make(chan interface{})
+	pl := startPipelineInternal(defaultConfig(), golChan, golChan)
+	tests := []struct {
+		h      http.HandlerFunc
+		target string
+		code   int
+	}{
+		{handleSnapshot(pl), "/snapshot.png", http.StatusOK},
+		{handleSnapshot(pl), "/snapshot.png?cell=16", http.StatusOK},
+		{handleSnapshot(pl), "/snapshot.png?cell=32", http.StatusBadRequest},
+		{handleSnapshot(pl), "/snapshot.png?cell=32&width=60&height=60", http.StatusOK},
+		{handleTimeLapse(pl), "/timelapse.gif", http.StatusOK},
+		{handleTimeLapse(pl), "/timelapse.gif?cell=32&generations=1000", http.StatusBadRequest},
+		{handleTimeLapse(pl), "/timelapse.gif?generations=9223372036854775807", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		test.h(rec, httptest.NewRequest("GET", test.target, nil))
+		if rec.Code != test.code {
+			t.Errorf("%v: expected status %v but got %v", test.target, test.code, rec.Code)
+		}
+	}
+}
+
+// Endpoints that serve the whole grid should be refused on the plane.
+func Test_handlersPlane(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.topology = plane
+	golChan := make(chan interface{})
+	pl := startPipelineInternal(cfg, golChan, golChan)
+	tests := []struct {
+		h      http.HandlerFunc
+		target string
+	}{
+		{handleExport(pl), "/export"},
+		{handleSnapshot(pl), "/snapshot.png"},
+		{handleTimeLapse(pl), "/timelapse.gif"},
+		{handleAPIGrid(pl), "/api/grid"},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		test.h(rec, httptest.NewRequest("GET", test.target, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%v: expected status %v but got %v", test.target, http.StatusBadRequest, rec.Code)
+		}
+	}
+}
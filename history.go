@@ -0,0 +1,83 @@
+package main
+
+// history records the most recent diffs broadcast by gol, so that the last
+// several generations can be replayed.
+type history struct {
+	// base is the grid before the first diff in diffs was applied.
+	base grid
+	// diffs holds the recorded diffs, oldest first. Recorded diffs are never
+	// modified, so slices of diffs can be shared with other goroutines.
+	diffs []diff
+	// max is the maximum number of diffs to record.
+	max int
+	// count is the number of diffs that have ever been passed to record.
+	// Generation n is the grid after n diffs, so count is also the current
+	// generation.
+	count int
+}
+
+// record appends a copy of a diff to the history, discarding the oldest diff
+// if the history is full.
+func (h *history) record(df diff) {
+	h.count++
+	if h.max <= 0 {
+		return
+	}
+	if len(h.diffs) == h.max {
+		apply(h.diffs[0], &h.base)
+		h.diffs = h.diffs[1:]
+	}
+	h.diffs = append(h.diffs, copyDiff(df))
+}
+
+// since returns the recorded diffs that follow generation gen, oldest first.
+// ok is false if the history no longer holds all of them, or if gen is later
+// than the current generation.
+func (h *history) since(gen int) (diffs []diff, ok bool) {
+	first := h.count - len(h.diffs)
+	if gen < first || gen > h.count {
+		return nil, false
+	}
+	return h.diffs[gen-first:], true
+}
+
+// recording is a sequence of generations that starts from a grid.
+type recording struct {
+	base  *grid
+	diffs []diff
+}
+
+// snapshot returns a recording of the history. The recording's base is a
+// copy, and its diffs are shared with the history.
+func (h *history) snapshot() *recording {
+	base := h.base
+	return &recording{&base, h.diffs}
+}
+
+// last returns a recording of the final n generations of r, where the first
+// generation is the starting grid. Applying diffs to the returned base grid
+// does not affect r.
+func (r *recording) last(n int) *recording {
+	base := *r.base
+	skip := len(r.diffs) + 1 - n
+	if skip < 0 {
+		skip = 0
+	}
+	for _, df := range r.diffs[:skip] {
+		apply(df, &base)
+	}
+	return &recording{&base, r.diffs[skip:]}
+}
+
+// getHistory requests a recording of the history, which gol sends on reply.
+// reply should be buffered so that gol doesn't block.
+type getHistory struct {
+	reply chan<- *recording
+}
+
+// recentHistory returns a recording of the generations retained by gol.
+func (pl *pipeline) recentHistory() *recording {
+	reply := make(chan *recording, 1)
+	pl.golChan <- &getHistory{reply}
+	return <-reply
+}
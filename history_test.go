@@ -0,0 +1,60 @@
+package main
+
+import "testing"
+
+func Test_history(t *testing.T) {
+	h := &history{max: 2}
+	df := make(diff)
+	for i := 0; i < 3; i++ {
+		df[i] = map[int]species{0: "a"}
+		h.record(df)
+		flush(df, &grid{})
+	}
+
+	rec := h.snapshot()
+
+	// The first diff should have been merged into the base grid.
+	if v := rec.base[0][0]; v != "a" {
+		t.Errorf("Expected (0, 0) to be \"a\" but got %q", v)
+	}
+	if v := rec.base[1][0]; v != "" {
+		t.Errorf("Expected (1, 0) to be \"\" but got %q", v)
+	}
+	if len(rec.diffs) != 2 {
+		t.Fatalf("Expected 2 diffs but got %v", len(rec.diffs))
+	}
+	// Recorded diffs should be copies.
+	if v := rec.diffs[1][2][0]; v != "a" {
+		t.Errorf("Expected (2, 0) to be \"a\" but got %q", v)
+	}
+
+	// Changing the snapshot should not change the history.
+	rec.base[5][5] = "b"
+	if v := h.base[5][5]; v != "" {
+		t.Errorf("Expected (5, 5) to be \"\" but got %q", v)
+	}
+}
+
+func Test_recordingLast(t *testing.T) {
+	rec := &recording{&grid{}, []diff{
+		{0: {0: "a"}},
+		{1: {0: "b"}},
+		{2: {0: "c"}},
+	}}
+
+	last := rec.last(2)
+
+	if len(last.diffs) != 1 {
+		t.Fatalf("Expected 1 diff but got %v", len(last.diffs))
+	}
+	if last.base[0][0] != "a" || last.base[1][0] != "b" || last.base[2][0] != "" {
+		t.Errorf("Incorrect base grid")
+	}
+	if rec.base[0][0] != "" {
+		t.Errorf("Expected original base grid to be unchanged")
+	}
+
+	if all := rec.last(10); len(all.diffs) != 3 || all.base[0][0] != "" {
+		t.Errorf("Expected the entire recording")
+	}
+}
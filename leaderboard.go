@@ -0,0 +1,140 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// defaultLeaderboardInterval is the default value of
+// config.leaderboardInterval. At the usual tick rate, it is about 5 seconds.
+const defaultLeaderboardInterval = 30
+
+// leaderboardLen is the number of species on the leaderboard.
+const leaderboardLen = 10
+
+// leaderboardHistoryLen is how far back the leaderboard history goes.
+const leaderboardHistoryLen = time.Hour
+
+// population counts the live cells of each species.
+type population map[species]int
+
+// advance updates the counts for the generation produced by applying df to
+// the grid. counted holds the species that each cell is counted as, which is
+// "" for cells that aren't counted, and advance updates it along with the
+// counts. A cell is counted as its species if it is live and isFading, which
+// may be nil, doesn't report it as being recolored by debris cleanup.
+func (pop population) advance(counted *grid, df diff, isFading func(p point, s species) bool) {
+	for x, ydiff := range df {
+		for y, s := range ydiff {
+			if old := counted[x][y]; old != "" {
+				pop[old]--
+				if pop[old] == 0 {
+					delete(pop, old)
+				}
+			}
+			if !isLive(s) || (isFading != nil && isFading(point{x, y}, s)) {
+				s = ""
+			}
+			counted[x][y] = s
+			if s != "" {
+				pop[s]++
+			}
+		}
+	}
+}
+
+// advancePlane is the counterpart of advance for the plane, where no cells
+// are recolored by debris cleanup, so the world itself holds the species that
+// each cell is counted as. It must be called before df is flushed into w.
+func (pop population) advancePlane(w *world, df planeDiff) {
+	for x, ydiff := range df {
+		for y, s := range ydiff {
+			if old := w.get(x, y); isLive(old) {
+				pop[old]--
+				if pop[old] == 0 {
+					delete(pop, old)
+				}
+			}
+			if isLive(s) {
+				pop[s]++
+			}
+		}
+	}
+}
+
+// speciesCount is the number of live cells of a species.
+type speciesCount struct {
+	Species species `json:"species"`
+	Cells   int     `json:"cells"`
+}
+
+// top returns the n most populous species, most populous first.
+func (pop population) top(n int) []speciesCount {
+	list := make([]speciesCount, 0, len(pop))
+	for s, c := range pop {
+		list = append(list, speciesCount{s, c})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Cells != list[j].Cells {
+			return list[i].Cells > list[j].Cells
+		}
+		return list[i].Species < list[j].Species
+	})
+	if len(list) > n {
+		list = list[:n]
+	}
+	return list
+}
+
+// leaderboardSample is the leaderboard at a point in time.
+type leaderboardSample struct {
+	Time    time.Time      `json:"time"`
+	Species []speciesCount `json:"species"`
+}
+
+// leaderboard keeps the samples taken over the last hour.
+type leaderboard struct {
+	samples []leaderboardSample
+}
+
+// record adds a sample and discards samples that are more than an hour
+// older.
+func (lb *leaderboard) record(sample leaderboardSample) {
+	lb.samples = append(lb.samples, sample)
+	cutoff := sample.Time.Add(-leaderboardHistoryLen)
+	i := 0
+	for i < len(lb.samples) && lb.samples[i].Time.Before(cutoff) {
+		i++
+	}
+	// Copy rather than reslice so that the backing array doesn't grow
+	// forever.
+	if i > 0 {
+		lb.samples = append([]leaderboardSample(nil), lb.samples[i:]...)
+	}
+}
+
+// leaderboardMessage is the JSON representation of the leaderboard broadcast
+// to clients.
+type leaderboardMessage struct {
+	Type    string         `json:"type"`
+	Species []speciesCount `json:"species"`
+}
+
+// leaderboardReport is the current leaderboard along with its history.
+type leaderboardReport struct {
+	Species []speciesCount      `json:"species"`
+	History []leaderboardSample `json:"history"`
+}
+
+// getLeaderboard requests a leaderboardReport, which gol sends on reply.
+// reply should be buffered so that gol doesn't block.
+type getLeaderboard struct {
+	reply chan<- *leaderboardReport
+}
+
+// currentLeaderboard returns the current leaderboard along with its history.
+func (pl *pipeline) currentLeaderboard() *leaderboardReport {
+	reply := make(chan *leaderboardReport, 1)
+	pl.golChan <- &getLeaderboard{reply}
+	return <-reply
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Counts updated from diffs should match counts taken from the grid.
+func Test_populationAdvance(t *testing.T) {
+	g, df := &grid{}, make(diff)
+	pop, counted := make(population), &grid{}
+	rs := testRuleset()
+	merge(diff{
+		10: {10: "#aaaaaa", 11: "#aaaaaa", 12: "#bbbbbb"},
+		30: {30: "#cccccc", 31: "#cccccc"},
+		31: {30: "#cccccc", 31: "#cccccc"},
+	}, df)
+	for i := 0; i < 5; i++ {
+		pop.advance(counted, df, nil)
+		flush(df, g)
+		nextState(g, df, rs)
+	}
+
+	want := make(population)
+	for x := 0; x < gridDimX; x++ {
+		for y := 0; y < gridDimY; y++ {
+			if s := g[x][y]; s != "" {
+				want[s]++
+			}
+		}
+	}
+	if len(pop) != len(want) {
+		t.Errorf("Expected %v but got %v", want, pop)
+	}
+	for s, n := range want {
+		if pop[s] != n {
+			t.Errorf("Expected %v cells of %v but got %v", n, s, pop[s])
+		}
+	}
+}
+
+func Test_populationTop(t *testing.T) {
+	pop := population{"#aaaaaa": 3, "#bbbbbb": 5, "#cccccc": 3, "#dddddd": 1}
+	top := pop.top(3)
+	want := []speciesCount{{"#bbbbbb", 5}, {"#aaaaaa", 3}, {"#cccccc", 3}}
+	if len(top) != len(want) {
+		t.Fatalf("Expected %v but got %v", want, top)
+	}
+	for i := range want {
+		if top[i] != want[i] {
+			t.Errorf("Expected %v but got %v", want, top)
+		}
+	}
+}
+
+func Test_leaderboardRecord(t *testing.T) {
+	lb := &leaderboard{}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i <= 90; i += 15 {
+		lb.record(leaderboardSample{Time: start.Add(time.Duration(i) * time.Minute)})
+	}
+	// Samples at 30, 45, 60, 75, and 90 minutes are within the last hour.
+	if len(lb.samples) != 5 {
+		t.Errorf("Expected 5 samples but got %v", len(lb.samples))
+	}
+	if !lb.samples[0].Time.Equal(start.Add(30 * time.Minute)) {
+		t.Errorf("Expected the oldest sample to be at 30 minutes but got %v", lb.samples[0].Time)
+	}
+}
@@ -0,0 +1,467 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The file formats that patterns can be imported from and the grid can be
+// exported to. See https://conwaylife.com/wiki/File_formats.
+const (
+	formatRLE       = "rle"
+	formatPlaintext = "cells"
+	formatLife106   = "life106"
+)
+
+// maxRLEState is the highest cell state that can be written in RLE.
+const maxRLEState = 255
+
+// maxPatternDim is the largest width and height of an RLE or Life 1.06
+// pattern. Run counts let a few bytes of RLE describe a huge pattern, so the
+// dimensions bound the memory that parsing can take, and Life 1.06 coordinates
+// are bounded by it so that they can be shifted without overflowing.
+const maxPatternDim = 1024
+
+// detectFormat guesses the format of a pattern file from its contents.
+func detectFormat(data []byte) string {
+	s := strings.TrimSpace(string(data))
+	switch {
+	case strings.HasPrefix(s, "#Life 1.06"):
+		return formatLife106
+	case strings.HasPrefix(s, "!"), strings.HasPrefix(s, "."), strings.HasPrefix(s, "O"):
+		return formatPlaintext
+	default:
+		return formatRLE
+	}
+}
+
+// parsePattern reads a pattern in the given format.
+func parsePattern(name string, format string, r io.Reader) (*pattern, error) {
+	switch format {
+	case formatRLE:
+		return parseRLE(name, r)
+	case formatPlaintext:
+		return parsePlaintext(name, r)
+	case formatLife106:
+		return parseLife106(name, r)
+	default:
+		return nil, fmt.Errorf("unknown pattern format (%v)", format)
+	}
+}
+
+// parseRLE reads a pattern in the run length encoded format used by Golly and
+// the LifeWiki. See https://conwaylife.com/wiki/Run_Length_Encoded. The rule in
+// the header line is ignored. Cells in any state other than dead are live.
+//
+// In addition to the standard format, parseRLE understands comment lines of
+// the form "#C species A #aaaaaa", which give the species of the cells in a
+// multi-state pattern. Cells in states without such a comment have no species.
+func parseRLE(name string, r io.Reader) (*pattern, error) {
+	p := &pattern{name: name}
+	speciesOf := make(map[int]species)
+	sc := bufio.NewScanner(r)
+	isHeaderRead := false
+	isEndRead := false
+	x, y := 0, 0
+	run := 0
+	// prefix holds the first character of a two-character state, or 0.
+	var prefix rune
+	for sc.Scan() && !isEndRead {
+		line := strings.TrimSpace(sc.Text())
+		if strings.HasPrefix(line, "#C species ") {
+			s, sp, err := parseSpeciesComment(line)
+			if err != nil {
+				return nil, err
+			}
+			speciesOf[s] = sp
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !isHeaderRead {
+			w, h, err := parseRLEHeader(line)
+			if err != nil {
+				return nil, err
+			}
+			p.width, p.height = w, h
+			isHeaderRead = true
+			continue
+		}
+		for _, c := range line {
+			if prefix != 0 && (c < 'A' || c > 'X') {
+				return nil, fmt.Errorf("unexpected character %q after %q in RLE data", c, prefix)
+			}
+			state := -1
+			switch {
+			case c >= '0' && c <= '9':
+				run = run*10 + int(c-'0')
+				if run > p.width && run > p.height {
+					return nil, errRLEBounds
+				}
+				continue
+			case c == ' ' || c == '\t':
+				continue
+			case c >= 'p' && c <= 'y' && prefix == 0:
+				prefix = c
+				continue
+			case c == '!':
+				isEndRead = true
+			case c == '$':
+				x += runOrOne(run)
+				y = 0
+				if x > p.height {
+					return nil, errRLEBounds
+				}
+			case c == 'b' || c == '.':
+				y += runOrOne(run)
+				if y > p.width {
+					return nil, errRLEBounds
+				}
+			case c >= 'A' && c <= 'X':
+				state = int(c-'A') + 1
+				if prefix != 0 {
+					state += int(prefix-'p'+1) * 24
+				}
+			case c >= 'a' && c <= 'z':
+				state = 1
+			default:
+				return nil, fmt.Errorf("unexpected character %q in RLE data", c)
+			}
+			if state > 0 && (x >= p.height || y+runOrOne(run) > p.width) {
+				return nil, errRLEBounds
+			}
+			for i := 0; state > 0 && i < runOrOne(run); i++ {
+				p.cells = append(p.cells, patternCell{point{x, y}, speciesOf[state]})
+				y++
+			}
+			if isEndRead {
+				break
+			}
+			run = 0
+			prefix = 0
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if !isHeaderRead {
+		return nil, errors.New("missing RLE header line")
+	}
+	if err := checkPatternBounds(p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// errRLEBounds is returned by parseRLE for data that exceeds the dimensions in
+// the header line. It is checked for each run as the data is read, so that a
+// long run can't allocate more cells than the header allows.
+var errRLEBounds = errors.New("pattern data exceeds the dimensions in the header line")
+
+func runOrOne(run int) int {
+	if run == 0 {
+		return 1
+	}
+	return run
+}
+
+// parseRLEHeader parses a line of the form "x = m, y = n, rule = abc" and
+// returns m and n.
+func parseRLEHeader(line string) (width int, height int, err error) {
+	isWidthRead, isHeightRead := false, false
+	for _, field := range strings.Split(line, ",") {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			return 0, 0, fmt.Errorf("malformed RLE header line %q", line)
+		}
+		k, v := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		switch k {
+		case "x":
+			width, err = strconv.Atoi(v)
+			isWidthRead = true
+		case "y":
+			height, err = strconv.Atoi(v)
+			isHeightRead = true
+		}
+		if err != nil {
+			return 0, 0, fmt.Errorf("malformed RLE header line %q", line)
+		}
+	}
+	if !isWidthRead || !isHeightRead || width < 0 || height < 0 {
+		return 0, 0, fmt.Errorf("malformed RLE header line %q", line)
+	}
+	if width > maxPatternDim || height > maxPatternDim {
+		return 0, 0, fmt.Errorf("pattern is larger than %vx%v", maxPatternDim, maxPatternDim)
+	}
+	return width, height, nil
+}
+
+// parseSpeciesComment parses a line of the form "#C species A #aaaaaa" and
+// returns the state and species that it associates.
+func parseSpeciesComment(line string) (int, species, error) {
+	fields := strings.Fields(line)
+	if len(fields) != 4 || !hexColorCode.MatchString(fields[3]) {
+		return 0, "", fmt.Errorf("malformed species comment %q", line)
+	}
+	for s := 1; s <= maxRLEState; s++ {
+		if rleState(s) == fields[2] {
+			return s, fields[3], nil
+		}
+	}
+	return 0, "", fmt.Errorf("malformed species comment %q", line)
+}
+
+// rleState returns the characters that represent a cell state in multi-state
+// RLE.
+func rleState(s int) string {
+	if s <= 24 {
+		return string(rune('A' + s - 1))
+	}
+	return string(rune('p'+(s-25)/24)) + string(rune('A'+(s-25)%24))
+}
+
+// parsePlaintext reads a pattern in the plaintext format, where each line is
+// a row, "." is a dead cell, and "O" is a live cell. Lines beginning with "!"
+// are comments. See https://conwaylife.com/wiki/Plaintext.
+func parsePlaintext(name string, r io.Reader) (*pattern, error) {
+	p := &pattern{name: name}
+	sc := bufio.NewScanner(r)
+	x := 0
+	for sc.Scan() {
+		line := strings.TrimRight(sc.Text(), " \t\r")
+		if strings.HasPrefix(line, "!") {
+			continue
+		}
+		for y, c := range line {
+			switch c {
+			case '.':
+			case 'O', '*':
+				p.cells = append(p.cells, patternCell{point: point{x, y}})
+			default:
+				return nil, fmt.Errorf("unexpected character %q in plaintext data", c)
+			}
+		}
+		if len(line) > p.width {
+			p.width = len(line)
+		}
+		x++
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	p.height = x
+	return p, nil
+}
+
+// parseLife106 reads a pattern in the Life 1.06 format, where each line gives
+// the coordinates of a live cell as a column followed by a row. Lines beginning
+// with "#" are comments. The coordinates are taken as offsets from the
+// top-left corner of the pattern, unless some are negative, in which case the
+// pattern is shifted so that the lowest column and row are zero. Coordinates
+// may be at most maxPatternDim from zero, and the pattern at most
+// maxPatternDim wide and high. See https://conwaylife.com/wiki/Life_1.06.
+func parseLife106(name string, r io.Reader) (*pattern, error) {
+	p := &pattern{name: name}
+	sc := bufio.NewScanner(r)
+	minX, minY := 0, 0
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("malformed Life 1.06 line %q", line)
+		}
+		col, err1 := strconv.Atoi(fields[0])
+		row, err2 := strconv.Atoi(fields[1])
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("malformed Life 1.06 line %q", line)
+		}
+		if row < -maxPatternDim || row > maxPatternDim || col < -maxPatternDim || col > maxPatternDim {
+			return nil, fmt.Errorf("Life 1.06 line %q is more than %v cells from the origin", line, maxPatternDim)
+		}
+		p.cells = append(p.cells, patternCell{point: point{row, col}})
+		if row < minX {
+			minX = row
+		}
+		if col < minY {
+			minY = col
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	for i := range p.cells {
+		c := &p.cells[i]
+		c.x -= minX
+		c.y -= minY
+		if c.x >= p.height {
+			p.height = c.x + 1
+		}
+		if c.y >= p.width {
+			p.width = c.y + 1
+		}
+	}
+	if p.width > maxPatternDim || p.height > maxPatternDim {
+		return nil, fmt.Errorf("pattern is larger than %vx%v", maxPatternDim, maxPatternDim)
+	}
+	return p, nil
+}
+
+func checkPatternBounds(p *pattern) error {
+	for _, c := range p.cells {
+		if c.x >= p.height || c.y >= p.width {
+			return errors.New("pattern data exceeds the dimensions in the header line")
+		}
+	}
+	return nil
+}
+
+// writeGrid writes the grid in the given format. If withSpecies is true and
+// the format is RLE, each species is written as a separate state, and the
+// species of each state is written in a comment. See parseRLE.
+func writeGrid(w io.Writer, g *grid, format string, withSpecies bool) error {
+	// Pattern files can't hold the dying cells of Generations rules, so they
+	// are written as dead.
+	live := *g
+	for x := range live {
+		for y, s := range live[x] {
+			if !isLive(s) {
+				live[x][y] = ""
+			}
+		}
+	}
+	g = &live
+	switch format {
+	case formatRLE:
+		return writeRLE(w, g, withSpecies)
+	case formatPlaintext:
+		return writePlaintext(w, g)
+	case formatLife106:
+		return writeLife106(w, g)
+	default:
+		return fmt.Errorf("unknown pattern format (%v)", format)
+	}
+}
+
+func writeRLE(w io.Writer, g *grid, withSpecies bool) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "#N multi-life")
+	stateOf := make(map[species]int)
+	if withSpecies {
+		var ss []species
+		for x := 0; x < gridDimX; x++ {
+			for y := 0; y < gridDimY; y++ {
+				if s := g[x][y]; s != "" && stateOf[s] == 0 {
+					stateOf[s] = 1
+					ss = append(ss, s)
+				}
+			}
+		}
+		if len(ss) > maxRLEState {
+			return fmt.Errorf("the grid has too many species to write as "+
+				"multi-state RLE (%v)", len(ss))
+		}
+		sort.Strings(ss)
+		for i, s := range ss {
+			stateOf[s] = i + 1
+			fmt.Fprintf(bw, "#C species %v %v\n", rleState(i+1), s)
+		}
+		fmt.Fprintf(bw, "x = %v, y = %v\n", gridDimY, gridDimX)
+	} else {
+		fmt.Fprintf(bw, "x = %v, y = %v, rule = B3/S23\n", gridDimY, gridDimX)
+	}
+	rw := &rleWriter{w: bw}
+	// rows is the number of rows that have ended since the last run was
+	// written.
+	rows := 0
+	for x := 0; x < gridDimX; x++ {
+		y := 0
+		for y < gridDimY {
+			s := g[x][y]
+			n := 1
+			for y+n < gridDimY && g[x][y+n] == s {
+				n++
+			}
+			if s == "" && y+n == gridDimY {
+				// Omit dead cells at the end of a row.
+				break
+			}
+			if rows > 0 {
+				rw.write(rows, "$")
+				rows = 0
+			}
+			switch {
+			case s == "":
+				rw.write(n, "b")
+			case withSpecies:
+				rw.write(n, rleState(stateOf[s]))
+			default:
+				rw.write(n, "o")
+			}
+			y += n
+		}
+		rows++
+	}
+	rw.write(1, "!")
+	fmt.Fprintln(bw)
+	return bw.Flush()
+}
+
+// rleWriter writes runs of RLE data, breaking lines so that they don't exceed
+// 70 characters.
+type rleWriter struct {
+	w       *bufio.Writer
+	lineLen int
+}
+
+func (rw *rleWriter) write(n int, tag string) {
+	run := tag
+	if n > 1 {
+		run = strconv.Itoa(n) + tag
+	}
+	if rw.lineLen+len(run) > 70 {
+		rw.w.WriteByte('\n')
+		rw.lineLen = 0
+	}
+	rw.w.WriteString(run)
+	rw.lineLen += len(run)
+}
+
+func writePlaintext(w io.Writer, g *grid) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "!Name: multi-life")
+	for x := 0; x < gridDimX; x++ {
+		row := make([]byte, gridDimY)
+		for y := 0; y < gridDimY; y++ {
+			if g[x][y] == "" {
+				row[y] = '.'
+			} else {
+				row[y] = 'O'
+			}
+		}
+		bw.Write(row)
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
+
+func writeLife106(w io.Writer, g *grid) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "#Life 1.06")
+	for x := 0; x < gridDimX; x++ {
+		for y := 0; y < gridDimY; y++ {
+			if g[x][y] != "" {
+				fmt.Fprintf(bw, "%v %v\n", y, x)
+			}
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func Test_parseRLE(t *testing.T) {
+	rle := "#N Glider\n#C A comment\nx = 3, y = 3, rule = B3/S23\nbob$2bo$3o!\n"
+
+	p, err := parseRLE("glider", strings.NewReader(rle))
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if p.width != 3 || p.height != 3 {
+		t.Errorf("Expected 3x3 pattern but got %vx%v", p.width, p.height)
+	}
+	expected := []point{{0, 1}, {1, 2}, {2, 0}, {2, 1}, {2, 2}}
+	if len(p.cells) != len(expected) {
+		t.Fatalf("Expected cells %v but got %v", expected, p.cells)
+	}
+	for i, c := range expected {
+		if p.cells[i].point != c {
+			t.Errorf("Expected cells %v but got %v", expected, p.cells)
+		}
+	}
+}
+
+func Test_parseRLEMultiLine(t *testing.T) {
+	// Line breaks do not end a row, and "$" may be preceded by a run count to
+	// skip blank rows.
+	rle := "x = 4, y = 3\n2o\n2o2$3bo!"
+
+	p, err := parseRLE("p", strings.NewReader(rle))
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []point{{0, 0}, {0, 1}, {0, 2}, {0, 3}, {2, 3}}
+	if len(p.cells) != len(expected) {
+		t.Fatalf("Expected cells %v but got %v", expected, p.cells)
+	}
+	for i, c := range expected {
+		if p.cells[i].point != c {
+			t.Errorf("Expected cells %v but got %v", expected, p.cells)
+		}
+	}
+}
+
+func Test_parseRLEInvalid(t *testing.T) {
+	for _, rle := range []string{
+		"",
+		"bob$2bo$3o!",
+		"x = 3\nbob!",
+		"x = 3, y = a\nbob!",
+		"x = 2, y = 2\n3o!",
+		"x = 2, y = 2\no?o!",
+		"x = 2, y = 2\n999999999o!",
+		"x = 2, y = 2\no$o$o!",
+		"x = 2, y = 2\n3bo!",
+		"x = 2, y = 2\n99999999999999999999999o!",
+		"x = 100000, y = 100000\no!",
+	} {
+		if _, err := parseRLE("p", strings.NewReader(rle)); err == nil {
+			t.Errorf("Expected an error parsing %q", rle)
+		}
+	}
+}
+
+func Test_parseRLEMultiState(t *testing.T) {
+	rle := "#C species A #aaaaaa\n#C species pA #bbbbbb\nx = 4, y = 1\nA2bpA!"
+
+	p, err := parseRLE("p", strings.NewReader(rle))
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []patternCell{{point{0, 0}, "#aaaaaa"}, {point{0, 3}, "#bbbbbb"}}
+	if len(p.cells) != len(expected) {
+		t.Fatalf("Expected cells %v but got %v", expected, p.cells)
+	}
+	for i, c := range expected {
+		if p.cells[i] != c {
+			t.Errorf("Expected cells %v but got %v", expected, p.cells)
+		}
+	}
+}
+
+func Test_parsePlaintext(t *testing.T) {
+	cells := "!Name: Glider\n.O\n..O\nOOO\n"
+
+	p, err := parsePlaintext("glider", strings.NewReader(cells))
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if p.width != 3 || p.height != 3 {
+		t.Errorf("Expected 3x3 pattern but got %vx%v", p.width, p.height)
+	}
+	expected := []point{{0, 1}, {1, 2}, {2, 0}, {2, 1}, {2, 2}}
+	if len(p.cells) != len(expected) {
+		t.Fatalf("Expected cells %v but got %v", expected, p.cells)
+	}
+	for i, c := range expected {
+		if p.cells[i].point != c {
+			t.Errorf("Expected cells %v but got %v", expected, p.cells)
+		}
+	}
+}
+
+func Test_parseLife106(t *testing.T) {
+	// A glider centered on the origin.
+	lif := "#Life 1.06\n0 -1\n1 0\n-1 1\n0 1\n1 1\n"
+
+	p, err := parseLife106("glider", strings.NewReader(lif))
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if p.width != 3 || p.height != 3 {
+		t.Errorf("Expected 3x3 pattern but got %vx%v", p.width, p.height)
+	}
+	expected := []point{{0, 1}, {1, 2}, {2, 0}, {2, 1}, {2, 2}}
+	if len(p.cells) != len(expected) {
+		t.Fatalf("Expected cells %v but got %v", expected, p.cells)
+	}
+	for i, c := range expected {
+		if p.cells[i].point != c {
+			t.Errorf("Expected cells %v but got %v", expected, p.cells)
+		}
+	}
+}
+
+func Test_parseLife106Invalid(t *testing.T) {
+	for _, lif := range []string{
+		"#Life 1.06\n0\n",
+		"#Life 1.06\n0 a\n",
+		"#Life 1.06\n0 1025\n",
+		"#Life 1.06\n-9223372036854775808 0\n0 9223372036854775807\n",
+		"#Life 1.06\n-512 0\n512 0\n",
+	} {
+		if _, err := parseLife106("p", strings.NewReader(lif)); err == nil {
+			t.Errorf("Expected an error parsing %q", lif)
+		}
+	}
+}
+
+func Test_detectFormat(t *testing.T) {
+	tests := map[string]string{
+		"#Life 1.06\n0 0\n":              formatLife106,
+		"!Name: Block\nOO\nOO\n":         formatPlaintext,
+		".O\n..O\nOOO\n":                 formatPlaintext,
+		"#N Block\nx = 2, y = 2\n2o$2o!": formatRLE,
+		"x = 2, y = 2\n2o$2o!":           formatRLE,
+	}
+	for data, expected := range tests {
+		if got := detectFormat([]byte(data)); got != expected {
+			t.Errorf("Expected format of %q to be %v but got %v", data, expected, got)
+		}
+	}
+}
+
+// Writing the grid in each format and then reading it back should reproduce
+// the grid. Only multi-state RLE preserves species; the other formats
+// reproduce the live cells.
+func Test_lifeFormatRoundTrip(t *testing.T) {
+	g := &grid{}
+	g[0][0] = "#aaaaaa"
+	g[0][1] = "#aaaaaa"
+	g[5][gridDimY-1] = "#bbbbbb"
+	g[60][70] = "#cccccc"
+	for y := 0; y < gridDimY; y++ {
+		g[gridDimX-1][y] = "#dddddd"
+	}
+
+	tests := []struct {
+		format      string
+		withSpecies bool
+	}{
+		{formatRLE, true},
+		{formatRLE, false},
+		{formatPlaintext, false},
+		{formatLife106, false},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := writeGrid(&buf, g, tt.format, tt.withSpecies); err != nil {
+			t.Fatalf("%v: unexpected error writing: %v", tt.format, err)
+		}
+		if got := detectFormat(buf.Bytes()); got != tt.format {
+			t.Errorf("%v: detected format %v", tt.format, got)
+		}
+		p, err := parsePattern("board", tt.format, &buf)
+		if err != nil {
+			t.Fatalf("%v: unexpected error reading: %v", tt.format, err)
+		}
+		df, err := placePattern(p, 0, 0, "#eeeeee", torus)
+		if err != nil {
+			t.Fatalf("%v: unexpected error placing: %v", tt.format, err)
+		}
+		got := &grid{}
+		flush(df, got)
+		for x := 0; x < gridDimX; x++ {
+			for y := 0; y < gridDimY; y++ {
+				expected := g[x][y]
+				if expected != "" && !tt.withSpecies {
+					expected = "#eeeeee"
+				}
+				if got[x][y] != expected {
+					t.Errorf("%v: expected (%v, %v) to be %q but got %q",
+						tt.format, x, y, expected, got[x][y])
+				}
+			}
+		}
+	}
+}
+
+func Test_writeRLE(t *testing.T) {
+	g := &grid{}
+	g[0][1] = "#aaaaaa"
+	g[2][0] = "#bbbbbb"
+	g[2][1] = "#bbbbbb"
+
+	var buf bytes.Buffer
+	if err := writeRLE(&buf, g, true); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := "#N multi-life\n#C species A #aaaaaa\n#C species B #bbbbbb\n" +
+		"x = 120, y = 120\nbA2$2B!\n"
+	if buf.String() != expected {
+		t.Errorf("Expected %q but got %q", expected, buf.String())
+	}
+}
@@ -7,6 +7,7 @@ func (err *bufferOverflowError) Error() string {
 }
 
 type listener struct {
-	sendChan chan<- []byte
+	sendChan chan *lazyMessage
 	errSig   *errorSignal
+	caps     capabilities
 }
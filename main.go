@@ -3,31 +3,227 @@
 package main
 
 import (
+	"encoding/json"
+	"flag"
 	"log"
 	"net/http"
+	"os"
 
 	"github.com/gorilla/websocket"
 )
 
+// maxMessageSize is the maximum size in bytes of a message from a client. The
+// connection is closed if a client sends a larger one.
+const maxMessageSize = 1 << 20
+
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
+	Subprotocols:    subprotocols,
 }
 
 func main() {
-	pl := startPipeline()
+	if len(os.Args) > 1 && os.Args[1] == "gif" {
+		if err := runGIFCommand(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	patternDir := flag.String("patterns", "",
+		"directory of additional RLE patterns that clients may stamp")
+	adminToken := flag.String("admin-token", os.Getenv("ADMIN_TOKEN"),
+		"bearer token required by privileged HTTP endpoints (default $ADMIN_TOKEN)")
+	apiToken := flag.String("api-token", os.Getenv("API_TOKEN"),
+		"bearer token required by the HTTP API, which is open if it is empty (default $API_TOKEN)")
+	historyLen := flag.Int("history", defaultHistoryLen,
+		"number of generations retained for time-lapse export")
+	tickInterval := flag.Duration("tick", defaultTickInterval,
+		"initial interval between generations")
+	cleanupAfter := flag.Int("cleanup-after", 0,
+		"remove live cells that have been unchanged for this many generations (0 disables)")
+	cleanupComponents := flag.Bool("cleanup-components", false,
+		"remove debris only as whole connected components")
+	cleanupFade := flag.Int("cleanup-fade", 0,
+		"number of generations over which debris fades out before it is removed")
+	geneticsEnabled := flag.Bool("genetics", false,
+		"blend the colors of a newborn cell's parents instead of taking the most populous")
+	mutationRate := flag.Float64("mutation-rate", 0.01,
+		"probability that a newborn cell's hue mutates, when -genetics is set")
+	mutationRange := flag.Float64("mutation-range", 30,
+		"largest hue shift in degrees that a mutation can cause")
+	seed := flag.Int64("seed", 0,
+		"seed for the random number generator that evolves the board (default based on time)")
+	ruleNotation := flag.String("rule", conway,
+		"rule in B/S notation, e.g. B36/S23 for HighLife")
+	neighborhoodName := flag.String("neighborhood", string(moore),
+		"cells that the rule counts: moore, vonneumann, or hex")
+	radius := flag.Int("radius", 1,
+		"radius of the neighborhood")
+	topologyName := flag.String("topology", string(torus),
+		"how the edges of the board are joined: torus, bounded, klein, cross-surface, or plane (unbounded)")
+	antRule := flag.String("ant-rule", defaultAntRule,
+		"turns that ants make on dead and live cells, from L, R, N (none), and U (U-turn)")
+	maxAnts := flag.Int("max-ants", defaultMaxAnts,
+		"maximum number of ants on the board (0 disables ants)")
+	leaderboardInterval := flag.Int("leaderboard-interval", defaultLeaderboardInterval,
+		"number of generations between leaderboard broadcasts (0 disables them)")
+	overflowName := flag.String("overflow", string(disconnect),
+		"what to do when a client falls behind: disconnect, coalesce (merge diffs), or grid (send a fresh grid)")
+	sendBuffer := flag.Int("send-buffer", defaultSendBufferLen,
+		"number of messages that can wait to be sent to a client before it is considered behind")
+	maxClients := flag.Int("max-clients", 0,
+		"maximum number of players connected at once, not counting spectators (0 means no limit)")
+	maxSpectators := flag.Int("max-spectators", 0,
+		"maximum number of spectators connected at once (0 means no limit)")
+	var bots botFlag
+	flag.Var(&bots, "bot",
+		"run a bot, e.g. soup, glider, or blocks:species=#ff0000,every=10s,humans=2 (may be repeated)")
+	flag.Parse()
+
+	if *tickInterval < minTickInterval || *tickInterval > maxTickInterval {
+		log.Fatalf("tick interval must be between %v and %v", minTickInterval, maxTickInterval)
+	}
+
+	if err := validateAntRule(*antRule); err != nil {
+		log.Fatal(err)
+	}
+	overflow, err := parseOverflowPolicy(*overflowName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *sendBuffer < 1 {
+		log.Fatal("send buffer length must be at least 1")
+	}
+	topo, err := parseTopology(*topologyName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	nh, err := parseNeighborhood(*neighborhoodName, *radius)
+	if err != nil {
+		log.Fatal(err)
+	}
+	rule, err := parseRule(*ruleNotation, len(nh.offsets))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	patterns, err := loadPatterns(*patternDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cfg := defaultConfig()
+	cfg.patterns = patterns
+	cfg.adminToken = *adminToken
+	cfg.apiToken = *apiToken
+	cfg.historyLen = *historyLen
+	cfg.tickInterval = *tickInterval
+	cfg.cleanup = cleanupPolicy{*cleanupAfter, *cleanupComponents, *cleanupFade}
+	cfg.genetics = genetics{*geneticsEnabled, *mutationRate, *mutationRange}
+	cfg.seed = *seed
+	cfg.rule = rule
+	cfg.neighborhood = nh
+	cfg.topology = topo
+	cfg.antRule = *antRule
+	cfg.maxAnts = *maxAnts
+	if topo == plane && !isFlagSet("max-ants") {
+		// Ants are on by default, but can't be used on the plane.
+		cfg.maxAnts = 0
+	}
+	cfg.leaderboardInterval = *leaderboardInterval
+	cfg.sendBufferLen = *sendBuffer
+	cfg.overflowPolicy = overflow
+	cfg.maxPlayers = *maxClients
+	cfg.maxSpectators = *maxSpectators
+	cfg.bots = bots
+	if err := cfg.validate(); err != nil {
+		log.Fatal(err)
+	}
+
+	pl := startPipeline(cfg)
+	handleConn := handleWebSocket(pl)
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		if !websocket.IsWebSocketUpgrade(r) {
 			serveFileNoCache(w, r, "./assets/main.html")
 			return
 		}
-		conn, err := upgrader.Upgrade(w, r, nil)
+		handleConn(w, r)
+	})
+	http.HandleFunc("/patterns", func(w http.ResponseWriter, r *http.Request) {
+		serveJSON(w, patternInfos(cfg.patterns))
+	})
+	http.HandleFunc("/world", func(w http.ResponseWriter, r *http.Request) {
+		serveJSON(w, newWorldInfo(cfg))
+	})
+	http.HandleFunc("/leaderboard", func(w http.ResponseWriter, r *http.Request) {
+		serveJSON(w, pl.currentLeaderboard())
+	})
+	http.HandleFunc("/players", func(w http.ResponseWriter, r *http.Request) {
+		if pl.cfg.topology == plane {
+			http.Error(w, "player statistics are not supported on the plane", http.StatusBadRequest)
+			return
+		}
+		serveJSON(w, pl.currentPlayerStats())
+	})
+	http.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		serveJSON(w, pl.conns.snapshot())
+	})
+	http.HandleFunc("/events", handleEvents(pl))
+	apiLimiter := newKeyedLimiter(apiBurst, apiRefill)
+	http.HandleFunc("/api/grid", handleAPI(cfg.apiToken, apiLimiter, handleAPIGrid(pl)))
+	http.HandleFunc("/api/cell", handleAPI(cfg.apiToken, apiLimiter, handleAPICell(pl)))
+	http.HandleFunc("/api/diff", handleAPI(cfg.apiToken, apiLimiter, handleAPIDiff(pl)))
+	http.HandleFunc("/export", handleExport(pl))
+	http.HandleFunc("/import", requireToken(cfg.adminToken, handleImport(pl)))
+	http.HandleFunc("/snapshot.png", handleSnapshot(pl))
+	http.HandleFunc("/timelapse.gif", handleTimeLapse(pl))
+	http.HandleFunc("/admin/", requireToken(cfg.adminToken, handleAdmin(pl)))
+	http.HandleFunc("/main.js", func(w http.ResponseWriter, r *http.Request) {
+		serveFileNoCache(w, r, "./assets/main.js")
+	})
+	http.HandleFunc("/main.css", func(w http.ResponseWriter, r *http.Request) {
+		serveFileNoCache(w, r, "./assets/main.css")
+	})
+	http.HandleFunc("/beehive_oscillator.png", func(w http.ResponseWriter, r *http.Request) {
+		serveFileNoCache(w, r, "./assets/beehive_oscillator.png")
+	})
+	log.Fatal(http.ListenAndServe(":80", nil))
+}
+
+// handleWebSocket upgrades a request to a WebSocket connection and attaches
+// it to a pipeline. See protocol.md.
+func handleWebSocket(pl *pipeline) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if pl.cfg.topology == plane && !offersVersion2(r) {
+			http.Error(w, "the plane requires protocol version 2", http.StatusBadRequest)
+			return
+		}
+		caps := parseCapabilities(r.URL.Query().Get("caps"))
+		if pl.cfg.topology == plane && caps.ages {
+			http.Error(w, "cell ages are not supported on the plane", http.StatusBadRequest)
+			return
+		}
+		spectator := isSpectatorRequest(r)
+		if !pl.conns.acquire(spectator) {
+			http.Error(w, "too many clients are connected", http.StatusServiceUnavailable)
+			return
+		}
+		token, isNew := requestSession(r)
+		var header http.Header
+		if isNew {
+			header = sessionCookieHeader(token)
+		}
+		conn, err := upgrader.Upgrade(w, r, header)
 		if err != nil {
+			pl.conns.release(spectator)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			log.Println(err)
 			return
 		}
-		attachConn(
+		conn.SetReadLimit(maxMessageSize)
+		caps.version = parseSubprotocol(conn.Subprotocol())
+		caps.spectator = spectator
+		wg, _ := attachConn(
 			pl,
 			func() (messageType int, p []byte, err error) {
 				return conn.ReadMessage()
@@ -38,18 +234,14 @@ func main() {
 			func() error {
 				return conn.Close()
 			},
+			caps,
+			sessionPlayerID(token),
 		)
-	})
-	http.HandleFunc("/main.js", func(w http.ResponseWriter, r *http.Request) {
-		serveFileNoCache(w, r, "./assets/main.js")
-	})
-	http.HandleFunc("/main.css", func(w http.ResponseWriter, r *http.Request) {
-		serveFileNoCache(w, r, "./assets/main.css")
-	})
-	http.HandleFunc("/beehive_oscillator.png", func(w http.ResponseWriter, r *http.Request) {
-		serveFileNoCache(w, r, "./assets/beehive_oscillator.png")
-	})
-	log.Fatal(http.ListenAndServe(":80", nil))
+		go func() {
+			wg.Wait()
+			pl.conns.release(spectator)
+		}()
+	}
 }
 
 // serveFileNoCache serves a file and directs the client to always request the
@@ -62,3 +254,22 @@ func serveFileNoCache(w http.ResponseWriter, r *http.Request, name string) {
 	w.Header()["Cache-Control"] = []string{"no-store"}
 	http.ServeFile(w, r, name)
 }
+
+// serveJSON serves a value encoded as JSON.
+func serveJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println(err)
+	}
+}
+
+// isFlagSet reports whether a command-line flag was given.
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
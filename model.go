@@ -2,6 +2,9 @@ package main
 
 import (
 	"math/rand"
+	"strconv"
+	"strings"
+	"time"
 )
 
 const (
@@ -9,20 +12,42 @@ const (
 	gridDimY = 120
 )
 
+// species is the value of a cell. It is "" for a dead cell, and a hexadecimal
+// color code for a live cell. Under a Generations rule, a cell that is dying
+// is its former color code followed by ":" and its state, e.g. "#aaaaaa:2".
+// See lifeRule.
 type species = string
 type grid = [gridDimX][gridDimY]species
 type diff = map[int]map[int]species
 
+// ageGrid holds the age of each cell in a grid: the number of generations
+// since the cell was born or last changed species. Dead cells have age 0.
+type ageGrid = [gridDimX][gridDimY]int
+
 // flush copies a diff into a grid and empties the diff.
 func flush(df diff, g *grid) {
+	apply(df, g)
+	for x := range df {
+		delete(df, x)
+	}
+}
+
+// apply copies a diff into a grid, leaving the diff unchanged.
+func apply(df diff, g *grid) {
 	for x, ydiff := range df {
 		for y, v := range ydiff {
 			g[x][y] = v
 		}
-		delete(df, x)
 	}
 }
 
+// copyDiff returns a deep copy of a diff.
+func copyDiff(df diff) diff {
+	c := make(diff, len(df))
+	merge(df, c)
+	return c
+}
+
 // merge copies a new diff into an existing diff.
 func merge(newDiff diff, df diff) {
 	for x, newYDiff := range newDiff {
@@ -33,98 +58,199 @@ func merge(newDiff diff, df diff) {
 	}
 }
 
-// neighbors returns the number of live cells and most populous species in the
-// neighborhood of cell (x,y). If multiple species are tied for most populous,
-// neighbors chooses one at random. The neighborhood of a cell is defined such
-// that the left and right edges of the grid are stitched together, and the top
-// and bottom edges are stitched together.
-func neighbors(g *grid, x int, y int) (int, species) {
-	var left int
-	if x == 0 {
-		left = gridDimX - 1
-	} else {
-		left = x - 1
-	}
-	var right int
-	if x == gridDimX-1 {
-		right = 0
-	} else {
-		right = x + 1
-	}
-	var up int
-	if y == 0 {
-		up = gridDimY - 1
-	} else {
-		up = y - 1
-	}
-	var down int
-	if y == gridDimY-1 {
-		down = 0
-	} else {
-		down = y + 1
+// isLive reports whether a cell is live, as opposed to dead or dying.
+func isLive(s species) bool {
+	return s != "" && strings.IndexByte(s, ':') < 0
+}
+
+// cellState splits a cell into its color code and its state: 0 for a dead
+// cell, 1 for a live cell, and 2 or more for a dying cell.
+func cellState(s species) (species, int) {
+	if s == "" {
+		return "", 0
 	}
-	sCount := make(map[species]int)
-	var s species
-	if s = g[left][up]; s != "" {
-		sCount[s]++
+	i := strings.IndexByte(s, ':')
+	if i < 0 {
+		return s, 1
 	}
-	if s = g[x][up]; s != "" {
-		sCount[s]++
+	state, _ := strconv.Atoi(s[i+1:])
+	return s[:i], state
+}
+
+// dyingCell returns a dying cell of the given color code and state.
+func dyingCell(s species, state int) species {
+	return s + ":" + strconv.Itoa(state)
+}
+
+// advanceAges updates the ages of the cells in g for the generation produced
+// by applying df to g. It must be called before df is flushed into g.
+func advanceAges(a *ageGrid, g *grid, df diff) {
+	for x := 0; x < gridDimX; x++ {
+		ydiff := df[x]
+		for y := 0; y < gridDimY; y++ {
+			if _, ok := ydiff[y]; ok {
+				a[x][y] = 0
+			} else if g[x][y] != "" {
+				a[x][y]++
+			}
+		}
 	}
-	if s = g[right][up]; s != "" {
-		sCount[s]++
+}
+
+// clearAll modifies a diff so that applying it to a grid kills every cell.
+// Changes to cells that are dead in the grid are removed from the diff, so
+// that the diff only contains changes that have an effect.
+func clearAll(g *grid, df diff) {
+	for x := 0; x < gridDimX; x++ {
+		for y := 0; y < gridDimY; y++ {
+			if g[x][y] != "" {
+				getOrMakeYDiff(df, x)[y] = ""
+			} else if ydiff, ok := df[x]; ok {
+				delete(ydiff, y)
+			}
+		}
+		if len(df[x]) == 0 {
+			delete(df, x)
+		}
 	}
-	if s = g[left][y]; s != "" {
-		sCount[s]++
+}
+
+// ruleset holds the settings that determine how nextState evolves a grid.
+type ruleset struct {
+	rule         lifeRule
+	neighborhood neighborhood
+	topology     topology
+	genetics     genetics
+	// rng is the source of randomness used to break ties between species and
+	// to mutate genes. Seeding it makes evolution deterministic.
+	rng *rand.Rand
+	// heritable, if it isn't nil, maps the species of the live cell at p to
+	// the species that it passes on to its neighbors. See
+	// cleaner.heritable.
+	heritable func(p point, s species) species
+}
+
+// heritableSpecies returns the species that the live cell at p, which has
+// species s, passes on to its neighbors.
+func (rs *ruleset) heritableSpecies(p point, s species) species {
+	if rs.heritable == nil {
+		return s
 	}
-	if s = g[right][y]; s != "" {
-		sCount[s]++
+	return rs.heritable(p, s)
+}
+
+// newRuleset returns the ruleset described by a config.
+func newRuleset(cfg *config) *ruleset {
+	seed := cfg.seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
 	}
-	if s = g[left][down]; s != "" {
-		sCount[s]++
+	return &ruleset{
+		rule:         cfg.rule,
+		neighborhood: cfg.neighborhood,
+		topology:     cfg.topology,
+		genetics:     cfg.genetics,
+		rng:          rand.New(rand.NewSource(seed)),
 	}
-	if s = g[x][down]; s != "" {
-		sCount[s]++
+}
+
+// neighbors returns the number of live cells and most populous species in the
+// neighborhood of cell (x,y), along with the species of each live cell in the
+// neighborhood. If multiple species are tied for most populous, neighbors
+// chooses one at random. The neighborhood is defined by the ruleset, and its
+// topology determines the neighborhood of a cell at the edge of the grid.
+func neighbors(g *grid, x int, y int, rs *ruleset) (int, species, []species) {
+	var live []species
+	for _, o := range rs.neighborhood.offsets {
+		p, ok := rs.topology.wrap(x+o.x, y+o.y)
+		if !ok {
+			continue
+		}
+		if s := g[p.x][p.y]; isLive(s) {
+			live = append(live, rs.heritableSpecies(p, s))
+		}
 	}
-	if s = g[right][down]; s != "" {
+	return len(live), mostPopulous(live, rs.rng), live
+}
+
+// mostPopulous returns the species that occurs most often in live, choosing
+// at random between species that are tied.
+func mostPopulous(live []species, rng *rand.Rand) species {
+	sCount := make(map[species]int)
+	for _, s := range live {
 		sCount[s]++
 	}
-	var n int
+	// Consider each species in the order in which it was first seen, rather
+	// than in map order, so that a seeded rng gives deterministic results.
 	var sMax species
-	var sMaxCount int
-	for k, v := range sCount {
-		n += v
-		if v > sMaxCount || (v == sMaxCount && rand.Intn(2) == 0) {
-			sMax = k
-			sMaxCount = v
+	var sMaxCount, ties int
+	for _, s := range live {
+		v, ok := sCount[s]
+		if !ok {
+			// We've already considered this species.
+			continue
+		}
+		delete(sCount, s)
+		if v > sMaxCount {
+			sMax, sMaxCount, ties = s, v, 1
+		} else if v == sMaxCount {
+			// Replace the current choice with probability 1/ties, so that
+			// each of the tied species is equally likely to be chosen.
+			ties++
+			if rng.Intn(ties) == 0 {
+				sMax = s
+			}
 		}
 	}
-	return n, sMax
+	return sMax
 }
 
 // nextState computes the changes between a grid's current state and next
 // state, and writes the changes into a diff.
-// nextState implements the original rules of Conway's Game of Life, and
-// additionally sets a live cell's species to the most populous neighboring
-// species as determined by the neighbors function.
-func nextState(g *grid, df diff) {
+// nextState implements the ruleset's rule, which by default is the original
+// rule of Conway's Game of Life, and additionally sets a live cell's species
+// to the most populous neighboring species as determined by the neighbors
+// function. If genetics is enabled, a newborn cell's species is instead
+// inherited from its parents, and live cells keep their species. Under a
+// Generations rule, a live cell that doesn't survive, and a dying cell, move
+// on to the next state. Dying cells are neither live neighbors nor able to
+// give birth. Live cells take part with their heritable species (see
+// ruleset.heritable).
+func nextState(g *grid, df diff, rs *ruleset) {
 	for x := 0; x < gridDimX; x++ {
 		for y := 0; y < gridDimY; y++ {
-			n, sMax := neighbors(g, x, y)
+			n, sMax, parents := neighbors(g, x, y, rs)
 			current := g[x][y]
-			if current != "" {
-				if n != 2 && n != 3 {
-					getOrMakeYDiff(df, x)[y] = ""
-				} else if current != sMax {
-					getOrMakeYDiff(df, x)[y] = sMax
-				}
-			} else if n == 3 {
-				getOrMakeYDiff(df, x)[y] = sMax
+			if isLive(current) {
+				current = rs.heritableSpecies(point{x, y}, current)
+			}
+			if next, ok := transition(current, n, sMax, parents, rs); ok {
+				getOrMakeYDiff(df, x)[y] = next
 			}
 		}
 	}
 }
 
+// transition returns the next state of a cell, given its current state and
+// the results of neighbors. ok is false if the cell doesn't change.
+func transition(current species, n int, sMax species, parents []species, rs *ruleset) (next species, ok bool) {
+	if isLive(current) {
+		if !rs.rule.survives[n] {
+			return rs.rule.decay(current), true
+		} else if !rs.genetics.enabled && current != sMax {
+			return sMax, true
+		}
+	} else if current != "" {
+		return rs.rule.decay(current), true
+	} else if rs.rule.born[n] {
+		if rs.genetics.enabled {
+			return rs.genetics.offspring(parents, rs.rng), true
+		}
+		return sMax, true
+	}
+	return "", false
+}
+
 func getOrMakeYDiff(df diff, x int) map[int]species {
 	ydiff, ok := df[x]
 	if !ok {
@@ -69,7 +69,7 @@ func Test_neighbors(t *testing.T) {
 	g[11][12] = "a"
 	g[12][11] = "b"
 
-	n, sMax := neighbors(g, 11, 11)
+	n, sMax, _ := neighbors(g, 11, 11, testRuleset())
 
 	if n != 4 {
 		t.Errorf("Expected number of neighbors be 4 but got %v", n)
@@ -85,7 +85,7 @@ func Test_neighbors2(t *testing.T) {
 	g[gridDimX-1][gridDimY-1] = "b"
 	g[gridDimX-1][0] = "b"
 
-	n, sMax := neighbors(g, 0, 0)
+	n, sMax, _ := neighbors(g, 0, 0, testRuleset())
 
 	if n != 3 {
 		t.Errorf("Expected number of neighbors to be 1 but got %v", n)
@@ -103,7 +103,7 @@ func Test_nextState(t *testing.T) {
 	g[11][12] = "b"
 	g[12][11] = "c"
 
-	nextState(g, df)
+	nextState(g, df, testRuleset())
 
 	if n := len(df[10]); n < 2 || n > 3 {
 		t.Errorf("Incorrect game state")
@@ -145,3 +145,91 @@ func Test_nextState(t *testing.T) {
 		t.Errorf("Incorrect game state")
 	}
 }
+
+func Test_advanceAges(t *testing.T) {
+	g, a := &grid{}, &ageGrid{}
+	g[1][1] = "a"
+	g[2][2] = "a"
+	g[3][3] = "a"
+	a[1][1] = 5
+	a[2][2] = 5
+	a[3][3] = 5
+	df := diff{
+		2: {2: "b"},
+		3: {3: ""},
+		4: {4: "a"},
+	}
+
+	advanceAges(a, g, df)
+
+	if a[1][1] != 6 {
+		t.Errorf("Expected unchanged cell to have age 6 but got %v", a[1][1])
+	}
+	if a[2][2] != 0 {
+		t.Errorf("Expected cell that changed species to have age 0 but got %v", a[2][2])
+	}
+	if a[3][3] != 0 {
+		t.Errorf("Expected dead cell to have age 0 but got %v", a[3][3])
+	}
+	if a[4][4] != 0 {
+		t.Errorf("Expected newborn cell to have age 0 but got %v", a[4][4])
+	}
+	if a[5][5] != 0 {
+		t.Errorf("Expected empty cell to have age 0 but got %v", a[5][5])
+	}
+}
+
+// testRuleset returns the default ruleset with a fixed seed.
+func testRuleset() *ruleset {
+	cfg := defaultConfig()
+	cfg.seed = 1
+	return newRuleset(cfg)
+}
+
+func Test_nextStateGenetics(t *testing.T) {
+	g, df := &grid{}, make(diff)
+	g[10][10] = "#ff0000"
+	g[10][11] = "#0000ff"
+	g[10][12] = "#0000ff"
+	rs := testRuleset()
+	rs.genetics = genetics{enabled: true}
+
+	nextState(g, df, rs)
+
+	// The blinker flips, and each newborn cell is a blend of its parents.
+	if v := df[9][11]; v != "#5500aa" {
+		t.Errorf("Expected (9, 11) to be \"#5500aa\" but got %q", v)
+	}
+	if v := df[11][11]; v != "#5500aa" {
+		t.Errorf("Expected (11, 11) to be \"#5500aa\" but got %q", v)
+	}
+	// Survivors keep their species even though "#0000ff" is more populous.
+	if _, ok := df[10][11]; ok {
+		t.Errorf("Expected (10, 11) to be unchanged but got %q", df[10][11])
+	}
+}
+
+func Test_nextStateMutationIsDeterministic(t *testing.T) {
+	run := func() diff {
+		g, df := &grid{}, make(diff)
+		g[10][10] = "#ff0000"
+		g[10][11] = "#ff0000"
+		g[10][12] = "#0000ff"
+		cfg := defaultConfig()
+		cfg.genetics = genetics{enabled: true, mutationRate: 1, mutationRange: 90}
+		cfg.seed = 42
+		rs := newRuleset(cfg)
+		nextState(g, df, rs)
+		return df
+	}
+	first, second := run(), run()
+	for _, p := range []point{{9, 11}, {11, 11}} {
+		if first[p.x][p.y] != second[p.x][p.y] {
+			t.Errorf("Expected (%v, %v) to be the same for the same seed but got %q and %q",
+				p.x, p.y, first[p.x][p.y], second[p.x][p.y])
+		}
+		if first[p.x][p.y] == "#aa0055" {
+			t.Errorf("Expected (%v, %v) to mutate", p.x, p.y)
+		}
+	}
+}
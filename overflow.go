@@ -0,0 +1,178 @@
+package main
+
+import "fmt"
+
+// overflowPolicy determines what hub does when a Listener's send buffer is
+// full, which happens when a client can't keep up with the messages sent to
+// it.
+type overflowPolicy string
+
+const (
+	// disconnect drops the Listener, closing the connection. Clients are
+	// expected to reconnect.
+	disconnect overflowPolicy = "disconnect"
+	// coalesce holds back the Listener's messages until its buffer has room,
+	// merging consecutive diffs into one. See backlog. Listeners with the ages
+	// capability are treated as under freshGrid instead.
+	coalesce overflowPolicy = "coalesce"
+	// freshGrid discards the messages in the Listener's buffer, along with any
+	// sent before gol can answer a resync, and sends the Listener a fresh grid
+	// instead.
+	freshGrid overflowPolicy = "grid"
+)
+
+// overflowPolicies lists the valid overflow policies.
+var overflowPolicies = []overflowPolicy{disconnect, coalesce, freshGrid}
+
+// parseOverflowPolicy checks that a name refers to a known overflow policy.
+func parseOverflowPolicy(name string) (overflowPolicy, error) {
+	for _, p := range overflowPolicies {
+		if string(p) == name {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("unknown overflow policy (%v)", name)
+}
+
+// defaultSendBufferLen is the default value of config.sendBufferLen.
+const defaultSendBufferLen = 256
+
+// backlogItem is a message held back by a backlog: either a message to send as
+// is, or a diff that later diffs can be merged into.
+type backlogItem struct {
+	// message is the message to send as is. If it is nil, the item is a
+	// diff.
+	message *lazyMessage
+	// typed is true if message is a typed message that isn't part of the
+	// stream of diffs, such as a chat message or presence snapshot.
+	typed bool
+	// df and ants are a diff of the grid and the ants that followed it.
+	df   diff
+	ants []ant
+	// planeDf is a diff of the plane. If it is not nil, df is unused.
+	planeDf planeDiff
+	// generations is the number of generations merged into the diff.
+	generations int
+}
+
+// backlog holds the messages for a Listener whose send buffer filled up under
+// the coalesce policy, in the order that they were sent. A diff is merged into
+// the last diff held back, as long as every message since then is a typed
+// message that isn't part of the stream, so however far behind the client
+// falls, it is sent one diff for each run of generations between other
+// messages in the stream. The typed messages are sent before the diffs that
+// were merged past them, which clients can't tell apart from typed messages
+// that arrive late.
+type backlog struct {
+	items []*backlogItem
+	// max is the largest number of items that the backlog may hold.
+	max int
+}
+
+// add appends a message to the backlog, which is a typed message that isn't
+// part of the stream if typed is true. It returns false if the backlog is
+// full.
+func (b *backlog) add(message *lazyMessage, typed bool) bool {
+	if len(b.items) == b.max {
+		return false
+	}
+	b.items = append(b.items, &backlogItem{message: message, typed: typed})
+	return true
+}
+
+// lastDiff returns the last item in the stream, skipping typed messages, if it
+// is a diff of the kind given by isPlane, or else nil.
+func (b *backlog) lastDiff(isPlane bool) *backlogItem {
+	for i := len(b.items) - 1; i >= 0; i-- {
+		item := b.items[i]
+		if item.typed {
+			continue
+		}
+		if item.message != nil || (item.planeDf != nil) != isPlane {
+			return nil
+		}
+		return item
+	}
+	return nil
+}
+
+// addDiff merges a diff of the grid into the backlog. It returns false if the
+// backlog is full. df is copied rather than modified.
+func (b *backlog) addDiff(df diff, ants []ant) bool {
+	if last := b.lastDiff(false); last != nil {
+		merge(df, last.df)
+		last.ants = ants
+		last.generations++
+		return true
+	}
+	if len(b.items) == b.max {
+		return false
+	}
+	b.items = append(b.items, &backlogItem{df: copyDiff(df), ants: ants, generations: 1})
+	return true
+}
+
+// addPlaneDiff is the counterpart of addDiff for the plane.
+func (b *backlog) addPlaneDiff(df planeDiff) bool {
+	if last := b.lastDiff(true); last != nil {
+		mergePlane(df, last.planeDf)
+		last.generations++
+		return true
+	}
+	if len(b.items) == b.max {
+		return false
+	}
+	b.items = append(b.items, &backlogItem{planeDf: copyPlaneDiff(df), generations: 1})
+	return true
+}
+
+// flush sends as many items as will fit in a Listener's send buffer, oldest
+// first. It returns true if the backlog is now empty. Diffs are encoded by the
+// Listener's writePump, since they are no longer modified once sent.
+func (b *backlog) flush(li *listener) bool {
+	for len(b.items) > 0 {
+		item := b.items[0]
+		message := item.message
+		if message == nil && item.planeDf != nil {
+			message = newLazyMessage(func() []byte {
+				return marshalCoalescedPlaneDiff(item.planeDf, item.generations)
+			})
+		} else if message == nil {
+			caps := li.caps
+			message = newLazyMessage(func() []byte {
+				return marshalCoalescedDiff(item.df, item.ants, caps, item.generations)
+			})
+		}
+		select {
+		case li.sendChan <- message:
+			b.items = b.items[1:]
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// drain discards the messages in a Listener's send buffer.
+func drain(li *listener) {
+	for {
+		select {
+		case <-li.sendChan:
+		default:
+			return
+		}
+	}
+}
+
+// resync asks gol for a fresh grid for a Listener whose buffer overflowed
+// under the freshGrid policy.
+type resync struct {
+	li *listener
+}
+
+// resyncGrid carries the fresh grid from gol to hub, which resumes sending
+// messages to the Listener.
+type resyncGrid struct {
+	li      *listener
+	message []byte
+}
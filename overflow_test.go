@@ -0,0 +1,52 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_parseOverflowPolicy(t *testing.T) {
+	for _, p := range overflowPolicies {
+		if got, err := parseOverflowPolicy(string(p)); err != nil || got != p {
+			t.Errorf("parseOverflowPolicy(%v) = %v, %v", p, got, err)
+		}
+	}
+	if _, err := parseOverflowPolicy("nonexistent"); err == nil {
+		t.Errorf("Expected an error for an unknown policy")
+	}
+}
+
+func Test_backlog(t *testing.T) {
+	b := &backlog{max: 2}
+	first := diff{1: {1: "#aaaaaa"}}
+	if !b.addDiff(first, nil) || !b.addDiff(diff{1: {1: ""}, 2: {2: "#bbbbbb"}}, nil) {
+		t.Fatalf("Expected consecutive diffs to be merged")
+	}
+	if len(b.items) != 1 || b.items[0].generations != 2 {
+		t.Fatalf("Expected one item spanning two generations")
+	}
+	if want := (diff{1: {1: ""}, 2: {2: "#bbbbbb"}}); !reflect.DeepEqual(b.items[0].df, want) {
+		t.Errorf("Expected %v but got %v", want, b.items[0].df)
+	}
+	if first[1][1] != "#aaaaaa" || len(first) != 1 {
+		t.Errorf("Expected the first diff to be copied rather than modified")
+	}
+
+	if !b.add(encodedMessage([]byte("message")), false) {
+		t.Fatalf("Expected room for a second item")
+	}
+	if b.add(encodedMessage([]byte("message")), false) || b.addDiff(diff{3: {3: "#cccccc"}}, nil) {
+		t.Errorf("Expected the backlog to be full")
+	}
+
+	li := &listener{sendChan: make(chan *lazyMessage, 1)}
+	if b.flush(li) {
+		t.Errorf("Expected the backlog not to fit in the send buffer")
+	}
+	if got, want := string((<-li.sendChan).bytes()), "{\"1\":{\"1\":\"\"},\"2\":{\"2\":\"#bbbbbb\"}}"; got != want {
+		t.Errorf("Expected %v but got %v", want, got)
+	}
+	if !b.flush(li) || string((<-li.sendChan).bytes()) != "message" {
+		t.Errorf("Expected the rest of the backlog to be sent")
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// point is a pair of grid coordinates. Like the grid itself, X selects a row
+// as rendered by the client and Y selects a column.
+type point struct {
+	x int
+	y int
+}
+
+// pattern is a Game of Life pattern that can be stamped onto the grid.
+// Patterns are read from RLE files, which describe a pattern row by row. Each
+// RLE row maps to an X coordinate, and each RLE column maps to a Y coordinate.
+type pattern struct {
+	name string
+	// width is the number of columns (extent along the Y axis).
+	width int
+	// height is the number of rows (extent along the X axis).
+	height int
+	// cells holds the live cells of the pattern.
+	cells []patternCell
+}
+
+// patternCell is a live cell of a pattern.
+type patternCell struct {
+	// point is the offset of the cell from the top-left corner of the
+	// pattern's bounding box.
+	point
+	// species is the species of the cell, or "" if the file that the pattern
+	// was read from didn't specify one.
+	species species
+}
+
+// patternLibrary maps pattern names to patterns.
+type patternLibrary = map[string]*pattern
+
+// builtinRLE holds the patterns that are always available, keyed by name.
+var builtinRLE = map[string]string{
+	"glider": `#N Glider
+x = 3, y = 3, rule = B3/S23
+bob$2bo$3o!`,
+	"lwss": `#N Lightweight spaceship
+x = 5, y = 4, rule = B3/S23
+bo2bo$o4b$o3bo$4o!`,
+	"gosper-glider-gun": `#N Gosper glider gun
+x = 36, y = 9, rule = B3/S23
+24bo11b$22bobo11b$12b2o6b2o12b2o$11bo3bo4b2o12b2o$2o8bo5bo3b2o14b$2o8bo
+3bob2o4bobo11b$10bo5bo7bo11b$11bo3bo20b$12b2o!`,
+	"pulsar": `#N Pulsar
+x = 13, y = 13, rule = B3/S23
+2b3o3b3o2b2$o4bobo4bo$o4bobo4bo$o4bobo4bo$2b3o3b3o2b2$2b3o3b3o2b$o4bobo4b
+o$o4bobo4bo$o4bobo4bo2$2b3o3b3o!`,
+}
+
+// loadPatterns returns a library containing the built-in patterns and every
+// pattern in dir with the extension ".rle". A pattern loaded from dir is named
+// after its file, minus the extension, and replaces a built-in pattern of the
+// same name. If dir is empty, only the built-in patterns are loaded.
+func loadPatterns(dir string) (patternLibrary, error) {
+	lib := make(patternLibrary)
+	for name, rle := range builtinRLE {
+		p, err := parseRLE(name, strings.NewReader(rle))
+		if err != nil {
+			return nil, fmt.Errorf("built-in pattern %v: %w", name, err)
+		}
+		lib[name] = p
+	}
+	if dir == "" {
+		return lib, nil
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.rle"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		p, err := readRLEFile(name, path)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", path, err)
+		}
+		lib[name] = p
+	}
+	return lib, nil
+}
+
+func readRLEFile(name string, path string) (*pattern, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseRLE(name, f)
+}
+
+// sortedPatterns returns the patterns in a library sorted by name.
+func sortedPatterns(lib patternLibrary) []*pattern {
+	ps := make([]*pattern, 0, len(lib))
+	for _, p := range lib {
+		ps = append(ps, p)
+	}
+	sort.Slice(ps, func(i, j int) bool {
+		return ps[i].name < ps[j].name
+	})
+	return ps
+}
+
+// patternInfo is the JSON representation of a pattern served to clients.
+type patternInfo struct {
+	Name   string   `json:"name"`
+	Width  int      `json:"width"`
+	Height int      `json:"height"`
+	Cells  [][2]int `json:"cells"`
+}
+
+// patternInfos converts a library into a list suitable for serving as JSON.
+func patternInfos(lib patternLibrary) []patternInfo {
+	infos := make([]patternInfo, 0, len(lib))
+	for _, p := range sortedPatterns(lib) {
+		cells := make([][2]int, len(p.cells))
+		for i, c := range p.cells {
+			cells[i] = [2]int{c.x, c.y}
+		}
+		infos = append(infos, patternInfo{p.name, p.width, p.height, cells})
+	}
+	return infos
+}
+
+// stamp is a client message requesting that a pattern be placed on the grid.
+type stamp struct {
+	Name string `json:"name"`
+	// X and Y are the grid coordinates of the top-left corner of the
+	// transformed pattern's bounding box.
+	X int64 `json:"x"`
+	Y int64 `json:"y"`
+	// Rotate is the clockwise rotation in degrees: 0, 90, 180, or 270.
+	Rotate int `json:"rotate"`
+	// Reflect mirrors the pattern left-to-right before it is rotated.
+	Reflect bool    `json:"reflect"`
+	Species species `json:"species"`
+}
+
+// validateStamp checks that a stamp refers to a pattern in lib and can be
+// placed on the grid. On the plane, a stamp may be placed anywhere.
+func validateStamp(st *stamp, lib patternLibrary, topo topology) error {
+	p, ok := lib[st.Name]
+	if !ok {
+		return fmt.Errorf("stamp refers to an unknown pattern (%v)", st.Name)
+	}
+	if topo != plane && (st.X < 0 || st.X >= gridDimX) {
+		return errors.New("stamp exceeds grid's X dimension")
+	}
+	if topo != plane && (st.Y < 0 || st.Y >= gridDimY) {
+		return errors.New("stamp exceeds grid's Y dimension")
+	}
+	if st.Rotate != 0 && st.Rotate != 90 && st.Rotate != 180 && st.Rotate != 270 {
+		return fmt.Errorf("stamp has an invalid rotation (%v)", st.Rotate)
+	}
+	width, height := p.width, p.height
+	if st.Rotate == 90 || st.Rotate == 270 {
+		width, height = height, width
+	}
+	if width > gridDimY || height > gridDimX {
+		return errors.New("stamp's pattern is larger than the grid")
+	}
+	if !hexColorCode.MatchString(st.Species) {
+		return fmt.Errorf("stamp contains a species that is not a "+
+			"hexadecimal color code (%v)", st.Species)
+	}
+	return nil
+}
+
+// expandStamp converts a validated stamp into a diff. Cells that fall off the
+// edge of the grid are placed according to the topology, or dropped if the
+// grid is bounded.
+func expandStamp(st *stamp, lib patternLibrary, topo topology) diff {
+	p := lib[st.Name]
+	df := make(diff)
+	for _, c := range p.cells {
+		t := transform(c.point, p.width, p.height, st.Rotate, st.Reflect)
+		if q, ok := topo.wrap(int(st.X)+t.x, int(st.Y)+t.y); ok {
+			getOrMakeYDiff(df, q.x)[q.y] = st.Species
+		}
+	}
+	return df
+}
+
+// placePattern converts a pattern into a diff, placing the top-left corner of
+// the pattern at (x,y). Cells that fall off the edge of the grid are placed
+// according to the topology, or dropped if the grid is bounded. Cells without
+// a species are set to defaultSpecies.
+func placePattern(p *pattern, x int, y int, defaultSpecies species, topo topology) (diff, error) {
+	if x < 0 || x >= gridDimX {
+		return nil, errors.New("pattern position exceeds grid's X dimension")
+	}
+	if y < 0 || y >= gridDimY {
+		return nil, errors.New("pattern position exceeds grid's Y dimension")
+	}
+	if p.width > gridDimY || p.height > gridDimX {
+		return nil, errors.New("pattern is larger than the grid")
+	}
+	df := make(diff)
+	for _, c := range p.cells {
+		s := c.species
+		if s == "" {
+			s = defaultSpecies
+		}
+		if q, ok := topo.wrap(x+c.x, y+c.y); ok {
+			getOrMakeYDiff(df, q.x)[q.y] = s
+		}
+	}
+	if err := validateDiff(df); err != nil {
+		return nil, err
+	}
+	return df, nil
+}
+
+// transform reflects and rotates a cell offset within a pattern of the given
+// width and height, returning the offset within the transformed pattern's
+// bounding box.
+func transform(c point, width int, height int, rotate int, reflect bool) point {
+	if reflect {
+		c.y = width - 1 - c.y
+	}
+	for ; rotate > 0; rotate -= 90 {
+		// Rotating clockwise moves row x to column height-1-x, and column y
+		// to row y. The width and height are swapped.
+		c = point{c.y, height - 1 - c.x}
+		width, height = height, width
+	}
+	return c
+}
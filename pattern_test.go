@@ -0,0 +1,131 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_loadPatterns(t *testing.T) {
+	dir := t.TempDir()
+	rle := []byte("x = 2, y = 2\n2o$2o!")
+	if err := os.WriteFile(filepath.Join(dir, "block.rle"), rle, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "ignored.txt"), rle, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	lib, err := loadPatterns(dir)
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := map[string]int{
+		"glider":            5,
+		"lwss":              9,
+		"gosper-glider-gun": 36,
+		"pulsar":            48,
+		"block":             4,
+	}
+	if len(lib) != len(expected) {
+		t.Errorf("Expected %v patterns but got %v", len(expected), len(lib))
+	}
+	for name, n := range expected {
+		p, ok := lib[name]
+		if !ok {
+			t.Errorf("Expected pattern %q to be loaded", name)
+			continue
+		}
+		if len(p.cells) != n {
+			t.Errorf("Expected pattern %q to have %v cells but got %v", name, n, len(p.cells))
+		}
+	}
+}
+
+func Test_transform(t *testing.T) {
+	// A 2x3 pattern (2 rows, 3 columns) with a cell in the top-right corner.
+	c := point{0, 2}
+	tests := []struct {
+		rotate   int
+		reflect  bool
+		expected point
+	}{
+		{0, false, point{0, 2}},
+		{90, false, point{2, 1}},
+		{180, false, point{1, 0}},
+		{270, false, point{0, 0}},
+		{0, true, point{0, 0}},
+		{90, true, point{0, 1}},
+	}
+	for _, tt := range tests {
+		if got := transform(c, 3, 2, tt.rotate, tt.reflect); got != tt.expected {
+			t.Errorf("transform(rotate=%v, reflect=%v): expected %v but got %v",
+				tt.rotate, tt.reflect, tt.expected, got)
+		}
+	}
+}
+
+func Test_expandStamp(t *testing.T) {
+	lib := defaultConfig().patterns
+	st := &stamp{Name: "glider", X: gridDimX - 1, Y: 5, Species: "#aaaaaa"}
+
+	df := expandStamp(st, lib, torus)
+
+	// The glider's second and third rows wrap around to the top of the grid.
+	expected := []point{{gridDimX - 1, 6}, {0, 7}, {1, 5}, {1, 6}, {1, 7}}
+	n := 0
+	for _, ydiff := range df {
+		n += len(ydiff)
+	}
+	if n != len(expected) {
+		t.Errorf("Expected %v cells but got %v", len(expected), df)
+	}
+	for _, c := range expected {
+		if v := df[c.x][c.y]; v != "#aaaaaa" {
+			t.Errorf("Expected (%v, %v) to be \"#aaaaaa\" but got %q", c.x, c.y, v)
+		}
+	}
+}
+
+// On the plane, a stamp should be placed at any coordinates that its cells
+// can be addressed at, and rejected past the largest coordinates.
+func Test_expandPlaneStamp(t *testing.T) {
+	lib := defaultConfig().patterns
+	st := &stamp{Name: "glider", X: -1, Y: math.MaxInt64 - 2, Species: "#aaaaaa"}
+
+	df, err := expandPlaneStamp(st, lib)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if v := df[1][math.MaxInt64]; v != "#aaaaaa" {
+		t.Errorf("Expected the glider's last cell at the edge of the plane but got %v", df)
+	}
+
+	st.Y++
+	if _, err := expandPlaneStamp(st, lib); err == nil {
+		t.Errorf("Expected an error for a stamp past the edge of the plane")
+	}
+}
+
+func Test_validateStamp(t *testing.T) {
+	lib := defaultConfig().patterns
+	valid := stamp{Name: "glider", X: 10, Y: 10, Rotate: 90, Species: "#aaaaaa"}
+	if err := validateStamp(&valid, lib, torus); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	invalid := []stamp{valid, valid, valid, valid, valid}
+	invalid[0].Name = "nonexistent"
+	invalid[1].X = gridDimX
+	invalid[2].Y = -1
+	invalid[3].Rotate = 45
+	invalid[4].Species = ""
+	for _, st := range invalid {
+		st := st
+		if err := validateStamp(&st, lib, torus); err == nil {
+			t.Errorf("Expected an error validating %+v", st)
+		}
+	}
+}
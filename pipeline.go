@@ -2,6 +2,8 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"sync"
 	"time"
@@ -18,52 +20,74 @@ type writeToConn = func(messageType int, data []byte) error
 // closeConn decouples the application from websocket.Conn.closeConn for testing purposes.
 type closeConn = func() error
 
-const sendBufferLen = 256
-
 type pipeline struct {
+	cfg         *config
 	readPumpOut chan interface{}
 	golChan     chan interface{}
 	hubChan     chan interface{}
+	clockChan   chan time.Duration
+	conns       *connectionLimiter
 }
 
 // startPipeline runs clock, gol, and hub in separate goroutines and connects
 // them in that order via channels.
-func startPipeline() *pipeline {
+func startPipeline(cfg *config) *pipeline {
 	golChan := make(chan interface{})
-	pl := startPipelineInternal(golChan, golChan)
-	go clock(golChan)
+	pl := startPipelineInternal(cfg, golChan, golChan)
+	go clock(cfg.tickInterval, pl.clockChan, golChan)
+	go presenceClock(pl.hubChan)
+	startBots(pl)
 	return pl
 }
 
 // Internal implementation of startPipeline exposed for testing purposes. It
 // allows an additional stage to be added between readPump and gol, and omits
-// clock so that tests can control gol via tick messages.
-func startPipelineInternal(readPumpOut chan interface{}, golChan chan interface{}) *pipeline {
+// clock and presenceClock so that tests can control gol via tick messages and
+// hub via presenceTick messages. Tests that send setTickInterval must receive
+// the interval from clockChan.
+func startPipelineInternal(cfg *config, readPumpOut chan interface{}, golChan chan interface{}) *pipeline {
 	hubChan := make(chan interface{})
-	go gol(golChan, hubChan)
-	go hub(hubChan)
-	return &pipeline{readPumpOut, golChan, hubChan}
+	clockChan := make(chan time.Duration)
+	go gol(cfg, golChan, hubChan, clockChan)
+	go hub(cfg, hubChan, golChan)
+	return &pipeline{cfg, readPumpOut, golChan, hubChan, clockChan, newConnectionLimiter(cfg)}
 
 }
 
 // attachConn attaches a connection to a pipeline. It starts readPump in a
 // goroutine that sends messages to gol, and starts writePump in a goroutine
 // that receives messages from hub. It also causes initialization data to be
-// sent to the client. For testing purposes, attachConn returns the errorSignal
+// sent to the client. caps holds the optional protocol features that the
+// client asked for, and player identifies the player using the connection.
+// For spectators, spectatorReadPump runs in place of readPump, so that nothing
+// is forwarded to gol.
+// For testing purposes, attachConn returns the errorSignal
 // associated with the connection and a WaitGroup that can be used to wait for
 // writePump and readPump to stop.
-func attachConn(pl *pipeline, re readFromConn, wr writeToConn, cl closeConn) (*sync.WaitGroup, *errorSignal) {
+func attachConn(pl *pipeline, re readFromConn, wr writeToConn, cl closeConn, caps capabilities, player playerID) (*sync.WaitGroup, *errorSignal) {
+	return resumeConn(pl, re, wr, cl, caps, player, -1)
+}
+
+// resumeConn is attachConn for a client that has already received the grid as
+// of generation gen. If gol still holds the diffs since then, the client is
+// sent one diff that catches it up rather than the grid. If gen is negative,
+// the client is sent the grid, as by attachConn.
+func resumeConn(pl *pipeline, re readFromConn, wr writeToConn, cl closeConn, caps capabilities, player playerID, gen int) (*sync.WaitGroup, *errorSignal) {
 	// errorSignal for this connection
 	errSig := newErrorSignal()
 	// Channel of messages to send on this connection
-	sendChan := make(chan []byte, sendBufferLen)
+	sendChan := make(chan *lazyMessage, pl.cfg.sendBufferLen)
 
 	// Register this connection's send channel and errorSignal with the hub.
-	li := &listener{sendChan, errSig}
+	li := &listener{sendChan, errSig, caps}
 	pl.hubChan <- &register{li}
 
 	// Tell gol to send down initialization data.
-	pl.golChan <- &initListener{li}
+	if gen < 0 {
+		pl.golChan <- &initListener{li}
+	} else {
+		pl.golChan <- &resumeListener{li, gen}
+	}
 
 	var wg sync.WaitGroup
 	wg.Add(2)
@@ -74,7 +98,11 @@ func attachConn(pl *pipeline, re readFromConn, wr writeToConn, cl closeConn) (*s
 	}()
 	go func() {
 		defer wg.Done()
-		readPump(errSig, re, pl.readPumpOut)
+		if caps.spectator {
+			spectatorReadPump(errSig, re)
+			return
+		}
+		readPump(errSig, re, pl, li, player)
 	}()
 	return &wg, errSig
 }
@@ -93,6 +121,12 @@ func (errHan *errorHandler) run(err error) {
 		// to explicitly unregister.
 		errHan.hubChan <- &unregister{errHan.li}
 	}
+	if text, ok := errorText(err); ok && errHan.li.caps.version == protocolV2 {
+		message, _ := json.Marshal(&errorMessage{"error", text})
+		if err := errHan.wr(websocket.BinaryMessage, message); err != nil {
+			log.Printf("Error sending error message: %v\n", err)
+		}
+	}
 	if !websocket.IsUnexpectedCloseError(err) {
 		// The error is not due to the client closing the connection. Attempt
 		// to send a close message. If this *was* a close error, then Gorilla
@@ -110,93 +144,394 @@ func (errHan *errorHandler) run(err error) {
 	}
 }
 
-// readPump runs a loop that reads a message from the connection, unmarshals
-// JSON into a diff, validates the diff, and sends the mergeDiff message to
-// gol.
-func readPump(errSig *errorSignal, read readFromConn, golChan chan<- interface{}) {
+// readPump runs a loop that reads a message from the connection, converts it
+// into a message for gol or hub, and sends it on. Chat messages and presence
+// updates go to hub, so that they never hold up gol, and all other messages go
+// to gol via pl.readPumpOut. Presence updates that arrive less than
+// minPresenceUpdateInterval after the last one are dropped, and chat messages
+// and requests for statistics beyond their rate limits are answered with a
+// notice. See decodeMessage.
+func readPump(errSig *errorSignal, read readFromConn, pl *pipeline, li *listener, player playerID) {
+	limiter := newChatLimiter()
+	statsLimiter := newStatsLimiter()
+	var lastPresence time.Time
 	for {
 		_, message, err := read()
 		if err != nil {
 			errSig.send(err)
 			return
 		}
+		m, err := decodeMessage(message, pl.cfg, li, player)
+		if err != nil {
+			errSig.send(&invalidMessageError{err})
+			return
+		}
+		if c, ok := m.(*chat); ok {
+			if !limiter.allow(time.Now()) {
+				message, _ := json.Marshal(&noticeMessage{"notice",
+					"You're sending chat messages too quickly."})
+				pl.hubChan <- &forward{li, message}
+				continue
+			}
+			pl.hubChan <- c
+			continue
+		}
+		if _, ok := m.(*requestStats); ok && !statsLimiter.allow(time.Now()) {
+			message, _ := json.Marshal(&noticeMessage{"notice",
+				"You're asking for statistics too quickly."})
+			pl.hubChan <- &forward{li, message}
+			continue
+		}
+		if p, ok := m.(*presence); ok {
+			now := time.Now()
+			if now.Sub(lastPresence) < minPresenceUpdateInterval {
+				continue
+			}
+			lastPresence = now
+			pl.hubChan <- p
+			continue
+		}
+		pl.readPumpOut <- m
+	}
+}
+
+// decodeMessage unmarshals and validates a client message, returning the
+// message for gol that it represents. A client message is either a bare diff,
+// which only version 1 clients may send, or an object with a "type" field. A
+// "diff" holds a diff in its "cells" field, a "stamp" is expanded into a diff
+// using the config's pattern library, an "ant" spawns an ant, "stats" asks for
+// player statistics to be sent to li, "chat" is a chat message, "presence"
+// updates li's cursor and viewport, and "subscribe" limits the diffs sent to
+// li to a region of the grid. Diffs, chat messages and presence are
+// attributed to player.
+func decodeMessage(message []byte, cfg *config, li *listener, player playerID) (interface{}, error) {
+	var envelope struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(message, &envelope); err != nil {
+		return nil, err
+	}
+	if cfg.topology == plane {
+		if m, ok, err := decodePlaneMessage(message, envelope.Type, cfg, li, player); ok {
+			return m, err
+		}
+	}
+	switch envelope.Type {
+	case "":
+		if li.caps.version != protocolV1 {
+			return nil, errors.New("message has no type")
+		}
 		df := make(diff)
 		if err := json.Unmarshal(message, &df); err != nil {
-			errSig.send(err)
-			return
+			return nil, err
 		}
 		if err := validateDiff(df); err != nil {
-			errSig.send(err)
-			return
+			return nil, err
+		}
+		return &mergeDiff{df, player}, nil
+	case "diff":
+		var d struct {
+			Cells diff `json:"cells"`
+		}
+		if err := json.Unmarshal(message, &d); err != nil {
+			return nil, err
+		}
+		if d.Cells == nil {
+			d.Cells = make(diff)
+		}
+		if err := validateDiff(d.Cells); err != nil {
+			return nil, err
+		}
+		return &mergeDiff{d.Cells, player}, nil
+	case "subscribe":
+		if li.caps.version == protocolV1 {
+			return nil, errors.New("subscribe requires protocol version 2")
+		}
+		var sub struct {
+			Viewport *viewportJSON `json:"viewport"`
+		}
+		if err := json.Unmarshal(message, &sub); err != nil {
+			return nil, err
+		}
+		if v := sub.Viewport; v != nil {
+			r, err := v.region()
+			if err != nil {
+				return nil, err
+			}
+			return &subscribe{li, &r}, nil
+		}
+		return &subscribe{li, nil}, nil
+	case "stamp":
+		st := &stamp{}
+		if err := json.Unmarshal(message, st); err != nil {
+			return nil, err
+		}
+		if err := validateStamp(st, cfg.patterns, cfg.topology); err != nil {
+			return nil, err
 		}
-		golChan <- &mergeDiff{df}
+		return &mergeDiff{expandStamp(st, cfg.patterns, cfg.topology), player}, nil
+	case "ant":
+		a := &ant{}
+		if err := json.Unmarshal(message, a); err != nil {
+			return nil, err
+		}
+		if err := validateAnt(a); err != nil {
+			return nil, err
+		}
+		return &spawnAnt{a}, nil
+	case "stats":
+		return &requestStats{li, player}, nil
+	case "chat":
+		var c struct {
+			Text string `json:"text"`
+		}
+		if err := json.Unmarshal(message, &c); err != nil {
+			return nil, err
+		}
+		text, err := validateChat(c.Text)
+		if err != nil {
+			return nil, err
+		}
+		return &chat{player, text}, nil
+	case "presence":
+		p := &presence{}
+		if err := json.Unmarshal(message, p); err != nil {
+			return nil, err
+		}
+		if err := validatePresence(p, cfg.topology); err != nil {
+			return nil, err
+		}
+		p.li = li
+		p.Player = player
+		return p, nil
+	default:
+		return nil, fmt.Errorf("unknown message type (%v)", envelope.Type)
 	}
 }
 
-func clock(golChan chan<- interface{}) {
+// clock runs a loop that sends a tick message to gol at a regular interval.
+// The interval can be changed by sending a new one on intervalChan. clock
+// continues to receive from intervalChan while waiting for gol to accept a
+// tick, so gol can send on intervalChan without risk of deadlock.
+func clock(interval time.Duration, intervalChan <-chan time.Duration, golChan chan<- interface{}) {
+	ticker := time.NewTicker(interval)
 	for {
-		time.Sleep(170 * time.Millisecond)
-		golChan <- &tick{}
+		select {
+		case <-ticker.C:
+			select {
+			case golChan <- &tick{}:
+			case d := <-intervalChan:
+				ticker.Reset(d)
+			}
+		case d := <-intervalChan:
+			ticker.Reset(d)
+		}
 	}
 }
 
+// mergeDiff merges changes from a player into the next generation.
 type mergeDiff struct {
-	df diff
+	df     diff
+	player playerID
 }
 
 type initListener struct {
 	li *listener
 }
 
+// resumeListener is initListener for a Listener that has already received the
+// grid as of generation gen. See resumeConn.
+type resumeListener struct {
+	li  *listener
+	gen int
+}
+
 type tick struct{}
 
+// pause causes gol to ignore tick messages until a resume message is received.
+type pause struct{}
+
+type resume struct{}
+
+// step advances the game by one generation, whether or not gol is paused.
+type step struct{}
+
+// setTickInterval changes the interval between tick messages. gol forwards
+// the interval to clock.
+type setTickInterval struct {
+	d time.Duration
+}
+
+// clearGrid kills every cell in the next generation.
+type clearGrid struct{}
+
+// notice broadcasts a message from the server's operator to all clients.
+type notice struct {
+	text string
+}
+
+// getGrid requests a copy of the grid, which gol sends on reply. reply should
+// be buffered so that gol doesn't block.
+type getGrid struct {
+	reply chan<- *grid
+}
+
+// currentGrid returns a copy of the grid as of the most recent tick.
+func (pl *pipeline) currentGrid() *grid {
+	reply := make(chan *grid, 1)
+	pl.golChan <- &getGrid{reply}
+	return <-reply
+}
+
 // gol maintains the state of an instance of Conway's Game of Life, merging in
 // changes from clients and propogating changes to hub to be broadcast to
-// clients. See protocol.md for more context regarding the implementation.
-func gol(in <-chan interface{}, hubChan chan<- interface{}) {
-	g, df := &grid{}, make(diff)
+// clients. The cells are held by a board, which depends on the topology. See
+// protocol.md for more context regarding the implementation.
+func gol(cfg *config, in <-chan interface{}, hubChan chan<- interface{}, clockChan chan<- time.Duration) {
+	var b board
+	if cfg.topology == plane {
+		b = newPlaneBoard(cfg)
+	} else {
+		b = newGridBoard(cfg)
+	}
+	h := &history{max: cfg.historyLen}
 
 	// isEmptyDiffSent is true if the grid has stopped evolving (because it is
 	// empty or consists entirely of still lifes), and we have broadcasted a
 	// single empty diff to indicate that the stream of messages has ended.
 	isEmptyDiffSent := false
 
+	isPaused := false
+
+	lb := &leaderboard{}
+	// ticksSinceLeaderboard counts generations since the leaderboard was last
+	// broadcast.
+	ticksSinceLeaderboard := 0
+
+	advance := func() {
+		if message, recorded := b.next(); message != nil {
+			hubChan <- message
+			h.record(recorded)
+			isEmptyDiffSent = false
+		} else if !isEmptyDiffSent {
+			hubChan <- &broadcast{encode: marshalStreamEnd}
+			isEmptyDiffSent = true
+		}
+		if cfg.leaderboardInterval > 0 {
+			ticksSinceLeaderboard++
+			if ticksSinceLeaderboard == cfg.leaderboardInterval {
+				ticksSinceLeaderboard = 0
+				top := b.top()
+				lb.record(leaderboardSample{time.Now(), top})
+				message, _ := json.Marshal(&leaderboardMessage{"leaderboard", top})
+				hubChan <- &broadcastTyped{message}
+			}
+		}
+	}
+
+	// initialize sends the grid to a new Listener, followed by the end of the
+	// stream if the grid has stopped evolving.
+	initialize := func(li *listener) {
+		hubChan <- &forward{li, b.init(li, h.count)}
+		// Version 2 clients receive the world in the init message.
+		if li.caps.world && li.caps.version == protocolV1 {
+			worldMessage, _ := json.Marshal(&worldMessage{"world", newWorldInfo(cfg)})
+			hubChan <- &forward{li, worldMessage}
+		}
+		if isEmptyDiffSent {
+			// Send the end of the stream to this new Listener as well.
+			hubChan <- &forward{li, marshalStreamEnd(li.caps)}
+		}
+		hubChan <- &initialized{li}
+	}
+
 	// We could handle one mergeDiff message and an arbitrary number of
-	// initListener messages concurrently. But for simplicity of implementation
-	// we'll have one goroutine handle all three message types.
+	// initListener, getGrid, and getHistory messages concurrently. But for
+	// simplicity of implementation we'll have one goroutine handle all message
+	// types.
 	for {
 		switch m := (<-in).(type) {
 		case *mergeDiff:
-			merge(m.df, df)
+			b.merge(m)
+		case *mergePlaneDiff:
+			b.merge(m)
+		case *submitDiff:
+			b.merge(m.change)
+			// The diff is broadcast, and applied to the grid, as part of
+			// the next generation.
+			m.reply <- h.count + 1
 		case *initListener:
-			gridMessage, _ := json.Marshal(g)
-			hubChan <- &forward{m.li, gridMessage}
+			initialize(m.li)
+		case *resumeListener:
+			diffs, ok := h.since(m.gen)
+			var message []byte
+			if ok {
+				message, ok = b.catchUp(m.li, diffs)
+			}
+			if !ok {
+				initialize(m.li)
+				break
+			}
+			if message != nil {
+				hubChan <- &forward{m.li, message}
+			}
 			if isEmptyDiffSent {
-				// Send the empty diff to this new Listener as well.
-				emptyDiffMessage, _ := json.Marshal(df)
-				hubChan <- &forward{m.li, emptyDiffMessage}
+				hubChan <- &forward{m.li, marshalStreamEnd(m.li.caps)}
 			}
+			hubChan <- &initialized{m.li}
+		case *resync:
+			hubChan <- &resyncGrid{m.li, b.init(m.li, h.count)}
+			if isEmptyDiffSent {
+				hubChan <- &forward{m.li, marshalStreamEnd(m.li.caps)}
+			}
+		case *subscribe:
+			hubChan <- b.subscribe(m)
+		case *subscribeChunks:
+			hubChan <- b.subscribe(m)
+		case *getGrid:
+			m.reply <- b.window()
+		case *getGridReport:
+			m.reply <- &gridReport{b.window(), h.count}
+		case *getCell:
+			m.reply <- cellReport{h.count, b.get(m.x, m.y)}
+		case *getHistory:
+			m.reply <- h.snapshot()
+		case *getLeaderboard:
+			m.reply <- &leaderboardReport{b.top(), lb.samples}
+		case *getPlayerStats:
+			m.reply <- b.stats()
+		case *requestStats:
+			message, _ := json.Marshal(&statsMessage{"stats", m.player, b.stats()})
+			hubChan <- &forward{m.li, message}
 		case *tick:
-			if len(df) != 0 {
-				message, _ := json.Marshal(df)
-				hubChan <- &broadcast{message}
-				flush(df, g)
-				nextState(g, df)
-				isEmptyDiffSent = false
-			} else if !isEmptyDiffSent {
-				message, _ := json.Marshal(df)
-				hubChan <- &broadcast{message}
-				isEmptyDiffSent = true
-			}
-			// Note: Using len(diff) to determine whether the grid has stopped
-			// evolving hinges on the assumption that it isn't possible to
-			// "cancel out" a diff via a mergeDiff message. So care would need
-			// to be taken if we were to implement, e.g., a way for the client
-			// to erase grid cells.
+			if !isPaused {
+				advance()
+			}
+		case *step:
+			advance()
+		case *pause:
+			isPaused = true
+		case *resume:
+			isPaused = false
+		case *setTickInterval:
+			clockChan <- m.d
+		case *clearGrid:
+			b.clear()
+		case *spawnAnt:
+			b.spawnAnt(m.a)
+		case *notice:
+			message, _ := json.Marshal(&noticeMessage{"notice", m.text})
+			hubChan <- &broadcastTyped{message}
 		}
 	}
 }
 
+// noticeMessage is the JSON representation of a notice sent to clients.
+type noticeMessage struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
 // register a Listener
 type register struct {
 	li *listener
@@ -207,8 +542,67 @@ type unregister struct {
 	li *listener
 }
 
-// broadcast a websocket message to all registered Listeners
+// lazyMessage is a websocket message that is encoded by the first writePump
+// that writes it, so that the cost of encoding diffs falls on the connections
+// rather than on hub. Listeners that are sent the same lazyMessage share its
+// encoding.
+type lazyMessage struct {
+	once   sync.Once
+	encode func() []byte
+	data   []byte
+}
+
+// newLazyMessage returns a message that is encoded by encode when it is first
+// needed. encode may be called from any goroutine, so it must only read data
+// that nobody modifies.
+func newLazyMessage(encode func() []byte) *lazyMessage {
+	return &lazyMessage{encode: encode}
+}
+
+// encodedMessage returns a message that has already been encoded.
+func encodedMessage(data []byte) *lazyMessage {
+	m := &lazyMessage{data: data}
+	m.once.Do(func() {})
+	return m
+}
+
+// bytes returns the encoded message, encoding it if need be.
+func (m *lazyMessage) bytes() []byte {
+	m.once.Do(func() {
+		m.data = m.encode()
+		m.encode = nil
+	})
+	return m.data
+}
+
+// broadcast a websocket message to all registered Listeners. encode encodes
+// the message for the diff format of a Listener's capabilities. It is called
+// only for the formats that the Listeners use, at most once each, by the
+// writePumps of the Listeners.
 type broadcast struct {
+	encode func(caps capabilities) []byte
+	// variants holds the message for each diff format that has been sent.
+	variants map[capabilities]*lazyMessage
+}
+
+// variant returns the message to send to a Listener.
+func (b *broadcast) variant(li *listener) *lazyMessage {
+	caps := li.caps.diffFormat()
+	if m, ok := b.variants[caps]; ok {
+		return m
+	}
+	if b.variants == nil {
+		b.variants = make(map[capabilities]*lazyMessage)
+	}
+	m := newLazyMessage(func() []byte { return b.encode(caps) })
+	b.variants[caps] = m
+	return m
+}
+
+// broadcastTyped broadcasts a typed message, such as a notice or the
+// leaderboard, to the Listeners that accept typed messages. See
+// capabilities.acceptsTyped.
+type broadcastTyped struct {
 	message []byte
 }
 
@@ -218,45 +612,279 @@ type forward struct {
 	message []byte
 }
 
-// hub runs a loop that sends websocket messages to Listeners.
-func hub(in <-chan interface{}) {
+// hub runs a loop that sends websocket messages to Listeners, filtering diffs
+// for Listeners that have subscribed to a region of the grid. It also relays
+// chat messages, keeping the most recent ones for new Listeners, and sends
+// snapshots of every Listener's presence on each presenceTick. When a
+// Listener's buffer is full, hub applies cfg.overflowPolicy, asking gol for a
+// fresh grid on golChan if need be.
+func hub(cfg *config, in <-chan interface{}, golChan chan<- interface{}) {
 	listeners := make(map[*listener]bool)
+	room := newChatRoom()
+	pb := newPresenceBoard()
+	// subscriptions holds the region of each Listener that has subscribed to
+	// part of the grid.
+	subscriptions := make(map[*listener]region)
+	// chunkSubscriptions holds the chunks that each Listener has subscribed
+	// to on the plane.
+	chunkSubscriptions := make(map[*listener]chunkRange)
+	// backlogs holds the messages held back for each Listener whose buffer
+	// is full under the coalesce policy.
+	backlogs := make(map[*listener]*backlog)
+	// resyncing holds the Listeners that are waiting for a fresh grid under
+	// the freshGrid policy. Messages to them are dropped.
+	resyncing := make(map[*listener]bool)
+	remove := func(li *listener) {
+		delete(listeners, li)
+		delete(room.ready, li)
+		delete(subscriptions, li)
+		delete(chunkSubscriptions, li)
+		delete(backlogs, li)
+		delete(resyncing, li)
+		pb.remove(li)
+	}
+	// overflow applies the overflow policy to a Listener whose buffer is
+	// full. item is the message that didn't fit.
+	overflow := func(li *listener, item *backlogItem) {
+		policy := cfg.overflowPolicy
+		if policy == coalesce && li.caps.ages {
+			// Every live cell in a coalesced diff has age 0, which would be
+			// wrong for cells born before its last generation.
+			policy = freshGrid
+		}
+		switch policy {
+		case coalesce:
+			backlogs[li] = &backlog{[]*backlogItem{item}, cfg.sendBufferLen}
+		case freshGrid:
+			drain(li)
+			resyncing[li] = true
+			// gol may be blocked sending to hub, so ask from another
+			// goroutine.
+			go func() { golChan <- &resync{li} }()
+		default:
+			li.errSig.send(&bufferOverflowError{})
+			remove(li)
+		}
+	}
+	// sendLazy sends a message to a Listener, applying the overflow policy
+	// if its buffer is full. typed is true for typed messages that aren't
+	// part of the stream of diffs, such as chat messages. See backlog.
+	sendLazy := func(li *listener, message *lazyMessage, typed bool) {
+		if resyncing[li] {
+			return
+		}
+		if b, ok := backlogs[li]; ok {
+			if !b.add(message, typed) {
+				li.errSig.send(&bufferOverflowError{})
+				remove(li)
+			}
+			return
+		}
+		select {
+		case li.sendChan <- message:
+		default:
+			overflow(li, &backlogItem{message: message, typed: typed})
+		}
+	}
+	// send is sendLazy for a message in the stream that has already been
+	// encoded.
+	send := func(li *listener, message []byte) {
+		sendLazy(li, encodedMessage(message), false)
+	}
+	// sendTyped is send for a typed message that isn't part of the stream.
+	sendTyped := func(li *listener, message []byte) {
+		sendLazy(li, encodedMessage(message), true)
+	}
+	// sendDiff is sendLazy for a diff of the grid, which can be merged with
+	// other diffs if the Listener falls behind.
+	sendDiff := func(li *listener, df diff, ants []ant, message *lazyMessage) {
+		if resyncing[li] {
+			return
+		}
+		if b, ok := backlogs[li]; ok {
+			if !b.addDiff(df, ants) {
+				li.errSig.send(&bufferOverflowError{})
+				remove(li)
+			}
+			return
+		}
+		select {
+		case li.sendChan <- message:
+		default:
+			overflow(li, &backlogItem{df: copyDiff(df), ants: ants, generations: 1})
+		}
+	}
+	// sendPlaneDiff is the counterpart of sendDiff for the plane.
+	sendPlaneDiff := func(li *listener, df planeDiff, message *lazyMessage) {
+		if resyncing[li] {
+			return
+		}
+		if b, ok := backlogs[li]; ok {
+			if !b.addPlaneDiff(df) {
+				li.errSig.send(&bufferOverflowError{})
+				remove(li)
+			}
+			return
+		}
+		select {
+		case li.sendChan <- message:
+		default:
+			overflow(li, &backlogItem{planeDf: copyPlaneDiff(df), generations: 1})
+		}
+	}
 	for {
-		switch m := (<-in).(type) {
+		m := <-in
+		// Before handling the message, send what we can of each backlog, so
+		// that the message doesn't overtake it.
+		for li, b := range backlogs {
+			if b.flush(li) {
+				delete(backlogs, li)
+			}
+		}
+		switch m := m.(type) {
 		case *register:
 			listeners[m.li] = true
 		case *unregister:
-			delete(listeners, m.li)
+			remove(m.li)
 		case *broadcast:
 			for li := range listeners {
-				select {
-				case li.sendChan <- m.message:
-				default:
-					li.errSig.send(&bufferOverflowError{})
-					delete(listeners, li)
+				sendLazy(li, m.variant(li), false)
+			}
+		case *broadcastTyped:
+			for li := range listeners {
+				if li.caps.acceptsTyped() {
+					sendTyped(li, m.message)
+				}
+			}
+		case *broadcastDiff:
+			for li := range listeners {
+				if r, ok := subscriptions[li]; ok {
+					df, message := m.regionVariant(li, r)
+					sendDiff(li, df, m.ants, message)
+				} else {
+					sendDiff(li, m.df, m.ants, m.variant(li))
 				}
 			}
+		case *subscribed:
+			if !listeners[m.li] {
+				break
+			}
+			old, ok := subscriptions[m.li]
+			if !ok {
+				old = fullRegion
+			}
+			r := fullRegion
+			if m.region != nil {
+				r = *m.region
+				subscriptions[m.li] = r
+			} else {
+				delete(subscriptions, m.li)
+			}
+			caps := m.li.caps
+			sendLazy(m.li, newLazyMessage(func() []byte {
+				return marshalSubscribed(r, old, m.g, m.ages, caps)
+			}), false)
+		case *broadcastPlaneDiff:
+			// Listeners that haven't subscribed to any chunks still receive a
+			// diff for each generation, but without any cells.
+			unsubscribed := newLazyMessage(func() []byte {
+				return marshalPlaneDiff(planeDiff{})
+			})
+			for li := range listeners {
+				if cr, ok := chunkSubscriptions[li]; ok {
+					df, message := m.variant(cr)
+					sendPlaneDiff(li, df, message)
+				} else {
+					sendPlaneDiff(li, planeDiff{}, unsubscribed)
+				}
+			}
+		case *subscribedChunks:
+			if !listeners[m.li] {
+				break
+			}
+			old, hadOld := chunkSubscriptions[m.li]
+			entering := make(planeDiff)
+			for x, ydiff := range m.cells {
+				for y, s := range ydiff {
+					if k, _, _ := locate(x, y); !hadOld || !old.contains(k) {
+						getOrMakePlaneYDiff(entering, x)[y] = s
+					}
+				}
+			}
+			var viewport *viewportJSON
+			if m.cr != nil {
+				chunkSubscriptions[m.li] = *m.cr
+				v := m.cr.cells()
+				viewport = &v
+			} else {
+				delete(chunkSubscriptions, m.li)
+			}
+			message, _ := json.Marshal(&planeSubscribedMessage{"subscribed", viewport, entering})
+			send(m.li, message)
 		case *forward:
-			select {
-			case m.li.sendChan <- m.message:
-			default:
-				m.li.errSig.send(&bufferOverflowError{})
-				delete(listeners, m.li)
+			send(m.li, m.message)
+		case *resyncGrid:
+			if !resyncing[m.li] {
+				// The Listener was dropped while waiting.
+				break
+			}
+			delete(resyncing, m.li)
+			// A client on the plane must subscribe again after a fresh init
+			// message.
+			delete(chunkSubscriptions, m.li)
+			send(m.li, m.message)
+		case *initialized:
+			if !listeners[m.li] {
+				// The Listener was dropped before it was initialized.
+				break
+			}
+			if !m.li.caps.acceptsTyped() {
+				break
+			}
+			room.ready[m.li] = true
+			for _, message := range room.history() {
+				sendTyped(m.li, message)
+			}
+			if len(pb.current) > 0 {
+				sendTyped(m.li, pb.snapshot())
+			}
+		case *chat:
+			message := room.post(m, time.Now())
+			// Only send chat to Listeners that have received the grid, since
+			// clients expect the grid to be the first message.
+			for li := range room.ready {
+				sendTyped(li, message)
+			}
+		case *presence:
+			if !listeners[m.li] {
+				// Don't let a late update outlive the Listener's unregister.
+				break
+			}
+			pb.update(m)
+		case *presenceTick:
+			if !pb.changed {
+				break
+			}
+			pb.changed = false
+			message := pb.snapshot()
+			for li := range room.ready {
+				sendTyped(li, message)
 			}
 		}
 	}
 }
 
 // writePump runs a loop that copies a message from sendChan to the connection,
-// or executes error handling when a connection-specific error is detected.
-func writePump(errSig *errorSignal, errHan *errorHandler, sendChan <-chan []byte, write writeToConn) {
+// encoding it if no other writePump has, or executes error handling when a
+// connection-specific error is detected.
+func writePump(errSig *errorSignal, errHan *errorHandler, sendChan <-chan *lazyMessage, write writeToConn) {
 	for {
 		select {
 		case <-errSig.signal():
 			errHan.run(errSig.err())
 			return
 		case message := <-sendChan:
-			if err := write(websocket.BinaryMessage, message); err != nil {
+			if err := write(websocket.BinaryMessage, message.bytes()); err != nil {
 				errHan.run(err)
 				return
 			}
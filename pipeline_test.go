@@ -1,8 +1,10 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -13,7 +15,7 @@ import (
 func Test_pipeline(t *testing.T) {
 	readPumpOut := make(chan interface{})
 	golChan := make(chan interface{})
-	pl := startPipelineInternal(readPumpOut, golChan)
+	pl := startPipelineInternal(defaultConfig(), readPumpOut, golChan)
 
 	// When a new connection is made, the pipeline should send the Game of Life
 	// state as JSON to that connection.
@@ -21,8 +23,8 @@ func Test_pipeline(t *testing.T) {
 	in1, out1, re1, wr1, cl1 := newConn(t)
 	in2, out2, re2, wr2, cl2 := newConn(t)
 
-	attachConn(pl, re1, wr1, cl1)
-	attachConn(pl, re2, wr2, cl2)
+	attachConn(pl, re1, wr1, cl1, capabilities{}, "")
+	attachConn(pl, re2, wr2, cl2, capabilities{}, "")
 
 	json := string(recv(t, out1))
 	if !strings.HasPrefix(json, "[") {
@@ -79,7 +81,7 @@ func Test_pipeline(t *testing.T) {
 
 	_, out3, re3, wr3, cl3 := newConn(t)
 
-	attachConn(pl, re3, wr3, cl3)
+	attachConn(pl, re3, wr3, cl3, capabilities{}, "")
 
 	json = string(recv(t, out3))
 	if !strings.HasPrefix(json, "[") || !strings.Contains(json, "\"#aaaaaa\"") {
@@ -90,13 +92,13 @@ func Test_pipeline(t *testing.T) {
 func Test_pipelineEmptyDiff(t *testing.T) {
 	readPumpOut := make(chan interface{})
 	golChan := make(chan interface{})
-	pl := startPipelineInternal(readPumpOut, golChan)
+	pl := startPipelineInternal(defaultConfig(), readPumpOut, golChan)
 
 	in1, out1, re1, wr1, cl1 := newConn(t)
 	_, out2, re2, wr2, cl2 := newConn(t)
 
-	attachConn(pl, re1, wr1, cl1)
-	attachConn(pl, re2, wr2, cl2)
+	attachConn(pl, re1, wr1, cl1, capabilities{}, "")
+	attachConn(pl, re2, wr2, cl2, capabilities{}, "")
 
 	// Handle the GoL state initialization message
 	recv(t, out1)
@@ -160,7 +162,7 @@ func Test_pipelineEmptyDiff(t *testing.T) {
 	// connection.
 
 	_, out3, re3, wr3, cl3 := newConn(t)
-	attachConn(pl, re3, wr3, cl3)
+	attachConn(pl, re3, wr3, cl3, capabilities{}, "")
 	// Handle the GoL state initialization message
 	recv(t, out3)
 
@@ -170,6 +172,718 @@ func Test_pipelineEmptyDiff(t *testing.T) {
 	}
 }
 
+// Admin messages sent to gol should control the simulation.
+func Test_pipelineAdmin(t *testing.T) {
+	readPumpOut := make(chan interface{})
+	golChan := make(chan interface{})
+	pl := startPipelineInternal(defaultConfig(), readPumpOut, golChan)
+
+	in, out, re, wr, cl := newConn(t)
+	attachConn(pl, re, wr, cl, capabilities{messages: true}, "")
+	// Handle the GoL state initialization message
+	recv(t, out)
+
+	// A blinker, which oscillates forever.
+	blinker := "{\"20\":{\"20\":\"#aaaaaa\",\"21\":\"#aaaaaa\",\"22\":\"#aaaaaa\"}}"
+	send(t, in, []byte(blinker))
+	fwd(t, golChan, readPumpOut)
+
+	// While paused, ticks should be ignored, but steps should advance the game.
+	send[interface{}](t, golChan, &pause{})
+	send[interface{}](t, golChan, &tick{})
+	send[interface{}](t, golChan, &step{})
+	json := string(recv(t, out))
+	if json != blinker {
+		t.Errorf("Got incorrect JSON: %v", json)
+	}
+	select {
+	case m := <-out:
+		t.Errorf("Unexpected message while paused: %s", m)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// After resuming, ticks should advance the game.
+	send[interface{}](t, golChan, &resume{})
+	send[interface{}](t, golChan, &tick{})
+	json = string(recv(t, out))
+	expected := "{\"19\":{\"21\":\"#aaaaaa\"},\"20\":{\"20\":\"\",\"22\":\"\"},\"21\":{\"21\":\"#aaaaaa\"}}"
+	if json != expected {
+		t.Errorf("Got incorrect JSON: %v", json)
+	}
+
+	// Clearing the grid should kill every live cell in the next generation,
+	// and discard pending births.
+	send[interface{}](t, golChan, &clearGrid{})
+	send[interface{}](t, golChan, &tick{})
+	json = string(recv(t, out))
+	expected = "{\"19\":{\"21\":\"\"},\"20\":{\"21\":\"\"},\"21\":{\"21\":\"\"}}"
+	if json != expected {
+		t.Errorf("Got incorrect JSON: %v", json)
+	}
+	send[interface{}](t, golChan, &tick{})
+	json = string(recv(t, out))
+	if json != "{}" {
+		t.Errorf("Got incorrect JSON: %v", json)
+	}
+
+	// A notice should be broadcast.
+	send[interface{}](t, golChan, &notice{"hello"})
+	json = string(recv(t, out))
+	if json != "{\"type\":\"notice\",\"text\":\"hello\"}" {
+		t.Errorf("Got incorrect JSON: %v", json)
+	}
+
+	// A new tick interval should be forwarded to clock.
+	send[interface{}](t, golChan, &setTickInterval{time.Second})
+	if d := recv(t, pl.clockChan); d != time.Second {
+		t.Errorf("Expected interval %v but got %v", time.Second, d)
+	}
+}
+
+// When a cleanup policy is configured, the pipeline should remove debris once
+// it has been unchanged for long enough, even after the stream has ended.
+func Test_pipelineCleanup(t *testing.T) {
+	readPumpOut := make(chan interface{})
+	golChan := make(chan interface{})
+	cfg := defaultConfig()
+	cfg.cleanup = cleanupPolicy{after: 2}
+	pl := startPipelineInternal(cfg, readPumpOut, golChan)
+
+	in, out, re, wr, cl := newConn(t)
+	attachConn(pl, re, wr, cl, capabilities{}, "")
+	// Handle the GoL state initialization message
+	recv(t, out)
+
+	block := "{\"10\":{\"10\":\"#aaaaaa\",\"11\":\"#aaaaaa\"},\"11\":{\"10\":\"#aaaaaa\",\"11\":\"#aaaaaa\"}}"
+	send(t, in, []byte(block))
+	fwd(t, golChan, readPumpOut)
+	send[interface{}](t, golChan, &tick{})
+	recv(t, out)
+	send[interface{}](t, golChan, &tick{})
+	json := string(recv(t, out))
+	if json != "{}" {
+		t.Errorf("Got incorrect JSON: %v", json)
+	}
+	// The block has now been unchanged for two generations, so it is removed
+	// in the next generation.
+	send[interface{}](t, golChan, &tick{})
+	send[interface{}](t, golChan, &tick{})
+
+	expected := "{\"10\":{\"10\":\"\",\"11\":\"\"},\"11\":{\"10\":\"\",\"11\":\"\"}}"
+	json = string(recv(t, out))
+	if json != expected {
+		t.Errorf("Got incorrect JSON: %v", json)
+	}
+}
+
+// Connections with the ages capability should receive each live cell along
+// with its age, while other connections receive the original format.
+func Test_pipelineAges(t *testing.T) {
+	readPumpOut := make(chan interface{})
+	golChan := make(chan interface{})
+	pl := startPipelineInternal(defaultConfig(), readPumpOut, golChan)
+
+	in1, out1, re1, wr1, cl1 := newConn(t)
+	_, out2, re2, wr2, cl2 := newConn(t)
+	attachConn(pl, re1, wr1, cl1, capabilities{}, "")
+	attachConn(pl, re2, wr2, cl2, capabilities{ages: true}, "")
+	recv(t, out1)
+	recv(t, out2)
+
+	block := "{\"10\":{\"10\":\"#aaaaaa\",\"11\":\"#aaaaaa\"},\"11\":{\"10\":\"#aaaaaa\",\"11\":\"#aaaaaa\"}}"
+	agedBlock := "{\"10\":{\"10\":[\"#aaaaaa\",0],\"11\":[\"#aaaaaa\",0]},\"11\":{\"10\":[\"#aaaaaa\",0],\"11\":[\"#aaaaaa\",0]}}"
+	send(t, in1, []byte(block))
+	fwd(t, golChan, readPumpOut)
+	send[interface{}](t, golChan, &tick{})
+
+	json := string(recv(t, out1))
+	if json != block {
+		t.Errorf("Got incorrect JSON: %v", json)
+	}
+	json = string(recv(t, out2))
+	if json != agedBlock {
+		t.Errorf("Got incorrect JSON: %v", json)
+	}
+
+	// A new connection with the ages capability should receive the ages of
+	// the live cells in the grid.
+	_, out3, re3, wr3, cl3 := newConn(t)
+	attachConn(pl, re3, wr3, cl3, capabilities{ages: true}, "")
+	json = string(recv(t, out3))
+	if !strings.Contains(json, "[\"#aaaaaa\",0]") {
+		t.Errorf("Got incorrect JSON: %v", json)
+	}
+}
+
+// Connections with the world capability should receive a description of the
+// world immediately after the grid.
+func Test_pipelineWorld(t *testing.T) {
+	readPumpOut := make(chan interface{})
+	golChan := make(chan interface{})
+	cfg := defaultConfig()
+	cfg.neighborhood = newNeighborhood(hexagonal, 1)
+	cfg.rule, _ = parseRule("B2/S34", 6)
+	pl := startPipelineInternal(cfg, readPumpOut, golChan)
+
+	_, out, re, wr, cl := newConn(t)
+	attachConn(pl, re, wr, cl, capabilities{world: true}, "")
+
+	json := string(recv(t, out))
+	if !strings.HasPrefix(json, "[") {
+		t.Errorf("Got incorrect JSON: %v", json)
+	}
+	json = string(recv(t, out))
+	world := "{\"type\":\"world\",\"width\":120,\"height\":120,\"topology\":\"torus\",\"rule\":\"B2/S34\",\"neighborhood\":\"hex\",\"radius\":1,\"hexagonal\":true}"
+	if json != world {
+		t.Errorf("Got incorrect JSON: %v", json)
+	}
+}
+
+// Version 2 connections should receive every message as a typed message,
+// while version 1 connections on the same pipeline receive bare grids and
+// diffs.
+func Test_pipelineProtocolV2(t *testing.T) {
+	readPumpOut := make(chan interface{})
+	golChan := make(chan interface{})
+	pl := startPipelineInternal(defaultConfig(), readPumpOut, golChan)
+
+	_, out1, re1, wr1, cl1 := newConn(t)
+	in2, out2, re2, wr2, cl2 := newConn(t)
+	attachConn(pl, re1, wr1, cl1, capabilities{}, "")
+	attachConn(pl, re2, wr2, cl2, capabilities{ants: true, version: protocolV2}, "")
+	recv(t, out1)
+	json := string(recv(t, out2))
+	if !strings.HasPrefix(json, "{\"type\":\"init\",\"grid\":[[") ||
+		!strings.HasSuffix(json, "\"world\":{\"width\":120,\"height\":120,\"topology\":\"torus\",\"rule\":\"B3/S23\",\"neighborhood\":\"moore\",\"radius\":1,\"hexagonal\":false}}") {
+		t.Errorf("Got incorrect JSON: %v", json)
+	}
+
+	send(t, in2, []byte("{\"type\":\"diff\",\"cells\":{\"10\":{\"20\":\"#aaaaaa\"}}}"))
+	fwd(t, golChan, readPumpOut)
+	send[interface{}](t, golChan, &tick{})
+	if json := string(recv(t, out1)); json != "{\"10\":{\"20\":\"#aaaaaa\"}}" {
+		t.Errorf("Got incorrect JSON: %v", json)
+	}
+	if json := string(recv(t, out2)); json != "{\"type\":\"diff\",\"cells\":{\"10\":{\"20\":\"#aaaaaa\"}},\"ants\":[]}" {
+		t.Errorf("Got incorrect JSON: %v", json)
+	}
+
+	// The lone cell dies, and then the stream ends.
+	send[interface{}](t, golChan, &tick{})
+	recv(t, out1)
+	recv(t, out2)
+	send[interface{}](t, golChan, &tick{})
+	if json := string(recv(t, out1)); json != "{}" {
+		t.Errorf("Got incorrect JSON: %v", json)
+	}
+	if json := string(recv(t, out2)); json != "{\"type\":\"streamEnd\"}" {
+		t.Errorf("Got incorrect JSON: %v", json)
+	}
+
+	// A new version 2 connection should be told that the stream has ended.
+	_, out3, re3, wr3, cl3 := newConn(t)
+	attachConn(pl, re3, wr3, cl3, capabilities{version: protocolV2}, "")
+	recv(t, out3)
+	if json := string(recv(t, out3)); json != "{\"type\":\"streamEnd\"}" {
+		t.Errorf("Got incorrect JSON: %v", json)
+	}
+}
+
+// A version 2 connection that sends an invalid message should be sent an
+// error message before the connection is closed. Untyped messages are invalid
+// in version 2.
+func Test_protocolV2Error(t *testing.T) {
+	golChan := make(chan interface{})
+	pl := startPipelineInternal(defaultConfig(), golChan, golChan)
+	in := make(chan []byte)
+	out := make(chan []byte)
+	closed := make(chan struct{})
+	attachConn(
+		pl,
+		newReadPayloadFn(in, closed),
+		func(messageType int, data []byte) error {
+			out <- data
+			return nil
+		},
+		newCloseFn(closed),
+		capabilities{version: protocolV2},
+		"",
+	)
+	recv(t, out)
+
+	send(t, in, []byte("{\"10\":{\"20\":\"#aaaaaa\"}}"))
+	if json := string(recv(t, out)); json != "{\"type\":\"error\",\"text\":\"message has no type\"}" {
+		t.Errorf("Got incorrect JSON: %v", json)
+	}
+	// The close message.
+	recv(t, out)
+	recv(t, closed)
+}
+
+// A connection that subscribes to a region should receive only the cells in
+// that region, along with the cells that enter the region when it changes.
+func Test_pipelineSubscribe(t *testing.T) {
+	readPumpOut := make(chan interface{})
+	golChan := make(chan interface{})
+	pl := startPipelineInternal(defaultConfig(), readPumpOut, golChan)
+
+	in1, out1, re1, wr1, cl1 := newConn(t)
+	in2, out2, re2, wr2, cl2 := newConn(t)
+	attachConn(pl, re1, wr1, cl1, capabilities{}, "")
+	attachConn(pl, re2, wr2, cl2, capabilities{version: protocolV2}, "")
+	recv(t, out1)
+	recv(t, out2)
+
+	// Nothing enters a region of the grid that the client already has.
+	send(t, in2, []byte("{\"type\":\"subscribe\",\"viewport\":{\"x\":0,\"y\":0,\"width\":10,\"height\":10}}"))
+	fwd(t, golChan, readPumpOut)
+	if json := string(recv(t, out2)); json != "{\"type\":\"subscribed\",\"viewport\":{\"x\":0,\"y\":0,\"width\":10,\"height\":10},\"cells\":{}}" {
+		t.Errorf("Got incorrect JSON: %v", json)
+	}
+
+	// A block inside the region and a blinker outside it.
+	send(t, in1, []byte("{\"1\":{\"1\":\"#aaaaaa\",\"2\":\"#aaaaaa\"},\"2\":{\"1\":\"#aaaaaa\",\"2\":\"#aaaaaa\"},\"50\":{\"50\":\"#aaaaaa\",\"51\":\"#aaaaaa\",\"52\":\"#aaaaaa\"}}"))
+	fwd(t, golChan, readPumpOut)
+	send[interface{}](t, golChan, &tick{})
+	recv(t, out1)
+	if json := string(recv(t, out2)); json != "{\"type\":\"diff\",\"cells\":{\"1\":{\"1\":\"#aaaaaa\",\"2\":\"#aaaaaa\"},\"2\":{\"1\":\"#aaaaaa\",\"2\":\"#aaaaaa\"}}}" {
+		t.Errorf("Got incorrect JSON: %v", json)
+	}
+
+	// Moving the region over the blinker sends the blinker's cells.
+	send(t, in2, []byte("{\"type\":\"subscribe\",\"viewport\":{\"x\":50,\"y\":50,\"width\":3,\"height\":1}}"))
+	fwd(t, golChan, readPumpOut)
+	if json := string(recv(t, out2)); json != "{\"type\":\"subscribed\",\"viewport\":{\"x\":50,\"y\":50,\"width\":3,\"height\":1},\"cells\":{\"50\":{\"50\":\"#aaaaaa\",\"51\":\"#aaaaaa\",\"52\":\"#aaaaaa\"}}}" {
+		t.Errorf("Got incorrect JSON: %v", json)
+	}
+
+	// The blinker turns vertical, so only its center stays in the region.
+	send[interface{}](t, golChan, &tick{})
+	recv(t, out1)
+	if json := string(recv(t, out2)); json != "{\"type\":\"diff\",\"cells\":{\"50\":{\"50\":\"\",\"52\":\"\"}}}" {
+		t.Errorf("Got incorrect JSON: %v", json)
+	}
+}
+
+func Test_subscribeV1(t *testing.T) {
+	invalidMessageTestTemplate(t, []byte("{\"type\":\"subscribe\"}"))
+}
+
+// A spectator should receive the grid and diffs, but any message that it sends
+// should close the connection with an error rather than reach gol.
+func Test_spectator(t *testing.T) {
+	readPumpOut := make(chan interface{})
+	golChan := make(chan interface{})
+	pl := startPipelineInternal(defaultConfig(), readPumpOut, golChan)
+
+	in1, out1, re1, wr1, cl1 := newConn(t)
+	attachConn(pl, re1, wr1, cl1, capabilities{}, "")
+	recv(t, out1)
+	in2 := make(chan []byte)
+	out2 := make(chan []byte)
+	closed := make(chan struct{})
+	attachConn(
+		pl,
+		newReadPayloadFn(in2, closed),
+		func(messageType int, data []byte) error {
+			out2 <- data
+			return nil
+		},
+		newCloseFn(closed),
+		capabilities{version: protocolV2, spectator: true},
+		"",
+	)
+	if json := string(recv(t, out2)); !strings.HasPrefix(json, "{\"type\":\"init\"") {
+		t.Errorf("Got incorrect JSON: %v", json)
+	}
+
+	send(t, in1, []byte("{\"10\":{\"20\":\"#aaaaaa\"}}"))
+	fwd(t, golChan, readPumpOut)
+	send[interface{}](t, golChan, &tick{})
+	recv(t, out1)
+	if json := string(recv(t, out2)); json != "{\"type\":\"diff\",\"cells\":{\"10\":{\"20\":\"#aaaaaa\"}}}" {
+		t.Errorf("Got incorrect JSON: %v", json)
+	}
+
+	send(t, in2, []byte("{\"type\":\"diff\",\"cells\":{\"10\":{\"21\":\"#aaaaaa\"}}}"))
+	if json := string(recv(t, out2)); json != "{\"type\":\"error\",\"text\":\"spectators may not send messages\"}" {
+		t.Errorf("Got incorrect JSON: %v", json)
+	}
+	// The close message.
+	recv(t, out2)
+	recv(t, closed)
+	select {
+	case m := <-readPumpOut:
+		t.Errorf("Expected nothing to be sent to gol but got %v", m)
+	default:
+	}
+}
+
+// On the plane, connections should receive only the cells in the chunks that
+// they subscribe to, wherever those chunks are.
+func Test_pipelinePlane(t *testing.T) {
+	readPumpOut := make(chan interface{})
+	golChan := make(chan interface{})
+	cfg := defaultConfig()
+	cfg.topology = plane
+	pl := startPipelineInternal(cfg, readPumpOut, golChan)
+
+	in1, out1, re1, wr1, cl1 := newConn(t)
+	_, out2, re2, wr2, cl2 := newConn(t)
+	attachConn(pl, re1, wr1, cl1, capabilities{version: protocolV2}, "")
+	attachConn(pl, re2, wr2, cl2, capabilities{version: protocolV2}, "")
+	json := string(recv(t, out1))
+	if json != "{\"type\":\"init\",\"generation\":0,\"world\":{\"width\":0,\"height\":0,\"chunkSize\":64,\"topology\":\"plane\",\"rule\":\"B3/S23\",\"neighborhood\":\"moore\",\"radius\":1,\"hexagonal\":false}}" {
+		t.Errorf("Got incorrect JSON: %v", json)
+	}
+	recv(t, out2)
+
+	send(t, in1, []byte("{\"type\":\"subscribe\",\"viewport\":{\"x\":1099511627776,\"y\":-10,\"width\":20,\"height\":10}}"))
+	fwd(t, golChan, readPumpOut)
+	json = string(recv(t, out1))
+	if json != "{\"type\":\"subscribed\",\"viewport\":{\"x\":1099511627776,\"y\":-64,\"width\":128,\"height\":64},\"cells\":{}}" {
+		t.Errorf("Got incorrect JSON: %v", json)
+	}
+
+	// A block in the subscribed chunks and a block at the origin.
+	send(t, in1, []byte("{\"type\":\"diff\",\"cells\":{\"1099511627776\":{\"-1\":\"#aaaaaa\",\"0\":\"#aaaaaa\"},\"1099511627777\":{\"-1\":\"#aaaaaa\",\"0\":\"#aaaaaa\"},\"0\":{\"0\":\"#aaaaaa\",\"1\":\"#aaaaaa\"},\"1\":{\"0\":\"#aaaaaa\",\"1\":\"#aaaaaa\"}}}"))
+	fwd(t, golChan, readPumpOut)
+	send[interface{}](t, golChan, &tick{})
+	json = string(recv(t, out1))
+	if json != "{\"type\":\"diff\",\"cells\":{\"1099511627776\":{\"-1\":\"#aaaaaa\",\"0\":\"#aaaaaa\"},\"1099511627777\":{\"-1\":\"#aaaaaa\",\"0\":\"#aaaaaa\"}}}" {
+		t.Errorf("Got incorrect JSON: %v", json)
+	}
+	if json := string(recv(t, out2)); json != "{\"type\":\"diff\",\"cells\":{}}" {
+		t.Errorf("Got incorrect JSON: %v", json)
+	}
+
+	// The blocks are still lifes, so the stream ends.
+	send[interface{}](t, golChan, &tick{})
+	for _, out := range []chan []byte{out1, out2} {
+		if json := string(recv(t, out)); json != "{\"type\":\"streamEnd\"}" {
+			t.Errorf("Got incorrect JSON: %v", json)
+		}
+	}
+
+	// gol's copy of the grid is the part of the plane at the origin.
+	g := pl.currentGrid()
+	if g[0][0] != "#aaaaaa" || g[1][1] != "#aaaaaa" {
+		t.Errorf("Expected the grid to hold the block at the origin")
+	}
+
+	// The leaderboard counts cells wherever they are.
+	expected := []speciesCount{{"#aaaaaa", 8}}
+	if lb := pl.currentLeaderboard(); !reflect.DeepEqual(lb.Species, expected) {
+		t.Errorf("Expected leaderboard %v but got %v", expected, lb.Species)
+	}
+
+	// A resumed connection has yet to subscribe, so it is sent the init
+	// message.
+	_, out3, re3, wr3, cl3 := newConn(t)
+	resumeConn(pl, re3, wr3, cl3, capabilities{version: protocolV2}, "", 0)
+	if json := string(recv(t, out3)); !strings.HasPrefix(json, "{\"type\":\"init\",\"generation\":1,") {
+		t.Errorf("Got incorrect JSON: %v", json)
+	}
+	if json := string(recv(t, out3)); json != "{\"type\":\"streamEnd\"}" {
+		t.Errorf("Got incorrect JSON: %v", json)
+	}
+}
+
+// Cells should be attributed to the player whose connection sent them, and a
+// stats message should be answered with the statistics for every player.
+func Test_pipelinePlayerStats(t *testing.T) {
+	readPumpOut := make(chan interface{})
+	golChan := make(chan interface{})
+	pl := startPipelineInternal(defaultConfig(), readPumpOut, golChan)
+
+	in, out, re, wr, cl := newConn(t)
+	attachConn(pl, re, wr, cl, capabilities{}, "alice")
+	recv(t, out)
+
+	send(t, in, []byte("{\"10\":{\"10\":\"#aaaaaa\",\"11\":\"#aaaaaa\",\"12\":\"#aaaaaa\"}}"))
+	fwd(t, golChan, readPumpOut)
+	send[interface{}](t, golChan, &tick{})
+	recv(t, out)
+
+	send(t, in, []byte("{\"type\":\"stats\"}"))
+	fwd(t, golChan, readPumpOut)
+	json := string(recv(t, out))
+	if json != "{\"type\":\"stats\",\"you\":\"alice\",\"players\":[{\"id\":\"alice\",\"placed\":3,\"alive\":3}]}" {
+		t.Errorf("Got incorrect JSON: %v", json)
+	}
+
+	stats := pl.currentPlayerStats()
+	if len(stats) != 1 || stats[0] != (playerStats{"alice", 3, 3}) {
+		t.Errorf("Got incorrect stats: %+v", stats)
+	}
+}
+
+// Typed messages that a client didn't ask for should only be sent to version
+// 2 clients and to version 1 clients with the messages capability.
+func Test_pipelineTypedMessagesOptIn(t *testing.T) {
+	readPumpOut := make(chan interface{})
+	golChan := make(chan interface{})
+	pl := startPipelineInternal(defaultConfig(), readPumpOut, golChan)
+
+	inOld, outOld, reOld, wrOld, clOld := newConn(t)
+	attachConn(pl, reOld, wrOld, clOld, capabilities{}, "alice")
+	recv(t, outOld)
+	_, outOptIn, reOptIn, wrOptIn, clOptIn := newConn(t)
+	attachConn(pl, reOptIn, wrOptIn, clOptIn, capabilities{messages: true}, "bob")
+	recv(t, outOptIn)
+	_, outV2, reV2, wrV2, clV2 := newConn(t)
+	attachConn(pl, reV2, wrV2, clV2, capabilities{version: protocolV2}, "carol")
+	recv(t, outV2)
+
+	send[interface{}](t, golChan, &notice{"hello"})
+	for _, out := range []chan []byte{outOptIn, outV2} {
+		if json := string(recv(t, out)); json != "{\"type\":\"notice\",\"text\":\"hello\"}" {
+			t.Errorf("Got incorrect JSON: %v", json)
+		}
+	}
+	send(t, inOld, []byte("{\"type\":\"chat\",\"text\":\"hi\"}"))
+	for _, out := range []chan []byte{outOptIn, outV2} {
+		if json := string(recv(t, out)); !strings.HasPrefix(json, "{\"type\":\"chat\"") {
+			t.Errorf("Got incorrect JSON: %v", json)
+		}
+	}
+	select {
+	case m := <-outOld:
+		t.Errorf("Unexpected message for a version 1 client: %s", m)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// Requests for statistics beyond the rate limit should be answered with a
+// notice rather than reaching gol.
+func Test_pipelineStatsRateLimit(t *testing.T) {
+	readPumpOut := make(chan interface{})
+	golChan := make(chan interface{})
+	pl := startPipelineInternal(defaultConfig(), readPumpOut, golChan)
+
+	in, out, re, wr, cl := newConn(t)
+	attachConn(pl, re, wr, cl, capabilities{}, "alice")
+	recv(t, out)
+
+	for i := 0; i < statsBurst; i++ {
+		send(t, in, []byte("{\"type\":\"stats\"}"))
+		fwd(t, golChan, readPumpOut)
+		recv(t, out)
+	}
+	send(t, in, []byte("{\"type\":\"stats\"}"))
+	if json := string(recv(t, out)); json != "{\"type\":\"notice\",\"text\":\"You're asking for statistics too quickly.\"}" {
+		t.Errorf("Got incorrect JSON: %v", json)
+	}
+}
+
+// The pipeline should broadcast the leaderboard every leaderboardInterval
+// generations, and record it in the history.
+func Test_pipelineLeaderboard(t *testing.T) {
+	readPumpOut := make(chan interface{})
+	golChan := make(chan interface{})
+	cfg := defaultConfig()
+	cfg.leaderboardInterval = 2
+	pl := startPipelineInternal(cfg, readPumpOut, golChan)
+
+	in, out, re, wr, cl := newConn(t)
+	attachConn(pl, re, wr, cl, capabilities{messages: true}, "")
+	recv(t, out)
+
+	block := "{\"10\":{\"10\":\"#aaaaaa\",\"11\":\"#aaaaaa\"},\"11\":{\"10\":\"#aaaaaa\",\"11\":\"#aaaaaa\"}}"
+	send(t, in, []byte(block))
+	fwd(t, golChan, readPumpOut)
+	send[interface{}](t, golChan, &tick{})
+	recv(t, out)
+	send[interface{}](t, golChan, &tick{})
+	// The block is a still life, so the stream ends.
+	if json := string(recv(t, out)); json != "{}" {
+		t.Errorf("Got incorrect JSON: %v", json)
+	}
+	json := string(recv(t, out))
+	if json != "{\"type\":\"leaderboard\",\"species\":[{\"species\":\"#aaaaaa\",\"cells\":4}]}" {
+		t.Errorf("Got incorrect JSON: %v", json)
+	}
+
+	report := pl.currentLeaderboard()
+	if len(report.Species) != 1 || report.Species[0] != (speciesCount{"#aaaaaa", 4}) {
+		t.Errorf("Got incorrect leaderboard: %+v", report.Species)
+	}
+	if len(report.History) != 1 {
+		t.Errorf("Expected 1 sample but got %v", len(report.History))
+	}
+}
+
+// Chat messages should be broadcast to every connection without going
+// through gol, and new connections should receive recent chat messages after
+// the grid.
+func Test_pipelineChat(t *testing.T) {
+	readPumpOut := make(chan interface{})
+	golChan := make(chan interface{})
+	pl := startPipelineInternal(defaultConfig(), readPumpOut, golChan)
+
+	in1, out1, re1, wr1, cl1 := newConn(t)
+	_, out2, re2, wr2, cl2 := newConn(t)
+	attachConn(pl, re1, wr1, cl1, capabilities{messages: true}, "alice")
+	attachConn(pl, re2, wr2, cl2, capabilities{messages: true}, "bob")
+	recv(t, out1)
+	recv(t, out2)
+
+	// No fwd is needed, since chat doesn't go through readPumpOut.
+	send(t, in1, []byte("{\"type\":\"chat\",\"text\":\"hi\"}"))
+	for _, out := range []chan []byte{out1, out2} {
+		json := string(recv(t, out))
+		if !strings.HasPrefix(json, "{\"type\":\"chat\",\"player\":\"alice\",\"text\":\"hi\"") {
+			t.Errorf("Got incorrect JSON: %v", json)
+		}
+	}
+
+	_, out3, re3, wr3, cl3 := newConn(t)
+	attachConn(pl, re3, wr3, cl3, capabilities{messages: true}, "carol")
+	if json := string(recv(t, out3)); !strings.HasPrefix(json, "[") {
+		t.Errorf("Got incorrect JSON: %v", json)
+	}
+	if json := string(recv(t, out3)); !strings.Contains(json, "\"text\":\"hi\"") {
+		t.Errorf("Got incorrect JSON: %v", json)
+	}
+
+	// Sending too many chat messages in quick succession should get a notice
+	// rather than a broadcast.
+	for i := 1; i < chatBurst; i++ {
+		send(t, in1, []byte("{\"type\":\"chat\",\"text\":\"spam\"}"))
+		recv(t, out1)
+		recv(t, out2)
+		recv(t, out3)
+	}
+	send(t, in1, []byte("{\"type\":\"chat\",\"text\":\"spam\"}"))
+	if json := string(recv(t, out1)); !strings.HasPrefix(json, "{\"type\":\"notice\"") {
+		t.Errorf("Got incorrect JSON: %v", json)
+	}
+}
+
+func Test_chatTooLong(t *testing.T) {
+	invalidMessageTestTemplate(t, []byte("{\"type\":\"chat\",\"text\":\""+strings.Repeat("a", maxChatLen+1)+"\"}"))
+}
+
+// Presence updates should be collected by hub and sent to every connection on
+// the next presenceTick, and to new connections once they're initialized.
+func Test_pipelinePresence(t *testing.T) {
+	readPumpOut := make(chan interface{})
+	golChan := make(chan interface{})
+	pl := startPipelineInternal(defaultConfig(), readPumpOut, golChan)
+
+	in1, out1, re1, wr1, cl1 := newConn(t)
+	_, out2, re2, wr2, cl2 := newConn(t)
+	attachConn(pl, re1, wr1, cl1, capabilities{messages: true}, "alice")
+	attachConn(pl, re2, wr2, cl2, capabilities{messages: true}, "bob")
+	recv(t, out1)
+	recv(t, out2)
+	// Once gol has answered another message, it has sent hub the initialized
+	// messages, so neither connection is sent the presence update on being
+	// initialized.
+	pl.currentGrid()
+
+	send(t, in1, []byte("{\"type\":\"presence\",\"species\":\"#aaaaaa\",\"cursor\":{\"x\":3,\"y\":4},\"viewport\":{\"x\":0,\"y\":0,\"width\":40,\"height\":30}}"))
+	// readPump handles messages in order, so once the chat message has been
+	// relayed, hub has the presence update.
+	send(t, in1, []byte("{\"type\":\"chat\",\"text\":\"hi\"}"))
+	recv(t, out1)
+	recv(t, out2)
+
+	expected := "{\"type\":\"presence\",\"players\":[{\"player\":\"alice\",\"species\":\"#aaaaaa\",\"cursor\":{\"x\":3,\"y\":4},\"viewport\":{\"x\":0,\"y\":0,\"width\":40,\"height\":30}}]}"
+	send[interface{}](t, pl.hubChan, &presenceTick{})
+	for _, out := range []chan []byte{out1, out2} {
+		if json := string(recv(t, out)); json != expected {
+			t.Errorf("Got incorrect JSON: %v", json)
+		}
+	}
+
+	// Nothing has changed, so the next presenceTick shouldn't send anything.
+	send[interface{}](t, pl.hubChan, &presenceTick{})
+	send(t, in1, []byte("{\"type\":\"chat\",\"text\":\"still here\"}"))
+	if json := string(recv(t, out2)); !strings.HasPrefix(json, "{\"type\":\"chat\"") {
+		t.Errorf("Got incorrect JSON: %v", json)
+	}
+	recv(t, out1)
+
+	_, out3, re3, wr3, cl3 := newConn(t)
+	attachConn(pl, re3, wr3, cl3, capabilities{messages: true}, "carol")
+	recv(t, out3)
+	recv(t, out3)
+	recv(t, out3)
+	if json := string(recv(t, out3)); json != expected {
+		t.Errorf("Got incorrect JSON: %v", json)
+	}
+}
+
+func Test_presenceOutsideGrid(t *testing.T) {
+	invalidMessageTestTemplate(t, []byte("{\"type\":\"presence\",\"species\":\"#aaaaaa\",\"cursor\":{\"x\":-1,\"y\":0}}"))
+}
+
+// When an ant is spawned and ticks occur, the pipeline should send the cells
+// that the ant flips. Connections with the ants capability should also
+// receive the ant's position.
+func Test_pipelineAnts(t *testing.T) {
+	readPumpOut := make(chan interface{})
+	golChan := make(chan interface{})
+	pl := startPipelineInternal(defaultConfig(), readPumpOut, golChan)
+
+	in1, out1, re1, wr1, cl1 := newConn(t)
+	_, out2, re2, wr2, cl2 := newConn(t)
+	attachConn(pl, re1, wr1, cl1, capabilities{}, "")
+	attachConn(pl, re2, wr2, cl2, capabilities{ants: true}, "")
+	recv(t, out1)
+	recv(t, out2)
+
+	send(t, in1, []byte("{\"type\":\"ant\",\"x\":10,\"y\":20,\"dir\":0,\"species\":\"#aaaaaa\"}"))
+	fwd(t, golChan, readPumpOut)
+	send[interface{}](t, golChan, &tick{})
+
+	// The ant starts on a dead cell, so it fills the cell, turns right, and
+	// moves east.
+	json := string(recv(t, out1))
+	if json != "{\"10\":{\"20\":\"#aaaaaa\"}}" {
+		t.Errorf("Got incorrect JSON: %v", json)
+	}
+	json = string(recv(t, out2))
+	if json != "{\"10\":{\"20\":\"#aaaaaa\"},\"ants\":[{\"x\":10,\"y\":21,\"dir\":1,\"species\":\"#aaaaaa\"}]}" {
+		t.Errorf("Got incorrect JSON: %v", json)
+	}
+
+	// Clearing the grid removes the ant along with the cells.
+	send[interface{}](t, golChan, &clearGrid{})
+	send[interface{}](t, golChan, &tick{})
+	recv(t, out1)
+	json = string(recv(t, out2))
+	if json != "{\"10\":{\"20\":\"\"},\"ants\":[]}" {
+		t.Errorf("Got incorrect JSON: %v", json)
+	}
+}
+
+// When a stamp message comes in on a connection and then a tick occurs, the
+// pipeline should send the diff produced by stamping the pattern.
+func Test_pipelineStamp(t *testing.T) {
+	readPumpOut := make(chan interface{})
+	golChan := make(chan interface{})
+	pl := startPipelineInternal(defaultConfig(), readPumpOut, golChan)
+
+	in, out, re, wr, cl := newConn(t)
+	attachConn(pl, re, wr, cl, capabilities{}, "")
+	// Handle the GoL state initialization message
+	recv(t, out)
+
+	st := "{\"type\":\"stamp\",\"name\":\"glider\",\"x\":10,\"y\":20,\"rotate\":180,\"reflect\":false,\"species\":\"#aaaaaa\"}"
+	df := "{\"10\":{\"20\":\"#aaaaaa\",\"21\":\"#aaaaaa\",\"22\":\"#aaaaaa\"},\"11\":{\"20\":\"#aaaaaa\"},\"12\":{\"21\":\"#aaaaaa\"}}"
+
+	send(t, in, []byte(st))
+	fwd(t, golChan, readPumpOut)
+	send[interface{}](t, golChan, &tick{})
+
+	json := string(recv(t, out))
+	if json != df {
+		t.Errorf("Got incorrect JSON: %v", json)
+	}
+}
+
 // When invalid JSON comes in on a connection, a close message should be sent
 // on the connection and then the connection should be closed.
 func Test_invalidJSON(t *testing.T) {
@@ -246,11 +960,47 @@ func Test_invalidDiff10(t *testing.T) {
 	invalidMessageTestTemplate(t, []byte("{\"0\":{\"0\":\"\"}}"))
 }
 
+// When valid JSON that is not a valid Game of Life diff comes in on a
+// connection, a close message should be sent on the connection and then the
+// connection should be closed.
+func Test_invalidDiff11(t *testing.T) {
+	invalidMessageTestTemplate(t, []byte("{\"-1\":{\"0\":\"#aaaaaa\"}}"))
+}
+
+// When valid JSON that is not a valid Game of Life diff comes in on a
+// connection, a close message should be sent on the connection and then the
+// connection should be closed.
+func Test_invalidDiff12(t *testing.T) {
+	invalidMessageTestTemplate(t, []byte("{\"0\":{\"-1\":\"#aaaaaa\"}}"))
+}
+
+// When a message with an unknown type comes in on a connection, a close
+// message should be sent on the connection and then the connection should be
+// closed.
+func Test_dyingCellInDiff(t *testing.T) {
+	invalidMessageTestTemplate(t, []byte("{\"0\":{\"0\":\"#aaaaaa:2\"}}"))
+}
+
+func Test_invalidAnt(t *testing.T) {
+	invalidMessageTestTemplate(t, []byte("{\"type\":\"ant\",\"x\":10,\"y\":20,\"dir\":4,\"species\":\"#aaaaaa\"}"))
+}
+
+func Test_unknownMessageType(t *testing.T) {
+	invalidMessageTestTemplate(t, []byte("{\"type\":\"nonexistent\"}"))
+}
+
+// When a stamp message referring to an unknown pattern comes in on a
+// connection, a close message should be sent on the connection and then the
+// connection should be closed.
+func Test_invalidStamp(t *testing.T) {
+	invalidMessageTestTemplate(t, []byte("{\"type\":\"stamp\",\"name\":\"nonexistent\",\"x\":0,\"y\":0,\"species\":\"#aaaaaa\"}"))
+}
+
 // When reading from the connection returns an error that is not due to the
 // client closing the connection, a close message should be sent and then the
 // connection should be closed.
 func Test_errorReadingMessage(t *testing.T) {
-	pl := startPipeline()
+	pl := startPipeline(defaultConfig())
 	in := make(chan error)
 	out := make(chan int)
 	closed := make(chan struct{})
@@ -259,6 +1009,8 @@ func Test_errorReadingMessage(t *testing.T) {
 		newReadErrorFn(in, closed),
 		newWriteMessageTypeFn(out),
 		newCloseFn(closed),
+		capabilities{},
+		"",
 	)
 	// Handle the GoL state initialization message
 	recv(t, out)
@@ -272,7 +1024,7 @@ func Test_errorReadingMessage(t *testing.T) {
 // the connection, the connection should be closed and no close message should
 // be sent.
 func Test_errorReadingMessageClosedByClient(t *testing.T) {
-	pl := startPipeline()
+	pl := startPipeline(defaultConfig())
 	in := make(chan error)
 	out := make(chan struct{})
 	closed := make(chan struct{})
@@ -284,6 +1036,8 @@ func Test_errorReadingMessageClosedByClient(t *testing.T) {
 			return nil
 		},
 		newCloseFn(closed),
+		capabilities{},
+		"",
 	)
 	// Handle the GoL state initialization message
 	recv(t, out)
@@ -301,7 +1055,7 @@ func Test_errorReadingMessageClosedByClient(t *testing.T) {
 // When writing to the connection returns an error, the connection should be
 // closed.
 func Test_errorWritingMessage(t *testing.T) {
-	pl := startPipeline()
+	pl := startPipeline(defaultConfig())
 	closed := make(chan struct{})
 	attachConn(
 		pl,
@@ -310,6 +1064,8 @@ func Test_errorWritingMessage(t *testing.T) {
 			return errors.New("dummy error")
 		},
 		newCloseFn(closed),
+		capabilities{},
+		"",
 	)
 
 	// attachConn should have caused the GoL state initialization message to be
@@ -323,7 +1079,7 @@ func Test_errorWritingMessage(t *testing.T) {
 func Test_sendBufferOverflow(t *testing.T) {
 	readPumpOut := make(chan interface{})
 	modelChan := make(chan interface{})
-	pl := startPipelineInternal(readPumpOut, modelChan)
+	pl := startPipelineInternal(defaultConfig(), readPumpOut, modelChan)
 	in := make(chan []byte)
 	out := make(chan int)
 	closed := make(chan struct{})
@@ -332,6 +1088,8 @@ func Test_sendBufferOverflow(t *testing.T) {
 		newReadPayloadFn(in, closed),
 		newWriteMessageTypeFn(out),
 		newCloseFn(closed),
+		capabilities{},
+		"",
 	)
 
 	// This automaton runs forever, alternating between two states. This allows
@@ -342,7 +1100,7 @@ func Test_sendBufferOverflow(t *testing.T) {
 	// writePump should currently be blocked trying to write the GoL state
 	// initialization message to the connection, so no messages should be
 	// pulled out of the send buffer.
-	for i := 1; i <= sendBufferLen+1; i++ {
+	for i := 1; i <= defaultSendBufferLen+1; i++ {
 		send[interface{}](t, modelChan, &tick{})
 	}
 	// Wait for the buffer to overflow.
@@ -360,10 +1118,195 @@ func Test_sendBufferOverflow(t *testing.T) {
 	recv(t, closed)
 }
 
+// Under the coalesce policy, the diffs that don't fit in the send buffer
+// should be merged into one diff, which is sent once there is room.
+func Test_sendBufferCoalesce(t *testing.T) {
+	readPumpOut := make(chan interface{})
+	golChan := make(chan interface{})
+	cfg := defaultConfig()
+	cfg.sendBufferLen = 2
+	cfg.overflowPolicy = coalesce
+	pl := startPipelineInternal(cfg, readPumpOut, golChan)
+	in, out, writing, re, wr, cl := newSignalingConn(t)
+	attachConn(pl, re, wr, cl, capabilities{version: protocolV2}, "")
+	recv(t, writing)
+	recv(t, out)
+
+	// A blinker, which produces a diff on every tick.
+	send(t, in, []byte("{\"type\":\"diff\",\"cells\":{\"20\":{\"20\":\"#aaaaaa\",\"21\":\"#aaaaaa\",\"22\":\"#aaaaaa\"}}}"))
+	fwd(t, golChan, readPumpOut)
+	send[interface{}](t, golChan, &tick{})
+	// writePump is now blocked writing the first diff, so the next two diffs
+	// fill the buffer, and the three after that are coalesced.
+	recv(t, writing)
+	for i := 0; i < 5; i++ {
+		send[interface{}](t, golChan, &tick{})
+	}
+	// Once gol has received another message, it has sent hub the last diff,
+	// and once hub has too, it has handled that diff.
+	send[interface{}](t, golChan, &pause{})
+	send[interface{}](t, pl.hubChan, &presenceTick{})
+	for i := 0; i < 3; i++ {
+		if i > 0 {
+			recv(t, writing)
+		}
+		if json := string(recv(t, out)); strings.Contains(json, "generations") {
+			t.Errorf("Expected an ordinary diff but got %v", json)
+		}
+	}
+	// Any message to hub gives it a chance to send the backlog.
+	send[interface{}](t, pl.hubChan, &presenceTick{})
+	recv(t, writing)
+	json := string(recv(t, out))
+	if !strings.HasPrefix(json, "{\"type\":\"diff\",\"cells\":{") || !strings.HasSuffix(json, "},\"generations\":3}") {
+		t.Errorf("Got incorrect JSON: %v", json)
+	}
+}
+
+// Under the coalesce policy, diffs should be merged past typed messages, such
+// as presence snapshots, which aren't part of the stream of diffs.
+func Test_sendBufferCoalescePresence(t *testing.T) {
+	readPumpOut := make(chan interface{})
+	golChan := make(chan interface{})
+	cfg := defaultConfig()
+	cfg.sendBufferLen = 4
+	cfg.overflowPolicy = coalesce
+	pl := startPipelineInternal(cfg, readPumpOut, golChan)
+	in, out, writing, re, wr, cl := newSignalingConn(t)
+	attachConn(pl, re, wr, cl, capabilities{version: protocolV2}, "")
+	recv(t, writing)
+	recv(t, out)
+	// Another Listener whose presence changes between ticks.
+	other := &listener{make(chan *lazyMessage, 8), newErrorSignal(), capabilities{version: protocolV2}}
+	send[interface{}](t, pl.hubChan, &register{other})
+
+	send(t, in, []byte("{\"type\":\"diff\",\"cells\":{\"20\":{\"20\":\"#aaaaaa\",\"21\":\"#aaaaaa\",\"22\":\"#aaaaaa\"}}}"))
+	fwd(t, golChan, readPumpOut)
+	send[interface{}](t, golChan, &tick{})
+	// writePump is now blocked writing the first diff, so the next four
+	// diffs fill the buffer. The backlog holds the fifth diff, into which the
+	// last two are merged past the presence snapshots sent between them.
+	recv(t, writing)
+	for i := 0; i < 7; i++ {
+		send[interface{}](t, golChan, &tick{})
+		if i >= 4 {
+			// Once gol has received another message, it has sent hub the
+			// diff.
+			send[interface{}](t, golChan, &resume{})
+			send[interface{}](t, pl.hubChan, &presence{li: other, Player: "bob", Species: "#bbbbbb",
+				Cursor: &cursorJSON{int64(i), int64(i)}})
+			send[interface{}](t, pl.hubChan, &presenceTick{})
+		}
+	}
+	send[interface{}](t, golChan, &pause{})
+	send[interface{}](t, pl.hubChan, &presenceTick{})
+	for i := 0; i < 5; i++ {
+		if i > 0 {
+			recv(t, writing)
+		}
+		if json := string(recv(t, out)); strings.Contains(json, "generations") {
+			t.Errorf("Expected an ordinary diff but got %v", json)
+		}
+	}
+	send[interface{}](t, pl.hubChan, &presenceTick{})
+	recv(t, writing)
+	json := string(recv(t, out))
+	if !strings.HasPrefix(json, "{\"type\":\"diff\",\"cells\":{") || !strings.HasSuffix(json, "},\"generations\":3}") {
+		t.Errorf("Got incorrect JSON: %v", json)
+	}
+	for i := 0; i < 3; i++ {
+		recv(t, writing)
+		if json := string(recv(t, out)); !strings.HasPrefix(json, "{\"type\":\"presence\"") {
+			t.Errorf("Expected a presence snapshot but got %v", json)
+		}
+	}
+}
+
+// Under the coalesce policy, a Listener with the ages capability should be sent
+// a fresh grid rather than a coalesced diff, which can't give the ages of its
+// cells.
+func Test_sendBufferCoalesceAges(t *testing.T) {
+	readPumpOut := make(chan interface{})
+	golChan := make(chan interface{})
+	cfg := defaultConfig()
+	cfg.sendBufferLen = 2
+	cfg.overflowPolicy = coalesce
+	pl := startPipelineInternal(cfg, readPumpOut, golChan)
+	in, out, writing, re, wr, cl := newSignalingConn(t)
+	attachConn(pl, re, wr, cl, capabilities{ages: true}, "")
+	recv(t, writing)
+	recv(t, out)
+
+	send(t, in, []byte("{\"20\":{\"20\":\"#aaaaaa\",\"21\":\"#aaaaaa\",\"22\":\"#aaaaaa\"}}"))
+	fwd(t, golChan, readPumpOut)
+	send[interface{}](t, golChan, &tick{})
+	recv(t, writing)
+	for i := 0; i < 3; i++ {
+		send[interface{}](t, golChan, &tick{})
+	}
+	send[interface{}](t, golChan, &pause{})
+	send[interface{}](t, pl.hubChan, &presenceTick{})
+	send[interface{}](t, golChan, &resume{})
+	if json := string(recv(t, out)); !strings.HasPrefix(json, "{\"2") {
+		t.Errorf("Expected a diff but got %v", json)
+	}
+	recv(t, writing)
+	if json := string(recv(t, out)); !strings.HasPrefix(json, "[[") {
+		t.Errorf("Expected a fresh grid but got %v", json)
+	}
+}
+
+// Under the freshGrid policy, the messages in the send buffer should be
+// replaced with a fresh grid.
+func Test_sendBufferFreshGrid(t *testing.T) {
+	readPumpOut := make(chan interface{})
+	golChan := make(chan interface{})
+	cfg := defaultConfig()
+	cfg.sendBufferLen = 2
+	cfg.overflowPolicy = freshGrid
+	pl := startPipelineInternal(cfg, readPumpOut, golChan)
+	in, out, writing, re, wr, cl := newSignalingConn(t)
+	attachConn(pl, re, wr, cl, capabilities{}, "")
+	recv(t, writing)
+	recv(t, out)
+
+	send(t, in, []byte("{\"20\":{\"20\":\"#aaaaaa\",\"21\":\"#aaaaaa\",\"22\":\"#aaaaaa\"}}"))
+	fwd(t, golChan, readPumpOut)
+	send[interface{}](t, golChan, &tick{})
+	recv(t, writing)
+	// Two diffs fill the buffer, and the third overflows it.
+	for i := 0; i < 3; i++ {
+		send[interface{}](t, golChan, &tick{})
+	}
+	send[interface{}](t, golChan, &pause{})
+	send[interface{}](t, pl.hubChan, &presenceTick{})
+	send[interface{}](t, golChan, &resume{})
+	if json := string(recv(t, out)); !strings.HasPrefix(json, "{\"2") {
+		t.Errorf("Expected a diff but got %v", json)
+	}
+	recv(t, writing)
+	// The blinker has evolved three times, so it is vertical.
+	message := recv(t, out)
+	var g grid
+	if err := json.Unmarshal(message, &g); err != nil {
+		t.Fatal(err)
+	}
+	if g[19][21] != "#aaaaaa" || g[20][21] != "#aaaaaa" || g[21][21] != "#aaaaaa" || g[20][20] != "" {
+		t.Errorf("Expected the fresh grid to hold the blinker as of the latest generation")
+	}
+
+	// Diffs resume after the fresh grid.
+	send[interface{}](t, golChan, &tick{})
+	recv(t, writing)
+	if json := string(recv(t, out)); !strings.HasPrefix(json, "{\"") {
+		t.Errorf("Expected a diff but got %v", json)
+	}
+}
+
 // When an error occurs related to a connection and resources are cleaned up,
 // no goroutines should be leaked.
 func Test_leak(t *testing.T) {
-	pl := startPipeline()
+	pl := startPipeline(defaultConfig())
 	closed := make(chan struct{})
 	wg, errSig := attachConn(
 		pl,
@@ -372,6 +1315,8 @@ func Test_leak(t *testing.T) {
 			return nil
 		},
 		newCloseFn(closed),
+		capabilities{},
+		"",
 	)
 
 	errSig.send(errors.New("dummy error"))
@@ -396,11 +1341,24 @@ func newConn(t *testing.T) (in chan []byte, out chan []byte, re readFromConn, wr
 	return
 }
 
+// newSignalingConn is newConn, except that writing receives each message
+// before it is sent on out, so that tests can tell when writePump is blocked.
+func newSignalingConn(t *testing.T) (in chan []byte, out chan []byte, writing chan []byte, re readFromConn, wr writeToConn, cl closeConn) {
+	in, out, re, _, cl = newConn(t)
+	writing = make(chan []byte)
+	wr = func(messageType int, data []byte) error {
+		writing <- data
+		out <- data
+		return nil
+	}
+	return
+}
+
 // invalidMessageTestTemplate starts a pipeline with one connection, simulates
 // the message coming in on the connection, then verifies that a close message
 // was sent on the connection and the connection was closed.
 func invalidMessageTestTemplate(t *testing.T, message []byte) {
-	pl := startPipeline()
+	pl := startPipeline(defaultConfig())
 	in := make(chan []byte)
 	out := make(chan int)
 	closed := make(chan struct{})
@@ -409,6 +1367,8 @@ func invalidMessageTestTemplate(t *testing.T, message []byte) {
 		newReadPayloadFn(in, closed),
 		newWriteMessageTypeFn(out),
 		newCloseFn(closed),
+		capabilities{},
+		"",
 	)
 	// Handle the GoL state initialization message
 	recv(t, out)
@@ -492,3 +1452,32 @@ func send[U any](t *testing.T, ch chan<- U, v U) {
 func fwd[U any](t *testing.T, chIn chan<- U, chOut <-chan U) {
 	send(t, chIn, recv(t, chOut))
 }
+
+// A broadcast should be encoded only for the diff formats that are asked for,
+// and only once for each, however many Listeners write it.
+func Test_broadcastVariant(t *testing.T) {
+	var formats []capabilities
+	b := &broadcast{encode: func(caps capabilities) []byte {
+		formats = append(formats, caps)
+		return marshalStreamEnd(caps)
+	}}
+	listeners := []*listener{
+		{caps: capabilities{}},
+		{caps: capabilities{messages: true}},
+		{caps: capabilities{version: protocolV2}},
+		{caps: capabilities{version: protocolV2, spectator: true}},
+	}
+	for _, li := range listeners {
+		b.variant(li).bytes()
+	}
+	for _, li := range listeners {
+		b.variant(li).bytes()
+	}
+	expected := []capabilities{{}, {version: protocolV2}}
+	if !reflect.DeepEqual(formats, expected) {
+		t.Errorf("Expected formats %+v but got %+v", expected, formats)
+	}
+	if message := string(b.variant(listeners[2]).bytes()); message != "{\"type\":\"streamEnd\"}" {
+		t.Errorf("Got incorrect JSON: %v", message)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+)
+
+// mergePlaneDiff merges changes from a player into the next generation of the
+// plane.
+type mergePlaneDiff struct {
+	df     planeDiff
+	player playerID
+}
+
+// subscribeChunks is sent by a client on the plane to receive only the cells
+// in a range of chunks. A nil chunkRange unsubscribes from every chunk.
+type subscribeChunks struct {
+	li *listener
+	cr *chunkRange
+}
+
+// subscribedChunks carries a new subscription from gol to hub, along
+// with the cells in the subscribed chunks that aren't dead, from which hub
+// sends the cells in the chunks that are new to the subscription.
+type subscribedChunks struct {
+	li    *listener
+	cr    *chunkRange
+	cells planeDiff
+}
+
+// broadcastPlaneDiff broadcasts a diff of the plane. hub filters it for each
+// Listener according to its subscription.
+type broadcastPlaneDiff struct {
+	df planeDiff
+	// filtered holds the part of the diff in each range of chunks that a
+	// Listener has subscribed to, along with its message.
+	filtered map[chunkRange]*filteredPlaneDiff
+}
+
+// filteredPlaneDiff is the part of a diff of the plane sent to the Listeners
+// that have subscribed to a range of chunks.
+type filteredPlaneDiff struct {
+	df      planeDiff
+	message *lazyMessage
+}
+
+// variant returns the part of the diff in a range of chunks, and the message
+// to send to the Listeners that have subscribed to it.
+func (b *broadcastPlaneDiff) variant(cr chunkRange) (planeDiff, *lazyMessage) {
+	if f, ok := b.filtered[cr]; ok {
+		return f.df, f.message
+	}
+	if b.filtered == nil {
+		b.filtered = make(map[chunkRange]*filteredPlaneDiff)
+	}
+	df := filterPlaneDiff(b.df, cr)
+	m := newLazyMessage(func() []byte { return marshalPlaneDiff(df) })
+	b.filtered[cr] = &filteredPlaneDiff{df, m}
+	return df, m
+}
+
+// planeSubscribedMessage is the JSON representation of the cells sent to a
+// client on the plane when it subscribes. Viewport is the rectangle of cells
+// covered by the subscribed chunks, or nil if the client unsubscribed.
+type planeSubscribedMessage struct {
+	Type     string        `json:"type"`
+	Viewport *viewportJSON `json:"viewport"`
+	Cells    planeDiff     `json:"cells"`
+}
+
+// marshalPlaneDiff encodes a diff of the plane. Only version 2 clients can
+// connect to a server running on the plane.
+func marshalPlaneDiff(df planeDiff) []byte {
+	return marshalCoalescedPlaneDiff(df, 1)
+}
+
+// marshalCoalescedPlaneDiff is the counterpart of marshalCoalescedDiff for the
+// plane.
+func marshalCoalescedPlaneDiff(df planeDiff, generations int) []byte {
+	if generations == 1 {
+		// Leave generations out of ordinary diffs.
+		generations = 0
+	}
+	message, _ := json.Marshal(&diffMessage{"diff", df, nil, generations})
+	return message
+}
+
+// decodePlaneMessage is the counterpart of decodeMessage for the types of
+// message that differ on the plane, where coordinates are 64-bit. ok is false
+// if the message doesn't differ, and should be decoded by decodeMessage.
+func decodePlaneMessage(message []byte, msgType string, cfg *config, li *listener, player playerID) (m interface{}, ok bool, err error) {
+	switch msgType {
+	case "diff":
+		var d struct {
+			Cells planeDiff `json:"cells"`
+		}
+		if err := json.Unmarshal(message, &d); err != nil {
+			return nil, true, err
+		}
+		if err := validatePlaneDiff(d.Cells); err != nil {
+			return nil, true, err
+		}
+		return &mergePlaneDiff{d.Cells, player}, true, nil
+	case "stamp":
+		st := &stamp{}
+		if err := json.Unmarshal(message, st); err != nil {
+			return nil, true, err
+		}
+		if err := validateStamp(st, cfg.patterns, cfg.topology); err != nil {
+			return nil, true, err
+		}
+		df, err := expandPlaneStamp(st, cfg.patterns)
+		if err != nil {
+			return nil, true, err
+		}
+		return &mergePlaneDiff{df, player}, true, nil
+	case "subscribe":
+		var sub struct {
+			Viewport *viewportJSON `json:"viewport"`
+		}
+		if err := json.Unmarshal(message, &sub); err != nil {
+			return nil, true, err
+		}
+		if v := sub.Viewport; v != nil {
+			cr, err := coveringChunks(v.X, v.Y, v.Width, v.Height)
+			if err != nil {
+				return nil, true, err
+			}
+			return &subscribeChunks{li, &cr}, true, nil
+		}
+		return &subscribeChunks{li, nil}, true, nil
+	case "ant":
+		return nil, true, errors.New("ants are not supported on the plane")
+	case "stats":
+		return nil, true, errors.New("player statistics are not supported on the plane")
+	}
+	return nil, false, nil
+}
+
+// expandPlaneStamp is the counterpart of expandStamp for the plane. Rather than
+// wrapping around, a stamp that extends past the largest 64-bit coordinates
+// is rejected, as is one that spans too many chunks. See validatePlaneDiff.
+func expandPlaneStamp(st *stamp, lib patternLibrary) (planeDiff, error) {
+	p := lib[st.Name]
+	df := make(planeDiff)
+	for _, c := range p.cells {
+		t := transform(c.point, p.width, p.height, st.Rotate, st.Reflect)
+		if st.X > math.MaxInt64-int64(t.x) || st.Y > math.MaxInt64-int64(t.y) {
+			return nil, errors.New("stamp extends past the edge of the plane")
+		}
+		getOrMakePlaneYDiff(df, st.X+int64(t.x))[st.Y+int64(t.y)] = st.Species
+	}
+	if err := validatePlaneDiff(df); err != nil {
+		return nil, err
+	}
+	return df, nil
+}
+
+// windowDiff returns the part of a diff of the plane that is in the grid-sized
+// window at the origin, as a diff of the grid.
+func windowDiff(df planeDiff) diff {
+	w := make(diff)
+	for x, ydiff := range df {
+		if x < 0 || x >= gridDimX {
+			continue
+		}
+		for y, s := range ydiff {
+			if y >= 0 && y < gridDimY {
+				getOrMakeYDiff(w, int(x))[int(y)] = s
+			}
+		}
+	}
+	return w
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// playerID identifies a player across connections. The empty playerID stands
+// for changes that no player made, such as imports by the server's operator.
+// A player's ID is public, since it is shown to other players, so a player is
+// authenticated by the secret session token that their ID is derived from.
+// See sessionPlayerID.
+type playerID string
+
+// sessionCookie is the name of the cookie that holds a browser's session
+// token.
+const sessionCookie = "session"
+
+var validSessionToken = regexp.MustCompile(`\A[A-Za-z0-9_-]{16,128}\z`)
+
+// newSessionToken returns a random session token.
+func newSessionToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// sessionPlayerID returns the playerID of a session token. It is part of a
+// hash of the token, so that the token can't be recovered from the playerID.
+func sessionPlayerID(token string) playerID {
+	sum := sha256.Sum256([]byte(token))
+	return playerID(hex.EncodeToString(sum[:8]))
+}
+
+// requestSession returns the session token for a request: the "session" query
+// parameter, which lets non-browser clients keep their identity, or else the
+// session cookie. If neither holds a valid session token, a new one is
+// chosen. isNew reports whether the cookie should be set.
+func requestSession(r *http.Request) (token string, isNew bool) {
+	if v := r.URL.Query().Get("session"); validSessionToken.MatchString(v) {
+		return v, false
+	}
+	if c, err := r.Cookie(sessionCookie); err == nil && validSessionToken.MatchString(c.Value) {
+		return c.Value, false
+	}
+	return newSessionToken(), true
+}
+
+// sessionCookieHeader returns the header that sets the session cookie, for use
+// when upgrading a connection.
+func sessionCookieHeader(token string) http.Header {
+	c := &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   365 * 24 * 60 * 60,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	return http.Header{"Set-Cookie": {c.String()}}
+}
+
+// attribution records which player each live cell is attributed to. A cell
+// that a player places is attributed to them, and a cell that is born is
+// attributed to whichever player most of its parents are attributed to, so
+// each player's cells include the descendants of the cells they placed.
+type attribution struct {
+	owner [gridDimX][gridDimY]playerID
+	// pending holds the players who placed the cells in the diff for the next
+	// generation.
+	pending map[point]playerID
+	// placed holds the number of cells that each player has placed.
+	placed map[playerID]int
+	// pruneAt is the number of players in placed at which prune is next
+	// called.
+	pruneAt int
+}
+
+// maxTrackedPlayers is the number of players that attribution keeps the
+// statistics of before it forgets the players who have no live cells. Anyone
+// can make up a session token, so without a limit, placed could grow forever.
+const maxTrackedPlayers = 1024
+
+func newAttribution() *attribution {
+	return &attribution{
+		pending: make(map[point]playerID),
+		placed:  make(map[playerID]int),
+		pruneAt: maxTrackedPlayers,
+	}
+}
+
+// place records that a player placed the cells in a diff, which is to be
+// merged into the diff for the next generation.
+func (at *attribution) place(df diff, player playerID) {
+	for x, ydiff := range df {
+		for y := range ydiff {
+			at.pending[point{x, y}] = player
+		}
+		if player != "" {
+			at.placed[player] += len(ydiff)
+		}
+	}
+	if len(at.placed) > at.pruneAt {
+		at.prune()
+	}
+}
+
+// prune forgets the players who have no live cells, other than those with
+// pending cells. Players with live cells are kept, so there can still be more
+// than maxTrackedPlayers, in which case the next prune is put off until the
+// number of players has doubled.
+func (at *attribution) prune() {
+	keep := make(map[playerID]bool)
+	for x := 0; x < gridDimX; x++ {
+		for y := 0; y < gridDimY; y++ {
+			keep[at.owner[x][y]] = true
+		}
+	}
+	for _, player := range at.pending {
+		keep[player] = true
+	}
+	for player := range at.placed {
+		if !keep[player] {
+			delete(at.placed, player)
+		}
+	}
+	at.pruneAt = maxTrackedPlayers
+	if 2*len(at.placed) > at.pruneAt {
+		at.pruneAt = 2 * len(at.placed)
+	}
+}
+
+// advance updates the attribution of the cells in df. It must be called
+// before df is flushed into g.
+func (at *attribution) advance(g *grid, df diff, rs *ruleset) {
+	// Work out every new owner before changing any, so that newborn cells see
+	// the owners of their parents as they were.
+	owners := make(map[point]playerID)
+	for x, ydiff := range df {
+		for y, s := range ydiff {
+			p := point{x, y}
+			if player, ok := at.pending[p]; ok {
+				owners[p] = player
+			} else if !isLive(s) {
+				owners[p] = ""
+			} else if !isLive(g[x][y]) {
+				owners[p] = at.parentOwner(g, p, rs)
+			}
+			// A surviving cell that changed species stays with its owner.
+		}
+	}
+	for p, player := range owners {
+		at.owner[p.x][p.y] = player
+	}
+	for p := range at.pending {
+		delete(at.pending, p)
+	}
+}
+
+// parentOwner returns the player that most of the live neighbors of a cell
+// are attributed to. Ties go to the first such player in neighborhood order.
+func (at *attribution) parentOwner(g *grid, p point, rs *ruleset) playerID {
+	count := make(map[playerID]int)
+	var best playerID
+	for _, o := range rs.neighborhood.offsets {
+		q, ok := rs.topology.wrap(p.x+o.x, p.y+o.y)
+		if !ok || !isLive(g[q.x][q.y]) {
+			continue
+		}
+		owner := at.owner[q.x][q.y]
+		count[owner]++
+		if count[owner] > count[best] {
+			best = owner
+		}
+	}
+	return best
+}
+
+// playerStats are the statistics for a single player. Alive is the number of
+// live cells attributed to the player, which includes the player's cells and
+// their descendants.
+type playerStats struct {
+	ID     playerID `json:"id"`
+	Placed int      `json:"placed"`
+	Alive  int      `json:"alive"`
+}
+
+// stats returns the statistics for every player who has placed a cell,
+// ordered by the number of live cells, most first. counted is the grid of
+// cells counted by population.advance, so that cells fading out during debris
+// cleanup aren't counted.
+func (at *attribution) stats(counted *grid) []playerStats {
+	alive := make(map[playerID]int)
+	for x := 0; x < gridDimX; x++ {
+		for y := 0; y < gridDimY; y++ {
+			if counted[x][y] != "" && at.owner[x][y] != "" {
+				alive[at.owner[x][y]]++
+			}
+		}
+	}
+	list := make([]playerStats, 0, len(at.placed))
+	for id, placed := range at.placed {
+		list = append(list, playerStats{id, placed, alive[id]})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Alive != list[j].Alive {
+			return list[i].Alive > list[j].Alive
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// getPlayerStats requests the statistics for every player, which gol sends
+// on reply. reply should be buffered so that gol doesn't block.
+type getPlayerStats struct {
+	reply chan<- []playerStats
+}
+
+const (
+	// statsBurst is the number of requests for statistics that a connection
+	// can send in quick succession. gol computes statistics from the whole
+	// grid, so they are limited more tightly than chat messages.
+	statsBurst = 3
+	// statsRefill is the time it takes a connection to earn another request
+	// for statistics after using up its burst.
+	statsRefill = 2 * time.Second
+)
+
+// newStatsLimiter returns a tokenBucket that limits the rate at which a
+// connection can ask for statistics.
+func newStatsLimiter() *tokenBucket {
+	return newTokenBucket(statsBurst, statsRefill)
+}
+
+// requestStats is sent by a client to ask for the statistics for every
+// player. gol answers with a statsMessage.
+type requestStats struct {
+	li     *listener
+	player playerID
+}
+
+// statsMessage is the JSON representation of the statistics sent to a client.
+// You is the client's own playerID.
+type statsMessage struct {
+	Type    string        `json:"type"`
+	You     playerID      `json:"you"`
+	Players []playerStats `json:"players"`
+}
+
+// currentPlayerStats returns the statistics for every player.
+func (pl *pipeline) currentPlayerStats() []playerStats {
+	reply := make(chan []playerStats, 1)
+	pl.golChan <- &getPlayerStats{reply}
+	return <-reply
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_requestSession(t *testing.T) {
+	const token, cookie = "0123456789abcdef-query", "0123456789abcdef-cookie"
+	r := httptest.NewRequest(http.MethodGet, "/?session="+token, nil)
+	r.AddCookie(&http.Cookie{Name: sessionCookie, Value: cookie})
+	if got, isNew := requestSession(r); got != token || isNew {
+		t.Errorf("Expected the query parameter to win but got %q, %v", got, isNew)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(&http.Cookie{Name: sessionCookie, Value: cookie})
+	if got, isNew := requestSession(r); got != cookie || isNew {
+		t.Errorf("Expected the cookie to be used but got %q, %v", got, isNew)
+	}
+
+	// Tokens that are short enough to guess aren't accepted.
+	r = httptest.NewRequest(http.MethodGet, "/?session=abc", nil)
+	got, isNew := requestSession(r)
+	if !isNew || !validSessionToken.MatchString(got) {
+		t.Errorf("Expected a new valid token but got %q, %v", got, isNew)
+	}
+}
+
+// A player's ID should be stable for a session token, and not reveal it.
+func Test_sessionPlayerID(t *testing.T) {
+	const token = "0123456789abcdef"
+	id := sessionPlayerID(token)
+	if id != sessionPlayerID(token) || len(id) != 16 || strings.Contains(token, string(id)) {
+		t.Errorf("Got incorrect player ID: %q", id)
+	}
+	if id == sessionPlayerID("0123456789abcdeg") {
+		t.Errorf("Expected different tokens to have different player IDs")
+	}
+}
+
+// Players with no live cells should be forgotten once there are too many.
+func Test_attributionPrune(t *testing.T) {
+	at := newAttribution()
+	at.owner[0][0] = "alive"
+	at.placed["alive"] = 1
+	for i := 0; i < maxTrackedPlayers; i++ {
+		at.place(diff{1: {1: "#aaaaaa"}}, playerID(fmt.Sprintf("p%d", i)))
+		for p := range at.pending {
+			delete(at.pending, p)
+		}
+	}
+	if len(at.placed) > maxTrackedPlayers {
+		t.Errorf("Expected at most %v players but got %v", maxTrackedPlayers, len(at.placed))
+	}
+	if at.placed["alive"] != 1 {
+		t.Errorf("Expected a player with live cells to be kept")
+	}
+}
+
+// Cells born from a player's cells should be attributed to that player, and
+// cells that die should no longer be attributed to anyone.
+func Test_attribution(t *testing.T) {
+	g, df := &grid{}, make(diff)
+	rs := testRuleset()
+	at := newAttribution()
+
+	// Player "a" places a blinker, and player "b" places a lone cell.
+	blinker := diff{10: {10: "#aaaaaa", 11: "#aaaaaa", 12: "#aaaaaa"}}
+	merge(blinker, df)
+	at.place(blinker, "a")
+	lone := diff{50: {50: "#bbbbbb"}}
+	merge(lone, df)
+	at.place(lone, "b")
+	at.advance(g, df, rs)
+	flush(df, g)
+
+	stats := at.stats(g)
+	if len(stats) != 2 ||
+		stats[0] != (playerStats{"a", 3, 3}) ||
+		stats[1] != (playerStats{"b", 1, 1}) {
+		t.Errorf("Got incorrect stats: %+v", stats)
+	}
+
+	nextState(g, df, rs)
+	at.advance(g, df, rs)
+	flush(df, g)
+
+	// The blinker has flipped, and its new cells descend from "a". The lone
+	// cell has died.
+	if at.owner[9][11] != "a" || at.owner[11][11] != "a" {
+		t.Errorf("Expected newborn cells to be attributed to \"a\"")
+	}
+	if at.owner[10][10] != "" || at.owner[50][50] != "" {
+		t.Errorf("Expected dead cells not to be attributed to anyone")
+	}
+	stats = at.stats(g)
+	if len(stats) != 2 ||
+		stats[0] != (playerStats{"a", 3, 3}) ||
+		stats[1] != (playerStats{"b", 1, 0}) {
+		t.Errorf("Got incorrect stats: %+v", stats)
+	}
+}
+
+// A newborn cell should be attributed to the player that most of its parents
+// are attributed to.
+func Test_parentOwner(t *testing.T) {
+	g := &grid{}
+	at := newAttribution()
+	for _, c := range []struct {
+		p     point
+		owner playerID
+	}{
+		{point{9, 9}, "b"},
+		{point{9, 10}, "a"},
+		{point{11, 11}, "a"},
+	} {
+		g[c.p.x][c.p.y] = "#aaaaaa"
+		at.owner[c.p.x][c.p.y] = c.owner
+	}
+
+	if owner := at.parentOwner(g, point{10, 10}, testRuleset()); owner != "a" {
+		t.Errorf("Expected \"a\" but got %q", owner)
+	}
+}
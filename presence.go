@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	// presenceInterval is the interval between presence snapshots.
+	presenceInterval = 200 * time.Millisecond
+	// minPresenceUpdateInterval is the shortest interval between presence
+	// updates from a connection. Faster updates are dropped.
+	minPresenceUpdateInterval = 50 * time.Millisecond
+)
+
+// cursorJSON is the position of a player's cursor.
+type cursorJSON struct {
+	X int64 `json:"x"`
+	Y int64 `json:"y"`
+}
+
+// viewportJSON is the region of the grid that a player can see. Coordinates
+// are 64-bit so that viewports can be anywhere on the plane.
+type viewportJSON struct {
+	X      int64 `json:"x"`
+	Y      int64 `json:"y"`
+	Width  int64 `json:"width"`
+	Height int64 `json:"height"`
+}
+
+// region converts a viewport to a region of the grid, or returns an error if
+// the viewport isn't within the grid.
+func (v *viewportJSON) region() (region, error) {
+	if v.X < 0 || v.X >= gridDimX || v.Y < 0 || v.Y >= gridDimY ||
+		v.Width > gridDimY || v.Height > gridDimX {
+		return region{}, errors.New("viewport is outside the grid")
+	}
+	r := region{int(v.X), int(v.Y), int(v.Width), int(v.Height)}
+	return r, validateRegion(r)
+}
+
+// presence is where a player is looking and pointing, as sent by readPump to
+// hub. Cursor and Viewport are nil if the client didn't send them, e.g.
+// because the cursor is outside the grid.
+type presence struct {
+	li       *listener
+	Player   playerID      `json:"player"`
+	Species  species       `json:"species"`
+	Cursor   *cursorJSON   `json:"cursor"`
+	Viewport *viewportJSON `json:"viewport"`
+}
+
+// validatePresence checks a presence update from a client. On the plane, the
+// cursor and viewport may be anywhere.
+func validatePresence(p *presence, topo topology) error {
+	if !hexColorCode.MatchString(p.Species) {
+		return fmt.Errorf("presence contains a species that is not a "+
+			"hexadecimal color code (%v)", p.Species)
+	}
+	if topo == plane {
+		if v := p.Viewport; v != nil && (v.Width <= 0 || v.Height <= 0) {
+			return errors.New("presence viewport is empty")
+		}
+		return nil
+	}
+	if c := p.Cursor; c != nil {
+		if c.X < 0 || c.X >= gridDimX || c.Y < 0 || c.Y >= gridDimY {
+			return errors.New("presence cursor is outside the grid")
+		}
+	}
+	if v := p.Viewport; v != nil {
+		if _, err := v.region(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// presenceTick tells hub to send a presence snapshot if anything has changed.
+type presenceTick struct{}
+
+// presenceClock runs a loop that sends a presenceTick to hub at a regular
+// interval.
+func presenceClock(hubChan chan<- interface{}) {
+	ticker := time.NewTicker(presenceInterval)
+	for range ticker.C {
+		hubChan <- &presenceTick{}
+	}
+}
+
+// presenceMessage is the JSON representation of a presence snapshot.
+type presenceMessage struct {
+	Type    string      `json:"type"`
+	Players []*presence `json:"players"`
+}
+
+// presenceBoard is the state that hub keeps for presence.
+type presenceBoard struct {
+	current map[*listener]*presence
+	// changed is true if current has changed since the last snapshot was
+	// broadcast.
+	changed bool
+}
+
+func newPresenceBoard() *presenceBoard {
+	return &presenceBoard{current: make(map[*listener]*presence)}
+}
+
+func (pb *presenceBoard) update(p *presence) {
+	pb.current[p.li] = p
+	pb.changed = true
+}
+
+// remove forgets a listener's presence, e.g. when it unregisters.
+func (pb *presenceBoard) remove(li *listener) {
+	if _, ok := pb.current[li]; ok {
+		delete(pb.current, li)
+		pb.changed = true
+	}
+}
+
+// snapshot encodes the presence of every listener, ordered by player ID and
+// then species so that snapshots are stable.
+func (pb *presenceBoard) snapshot() []byte {
+	players := make([]*presence, 0, len(pb.current))
+	for _, p := range pb.current {
+		players = append(players, p)
+	}
+	sort.Slice(players, func(i, j int) bool {
+		if players[i].Player != players[j].Player {
+			return players[i].Player < players[j].Player
+		}
+		return players[i].Species < players[j].Species
+	})
+	message, _ := json.Marshal(&presenceMessage{"presence", players})
+	return message
+}
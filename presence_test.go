@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+)
+
+func Test_validatePresence(t *testing.T) {
+	valid := []*presence{
+		{Species: "#aaaaaa"},
+		{Species: "#aaaaaa", Cursor: &cursorJSON{gridDimX - 1, gridDimY - 1}},
+		{Species: "#aaaaaa", Viewport: &viewportJSON{0, 0, gridDimY, gridDimX}},
+	}
+	for _, p := range valid {
+		if err := validatePresence(p, torus); err != nil {
+			t.Errorf("Expected %+v to be valid but got %v", p, err)
+		}
+	}
+	invalid := []*presence{
+		{Species: "red"},
+		{Species: "#aaaaaa", Cursor: &cursorJSON{gridDimX, 0}},
+		{Species: "#aaaaaa", Viewport: &viewportJSON{0, 0, 0, 10}},
+		{Species: "#aaaaaa", Viewport: &viewportJSON{1, 0, 10, gridDimX}},
+	}
+	for _, p := range invalid {
+		if err := validatePresence(p, torus); err == nil {
+			t.Errorf("Expected %+v to be rejected", p)
+		}
+	}
+}
+
+func Test_presenceBoard(t *testing.T) {
+	pb := newPresenceBoard()
+	li1 := &listener{}
+	li2 := &listener{}
+	pb.update(&presence{li: li2, Player: "bob", Species: "#bbbbbb"})
+	pb.update(&presence{li: li1, Player: "alice", Species: "#aaaaaa"})
+	if !pb.changed {
+		t.Errorf("Expected the board to have changed")
+	}
+	json := string(pb.snapshot())
+	expected := "{\"type\":\"presence\",\"players\":[" +
+		"{\"player\":\"alice\",\"species\":\"#aaaaaa\",\"cursor\":null,\"viewport\":null}," +
+		"{\"player\":\"bob\",\"species\":\"#bbbbbb\",\"cursor\":null,\"viewport\":null}]}"
+	if json != expected {
+		t.Errorf("Got incorrect JSON: %v", json)
+	}
+
+	// Removing a listener's presence expires it from the next snapshot.
+	pb.changed = false
+	pb.remove(li1)
+	if !pb.changed {
+		t.Errorf("Expected the board to have changed")
+	}
+	json = string(pb.snapshot())
+	expected = "{\"type\":\"presence\",\"players\":[" +
+		"{\"player\":\"bob\",\"species\":\"#bbbbbb\",\"cursor\":null,\"viewport\":null}]}"
+	if json != expected {
+		t.Errorf("Got incorrect JSON: %v", json)
+	}
+
+	// Removing a listener that never sent presence changes nothing.
+	pb.changed = false
+	pb.remove(li1)
+	if pb.changed {
+		t.Errorf("Expected the board not to have changed")
+	}
+}
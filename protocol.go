@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/websocket"
+)
+
+// protocolVersion is the version of the protocol that a connection speaks.
+// In version 1, the grid and diffs are sent as bare JSON, and clients tell
+// them apart by the order in which they arrive. In version 2, every message
+// is a JSON object with a "type" field. See protocol.md.
+type protocolVersion int
+
+const (
+	protocolV1 protocolVersion = iota
+	protocolV2
+)
+
+// subprotocols lists the WebSocket subprotocols that the server speaks, most
+// preferred first. A client that doesn't ask for any of them speaks version 1.
+var subprotocols = []string{"multi-life.v2", "multi-life.v1"}
+
+// parseSubprotocol returns the protocolVersion for the subprotocol negotiated
+// during the WebSocket handshake, which is "" if there was none.
+func parseSubprotocol(name string) protocolVersion {
+	if name == "multi-life.v2" {
+		return protocolV2
+	}
+	return protocolV1
+}
+
+// offersVersion2 reports whether a WebSocket handshake offers the version 2
+// subprotocol.
+func offersVersion2(r *http.Request) bool {
+	for _, name := range websocket.Subprotocols(r) {
+		if name == "multi-life.v2" {
+			return true
+		}
+	}
+	return false
+}
+
+// initMessage is the first message sent to a version 2 client. Grid is
+// encoded as in a version 1 grid, and is left out on the plane. Generation is
+// the number of diffs that have been applied to the grid. See history.count.
+type initMessage struct {
+	Type       string      `json:"type"`
+	Grid       interface{} `json:"grid,omitempty"`
+	Generation int         `json:"generation"`
+	World      worldInfo   `json:"world"`
+}
+
+// diffMessage is a diff sent to a version 2 client. Ants is only set for
+// clients with the ants capability. Generations is only set if the diff
+// coalesces more than one generation. See backlog.
+type diffMessage struct {
+	Type        string      `json:"type"`
+	Cells       interface{} `json:"cells"`
+	Ants        interface{} `json:"ants,omitempty"`
+	Generations int         `json:"generations,omitempty"`
+}
+
+// typedMessage is a version 2 message that has no fields other than its
+// type, such as the end of a stream.
+type typedMessage struct {
+	Type string `json:"type"`
+}
+
+// errorMessage tells a version 2 client why the server is closing the
+// connection.
+type errorMessage struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// invalidMessageError is the error sent on a connection's errorSignal when
+// the client sends a message that can't be decoded.
+type invalidMessageError struct {
+	err error
+}
+
+func (err *invalidMessageError) Error() string {
+	return fmt.Sprintf("Invalid message: %v", err.err)
+}
+
+// errorText returns the text of the error message to send to a version 2
+// client before closing the connection because of err. ok is false if the
+// client doesn't need to be told, e.g. because it closed the connection.
+func errorText(err error) (text string, ok bool) {
+	switch err := err.(type) {
+	case *invalidMessageError:
+		return err.err.Error(), true
+	case *bufferOverflowError:
+		return "connection fell too far behind", true
+	}
+	return "", false
+}
+
+// marshalInit encodes the grid, as of generation gen, for a new client with
+// the given capabilities. Version 2 clients receive the grid in an init
+// message along with the generation and the world.
+func marshalInit(g *grid, a *ageGrid, gen int, cfg *config, caps capabilities) []byte {
+	var cells interface{} = g
+	if caps.ages {
+		cells = json.RawMessage(marshalGridWithAges(g, a))
+	}
+	if caps.version == protocolV1 {
+		message, _ := json.Marshal(cells)
+		return message
+	}
+	message, _ := json.Marshal(&initMessage{"init", cells, gen, newWorldInfo(cfg)})
+	return message
+}
+
+// marshalStreamEnd encodes the message that ends a stream: the empty diff in
+// version 1, or a streamEnd message in version 2.
+func marshalStreamEnd(caps capabilities) []byte {
+	if caps.version == protocolV1 {
+		return []byte("{}")
+	}
+	message, _ := json.Marshal(&typedMessage{"streamEnd"})
+	return message
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// tokenBucket limits the rate of some action, using a bucket that holds up to
+// burst tokens and gains one every refill. Each action uses up a token.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	burst  float64
+	refill time.Duration
+}
+
+func newTokenBucket(burst int, refill time.Duration) *tokenBucket {
+	return &tokenBucket{tokens: float64(burst), burst: float64(burst), refill: refill}
+}
+
+// allow reports whether the action can be taken at time now, and if so, uses
+// up a token.
+func (l *tokenBucket) allow(now time.Time) bool {
+	if !l.last.IsZero() {
+		l.tokens += float64(now.Sub(l.last)) / float64(l.refill)
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// keyedLimiter keeps a tokenBucket for each of many keys, such as the
+// addresses of HTTP clients. Its methods can be called concurrently.
+type keyedLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	burst   int
+	refill  time.Duration
+}
+
+// maxIdleBuckets is the number of buckets that a keyedLimiter holds before it
+// forgets the keys that have refilled their buckets.
+const maxIdleBuckets = 1024
+
+func newKeyedLimiter(burst int, refill time.Duration) *keyedLimiter {
+	return &keyedLimiter{buckets: make(map[string]*tokenBucket), burst: burst, refill: refill}
+}
+
+// allow reports whether the action can be taken for key at time now, and if
+// so, uses up one of key's tokens.
+func (kl *keyedLimiter) allow(key string, now time.Time) bool {
+	kl.mu.Lock()
+	defer kl.mu.Unlock()
+	if len(kl.buckets) >= maxIdleBuckets {
+		// A full bucket is the same as a new one, so it can be forgotten.
+		full := time.Duration(kl.burst) * kl.refill
+		for k, b := range kl.buckets {
+			if now.Sub(b.last) >= full {
+				delete(kl.buckets, k)
+			}
+		}
+	}
+	b, ok := kl.buckets[key]
+	if !ok {
+		b = newTokenBucket(kl.burst, kl.refill)
+		kl.buckets[key] = b
+	}
+	return b.allow(now)
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// neighborhood is the set of cells whose states determine the next state of
+// a cell, given as offsets from the cell.
+type neighborhood struct {
+	kind    neighborhoodKind
+	radius  int
+	offsets []point
+}
+
+type neighborhoodKind string
+
+const (
+	// moore is the square of cells around a cell. With radius 1, it is the
+	// 8 cells used by Conway's Game of Life. Larger radii give "Larger than
+	// Life" rules.
+	moore neighborhoodKind = "moore"
+	// vonNeumann is the diamond of cells within a Manhattan distance of the
+	// radius.
+	vonNeumann neighborhoodKind = "vonneumann"
+	// hexagonal treats the grid as hexagonal, with each row shifted half a
+	// cell to the right of the row above. The neighbors at radius 1 are the
+	// cells to the left and right, the two cells above at (x-1,y) and
+	// (x-1,y+1), and the two cells below at (x+1,y-1) and (x+1,y).
+	hexagonal neighborhoodKind = "hex"
+)
+
+// maxRadius is the largest neighborhood radius. A radius r Moore neighborhood
+// has (2r+1)^2-1 cells, all of which are visited for every cell each tick.
+const maxRadius = 5
+
+// mooreOffsets are the offsets from a cell to the cells in its radius 1 Moore
+// neighborhood.
+var mooreOffsets = newNeighborhood(moore, 1).offsets
+
+// newNeighborhood returns a neighborhood of the given kind and radius. The
+// kind and radius must be valid. See parseNeighborhood.
+func newNeighborhood(kind neighborhoodKind, radius int) neighborhood {
+	var offsets []point
+	// Visit offsets column by column, as the original neighbors function did.
+	for dy := -radius; dy <= radius; dy++ {
+		for dx := -radius; dx <= radius; dx++ {
+			if dx == 0 && dy == 0 {
+				continue
+			}
+			var in bool
+			switch kind {
+			case moore:
+				in = true
+			case vonNeumann:
+				in = abs(dx)+abs(dy) <= radius
+			case hexagonal:
+				// Hex distance in axial coordinates, where moving down a
+				// row and left a column is a single step.
+				in = (abs(dx)+abs(dy)+abs(dx+dy))/2 <= radius
+			}
+			if in {
+				offsets = append(offsets, point{dx, dy})
+			}
+		}
+	}
+	return neighborhood{kind, radius, offsets}
+}
+
+// parseNeighborhood returns the neighborhood with the given name and radius.
+func parseNeighborhood(name string, radius int) (neighborhood, error) {
+	kind := neighborhoodKind(name)
+	if kind != moore && kind != vonNeumann && kind != hexagonal {
+		return neighborhood{}, fmt.Errorf("unknown neighborhood (%v)", name)
+	}
+	if radius < 1 || radius > maxRadius {
+		return neighborhood{}, fmt.Errorf("neighborhood radius must be between 1 and %v", maxRadius)
+	}
+	return newNeighborhood(kind, radius), nil
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// lifeRule is an outer totalistic rule: whether a cell is live in the next
+// generation depends only on whether it is live now and on the number of live
+// cells in its neighborhood.
+//
+// A lifeRule may also be a Generations rule, in which a live cell that doesn't
+// survive passes through one or more dying, or refractory, states before it
+// becomes dead. A dying cell moves on to the next state every generation,
+// whatever its neighbors. It doesn't count as a live neighbor, and no cell can
+// be born in its place. E.g., Brian's Brain is B2/S/C3, in which cells die
+// after a single dying state.
+type lifeRule struct {
+	// born and survives are indexed by the number of live neighbors.
+	born     []bool
+	survives []bool
+	// states is the number of cell states, counting dead and live. Rules
+	// without dying states have 2.
+	states int
+	// notation is the rule in B/S notation, as it was parsed.
+	notation string
+}
+
+// maxStates is the largest number of states in a Generations rule.
+const maxStates = 256
+
+// decay returns the state that follows a live or dying cell that doesn't
+// survive.
+func (r *lifeRule) decay(s species) species {
+	color, state := cellState(s)
+	if state+1 >= r.states {
+		return ""
+	}
+	return dyingCell(color, state+1)
+}
+
+// conway is the rule of Conway's Game of Life.
+const conway = "B3/S23"
+
+// parseRule parses a rule in B/S notation, e.g. "B3/S23", for a neighborhood
+// of n cells. Each count is written as a single digit, unless the counts are
+// separated by commas, in which case each may have several digits, and a range
+// may be written as "a..b". E.g., "B34..45/S33..57" for a Larger than Life
+// rule. A Generations rule has a third part giving the number of states, e.g.
+// "B2/S345/C4" for Star Wars.
+func parseRule(notation string, n int) (lifeRule, error) {
+	parts := strings.Split(strings.ToUpper(notation), "/")
+	if (len(parts) != 2 && len(parts) != 3) ||
+		!strings.HasPrefix(parts[0], "B") || !strings.HasPrefix(parts[1], "S") {
+		return lifeRule{}, fmt.Errorf("rule is not in B/S notation (%v)", notation)
+	}
+	states := 2
+	if len(parts) == 3 {
+		var err error
+		states, err = strconv.Atoi(strings.TrimPrefix(parts[2], "C"))
+		if err != nil || states < 2 || states > maxStates {
+			return lifeRule{}, fmt.Errorf("number of states must be between 2 and %v (%v)",
+				maxStates, parts[2])
+		}
+	}
+	born, err := parseCounts(parts[0][1:], n)
+	if err != nil {
+		return lifeRule{}, err
+	}
+	survives, err := parseCounts(parts[1][1:], n)
+	if err != nil {
+		return lifeRule{}, err
+	}
+	if born[0] {
+		// Every dead cell far from any live cell would come to life.
+		return lifeRule{}, errors.New("rules with B0 are not supported")
+	}
+	return lifeRule{born, survives, states, notation}, nil
+}
+
+// parseCounts parses a list of neighbor counts, each of which must be at most
+// n. See parseRule.
+func parseCounts(list string, n int) ([]bool, error) {
+	counts := make([]bool, n+1)
+	var items []string
+	if strings.ContainsAny(list, ",.") {
+		items = strings.Split(list, ",")
+	} else {
+		items = strings.Split(list, "")
+	}
+	for _, item := range items {
+		if item == "" {
+			continue
+		}
+		lo, hi, isRange := strings.Cut(item, "..")
+		if !isRange {
+			hi = lo
+		}
+		from, err := strconv.Atoi(lo)
+		if err != nil {
+			return nil, fmt.Errorf("malformed neighbor count (%v)", item)
+		}
+		to, err := strconv.Atoi(hi)
+		if err != nil {
+			return nil, fmt.Errorf("malformed neighbor count (%v)", item)
+		}
+		if from < 0 || from > to || to > n {
+			return nil, fmt.Errorf("neighbor count out of range for a neighborhood of %v cells (%v)", n, item)
+		}
+		for i := from; i <= to; i++ {
+			counts[i] = true
+		}
+	}
+	return counts, nil
+}
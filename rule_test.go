@@ -0,0 +1,203 @@
+package main
+
+import "testing"
+
+func Test_newNeighborhood(t *testing.T) {
+	cases := []struct {
+		kind   neighborhoodKind
+		radius int
+		size   int
+	}{
+		{moore, 1, 8},
+		{moore, 2, 24},
+		{vonNeumann, 1, 4},
+		{vonNeumann, 2, 12},
+		{hexagonal, 1, 6},
+		{hexagonal, 2, 18},
+	}
+	for _, c := range cases {
+		if n := len(newNeighborhood(c.kind, c.radius).offsets); n != c.size {
+			t.Errorf("Expected %v neighborhood of radius %v to have %v cells but got %v",
+				c.kind, c.radius, c.size, n)
+		}
+	}
+	// The hexagonal neighborhood leaves out the upper left and lower right
+	// diagonal cells of the Moore neighborhood.
+	for _, o := range newNeighborhood(hexagonal, 1).offsets {
+		if o == (point{-1, -1}) || o == (point{1, 1}) {
+			t.Errorf("Expected hexagonal neighborhood not to include %v", o)
+		}
+	}
+}
+
+func Test_parseNeighborhood(t *testing.T) {
+	if _, err := parseNeighborhood("moore", 2); err != nil {
+		t.Errorf("Expected no error but got %v", err)
+	}
+	if _, err := parseNeighborhood("triangle", 1); err == nil {
+		t.Errorf("Expected an unknown neighborhood to be rejected")
+	}
+	if _, err := parseNeighborhood("moore", maxRadius+1); err == nil {
+		t.Errorf("Expected a radius larger than maxRadius to be rejected")
+	}
+	if _, err := parseNeighborhood("moore", 0); err == nil {
+		t.Errorf("Expected a radius of 0 to be rejected")
+	}
+}
+
+func Test_parseRule(t *testing.T) {
+	r, err := parseRule("B36/S23", 8)
+	if err != nil {
+		t.Fatalf("Expected no error but got %v", err)
+	}
+	for n := 0; n <= 8; n++ {
+		if r.born[n] != (n == 3 || n == 6) {
+			t.Errorf("Expected born[%v] to be %v", n, !r.born[n])
+		}
+		if r.survives[n] != (n == 2 || n == 3) {
+			t.Errorf("Expected survives[%v] to be %v", n, !r.survives[n])
+		}
+	}
+
+	r, err = parseRule("B34..45/S33..57", 120)
+	if err != nil {
+		t.Fatalf("Expected no error but got %v", err)
+	}
+	if r.born[33] || !r.born[34] || !r.born[45] || r.born[46] {
+		t.Errorf("Expected births for 34 to 45 neighbors")
+	}
+	if r.survives[32] || !r.survives[33] || !r.survives[57] || r.survives[58] {
+		t.Errorf("Expected survival for 33 to 57 neighbors")
+	}
+
+	r, err = parseRule("b2/s", 8)
+	if err != nil {
+		t.Fatalf("Expected no error but got %v", err)
+	}
+	if !r.born[2] {
+		t.Errorf("Expected births for 2 neighbors")
+	}
+	for n, v := range r.survives {
+		if v {
+			t.Errorf("Expected no survival for %v neighbors", n)
+		}
+	}
+
+	for _, notation := range []string{"", "B3", "S23/B3", "B9/S23", "B3/S2x", "B0/S23", "B5..4/S"} {
+		if _, err := parseRule(notation, 8); err == nil {
+			t.Errorf("Expected %q to be rejected", notation)
+		}
+	}
+}
+
+// In a von Neumann neighborhood, a cell is born from three orthogonal
+// neighbors but not from diagonal ones.
+func Test_nextStateVonNeumann(t *testing.T) {
+	g, df := &grid{}, make(diff)
+	g[9][10] = "#aaaaaa"
+	g[10][9] = "#aaaaaa"
+	g[10][11] = "#aaaaaa"
+	g[20][20] = "#aaaaaa"
+	g[20][22] = "#aaaaaa"
+	g[22][20] = "#aaaaaa"
+	rs := testRuleset()
+	rs.neighborhood = newNeighborhood(vonNeumann, 1)
+	rs.rule, _ = parseRule("B3/S", 4)
+
+	nextState(g, df, rs)
+
+	if v := df[10][10]; v != "#aaaaaa" {
+		t.Errorf("Expected (10, 10) to be born but got %q", v)
+	}
+	if v, ok := df[21][21]; ok {
+		t.Errorf("Expected (21, 21) to stay dead but got %q", v)
+	}
+}
+
+// A cell with neighbors two cells away should count them in a radius 2 Moore
+// neighborhood.
+func Test_neighborsRadius2(t *testing.T) {
+	g := &grid{}
+	g[8][8] = "#aaaaaa"
+	g[12][10] = "#bbbbbb"
+	g[12][12] = "#bbbbbb"
+	g[13][13] = "#cccccc"
+	rs := testRuleset()
+	rs.neighborhood = newNeighborhood(moore, 2)
+
+	n, sMax, _ := neighbors(g, 10, 10, rs)
+
+	if n != 3 {
+		t.Errorf("Expected number of neighbors to be 3 but got %v", n)
+	}
+	if sMax != "#bbbbbb" {
+		t.Errorf("Expected most populous species to be \"#bbbbbb\" but got %q", sMax)
+	}
+}
+
+func Test_parseRuleGenerations(t *testing.T) {
+	r, err := parseRule("B2/S345/C4", 8)
+	if err != nil {
+		t.Fatalf("Expected no error but got %v", err)
+	}
+	if r.states != 4 {
+		t.Errorf("Expected 4 states but got %v", r.states)
+	}
+	if r, _ := parseRule("B3/S23", 8); r.states != 2 {
+		t.Errorf("Expected 2 states but got %v", r.states)
+	}
+	for _, notation := range []string{"B2/S/C1", "B2/S/C", "B2/S/C257", "B2/S/3/4"} {
+		if _, err := parseRule(notation, 8); err == nil {
+			t.Errorf("Expected %q to be rejected", notation)
+		}
+	}
+}
+
+func Test_decay(t *testing.T) {
+	r, _ := parseRule("B2/S345/C4", 8)
+	cases := []struct {
+		s, want species
+	}{
+		{"#aaaaaa", "#aaaaaa:2"},
+		{"#aaaaaa:2", "#aaaaaa:3"},
+		{"#aaaaaa:3", ""},
+	}
+	for _, c := range cases {
+		if got := r.decay(c.s); got != c.want {
+			t.Errorf("Expected %q to decay to %q but got %q", c.s, c.want, got)
+		}
+	}
+	conway, _ := parseRule(conway, 8)
+	if got := conway.decay("#aaaaaa"); got != "" {
+		t.Errorf("Expected a live cell to die without dying states but got %q", got)
+	}
+}
+
+// Under Brian's Brain, a live cell always dies, passing through one dying
+// state, and dying cells neither count as neighbors nor allow births.
+func Test_nextStateBriansBrain(t *testing.T) {
+	g, df := &grid{}, make(diff)
+	g[10][10] = "#aaaaaa"
+	g[10][11] = "#aaaaaa"
+	g[20][20] = "#bbbbbb:2"
+	g[21][20] = "#bbbbbb:2"
+	rs := testRuleset()
+	rs.rule, _ = parseRule("B2/S/C3", 8)
+
+	nextState(g, df, rs)
+
+	if v := df[10][10]; v != "#aaaaaa:2" {
+		t.Errorf("Expected (10, 10) to be dying but got %q", v)
+	}
+	if v := df[9][10]; v != "#aaaaaa" {
+		t.Errorf("Expected (9, 10) to be born but got %q", v)
+	}
+	if v, ok := df[20][20]; !ok || v != "" {
+		t.Errorf("Expected (20, 20) to die but got %q", v)
+	}
+	// (21, 21) neighbors two dying cells, which would give birth if they
+	// counted as live.
+	if v, ok := df[21][21]; ok {
+		t.Errorf("Expected (21, 21) to stay dead but got %q", v)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// The colors of dead cells and grid lines, which match those used by the
+// client.
+var (
+	deadCellColor = color.RGBA{0xd3, 0xd3, 0xd3, 0xff} // lightgray
+	gridLineColor = color.RGBA{0xc0, 0xc0, 0xc0, 0xff} // silver
+)
+
+// maxCellSize is the largest number of pixels per cell that can be rendered.
+const maxCellSize = 32
+
+// maxSnapshotPixels is the largest number of pixels in a snapshot served over
+// HTTP, which allows the whole grid at 16 pixels per cell.
+const maxSnapshotPixels = 1 << 22
+
+// maxTimeLapsePixels is the largest number of pixels, summed over all frames,
+// in a time-lapse served over HTTP. Every frame is held in memory until the
+// GIF is encoded, so this bounds the memory that a request can take.
+const maxTimeLapsePixels = 1 << 25
+
+// region is a rectangle of cells. As with the grid, X selects a row and Y
+// selects a column.
+type region struct {
+	x      int
+	y      int
+	width  int
+	height int
+}
+
+// fullRegion is the region covering the entire grid.
+var fullRegion = region{0, 0, gridDimY, gridDimX}
+
+// validateRegion checks that a region lies within the grid. The dimensions
+// are compared against the grid's before the offsets are, so that huge values
+// can't overflow.
+func validateRegion(r region) error {
+	if r.height <= 0 || r.height > gridDimX || r.x < 0 || r.x > gridDimX-r.height {
+		return errors.New("region exceeds grid's X dimension")
+	}
+	if r.width <= 0 || r.width > gridDimY || r.y < 0 || r.y > gridDimY-r.width {
+		return errors.New("region exceeds grid's Y dimension")
+	}
+	return nil
+}
+
+// renderOptions controls how renderGrid draws a grid.
+type renderOptions struct {
+	// cellSize is the width and height of each cell in pixels.
+	cellSize int
+	// gridLines draws a one pixel border around each cell.
+	gridLines bool
+	// region is the part of the grid to draw.
+	region region
+}
+
+func validateRenderOptions(opts renderOptions) error {
+	if opts.cellSize < 1 || opts.cellSize > maxCellSize {
+		return errors.New("cell size is out of range")
+	}
+	if opts.gridLines && opts.cellSize < 3 {
+		return errors.New("cell size is too small to draw grid lines")
+	}
+	return validateRegion(opts.region)
+}
+
+// renderGrid draws a region of the grid as an image, with each live cell
+// filled with its species' color. Rows of the grid are drawn from top to
+// bottom, as on the client.
+func renderGrid(g *grid, opts renderOptions) *image.RGBA {
+	img := image.NewRGBA(imageBounds(opts))
+	if opts.gridLines {
+		draw.Draw(img, img.Bounds(), image.NewUniform(gridLineColor), image.Point{}, draw.Src)
+	}
+	paintCells(g, opts, func(rect image.Rectangle, s species) {
+		c := deadCellColor
+		if s != "" {
+			c = speciesColor(s)
+		}
+		draw.Draw(img, rect, image.NewUniform(c), image.Point{}, draw.Src)
+	})
+	return img
+}
+
+// imageBounds returns the bounds of an image rendered with the given options.
+func imageBounds(opts renderOptions) image.Rectangle {
+	return image.Rect(0, 0, opts.region.width*opts.cellSize, opts.region.height*opts.cellSize)
+}
+
+// imagePixels returns the number of pixels in an image rendered with opts.
+func imagePixels(opts renderOptions) int {
+	b := imageBounds(opts)
+	return b.Dx() * b.Dy()
+}
+
+// paintCells calls paint with the species of each cell in the region to be
+// rendered, and the rectangle of pixels that the cell occupies.
+func paintCells(g *grid, opts renderOptions, paint func(rect image.Rectangle, s species)) {
+	r := opts.region
+	for row := 0; row < r.height; row++ {
+		for col := 0; col < r.width; col++ {
+			rect := image.Rect(col*opts.cellSize, row*opts.cellSize,
+				(col+1)*opts.cellSize, (row+1)*opts.cellSize)
+			if opts.gridLines {
+				rect = rect.Inset(1)
+			}
+			paint(rect, g[r.x+row][r.y+col])
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"image/color"
+	"net/url"
+	"testing"
+)
+
+func Test_renderGrid(t *testing.T) {
+	g := &grid{}
+	g[1][2] = "#ff8000"
+
+	img := renderGrid(g, renderOptions{cellSize: 2, region: fullRegion})
+
+	if b := img.Bounds(); b.Dx() != gridDimY*2 || b.Dy() != gridDimX*2 {
+		t.Fatalf("Expected %vx%v image but got %vx%v", gridDimY*2, gridDimX*2, b.Dx(), b.Dy())
+	}
+	// Cell (1,2) is in the second row and third column.
+	live := color.RGBA{0xff, 0x80, 0x00, 0xff}
+	for _, p := range [][2]int{{4, 2}, {5, 3}} {
+		if c := img.RGBAAt(p[0], p[1]); c != live {
+			t.Errorf("Expected pixel %v to be %v but got %v", p, live, c)
+		}
+	}
+	for _, p := range [][2]int{{0, 0}, {2, 4}, {6, 2}} {
+		if c := img.RGBAAt(p[0], p[1]); c != deadCellColor {
+			t.Errorf("Expected pixel %v to be %v but got %v", p, deadCellColor, c)
+		}
+	}
+}
+
+func Test_renderGridRegionAndLines(t *testing.T) {
+	g := &grid{}
+	g[10][20] = "#0000ff"
+
+	img := renderGrid(g, renderOptions{
+		cellSize:  4,
+		gridLines: true,
+		region:    region{x: 10, y: 20, width: 3, height: 2},
+	})
+
+	if b := img.Bounds(); b.Dx() != 12 || b.Dy() != 8 {
+		t.Fatalf("Expected 12x8 image but got %vx%v", b.Dx(), b.Dy())
+	}
+	if c := img.RGBAAt(0, 0); c != gridLineColor {
+		t.Errorf("Expected grid line but got %v", c)
+	}
+	if c := img.RGBAAt(1, 1); c != (color.RGBA{0, 0, 0xff, 0xff}) {
+		t.Errorf("Expected live cell but got %v", c)
+	}
+	if c := img.RGBAAt(5, 5); c != deadCellColor {
+		t.Errorf("Expected dead cell but got %v", c)
+	}
+}
+
+func Test_renderOptionsFromQuery(t *testing.T) {
+	opts, err := renderOptionsFromQuery(url.Values{"cell": {"8"}, "lines": {"1"}, "x": {"100"}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := renderOptions{8, true, region{100, 0, gridDimY, gridDimX - 100}}
+	if opts != expected {
+		t.Errorf("Expected %+v but got %+v", expected, opts)
+	}
+
+	for _, q := range []url.Values{
+		{"cell": {"0"}},
+		{"cell": {"2"}, "lines": {"1"}},
+		{"x": {"-1"}},
+		{"y": {"10"}, "width": {"111"}},
+		{"height": {"a"}},
+		{"x": {"1"}, "height": {"9223372036854775807"}, "cell": {"2"}},
+		{"y": {"1"}, "width": {"9223372036854775807"}},
+	} {
+		if _, err := renderOptionsFromQuery(q); err == nil {
+			t.Errorf("Expected an error for %v", q)
+		}
+	}
+}
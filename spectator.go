@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+)
+
+// errSpectator is the error for a message from a spectator. Spectators can
+// watch the game, but can't send any messages.
+var errSpectator = errors.New("spectators may not send messages")
+
+// isSpectatorRequest reports whether a WebSocket handshake asks for a
+// spectator connection, via the "spectate" query parameter.
+func isSpectatorRequest(r *http.Request) bool {
+	return r.URL.Query().Get("spectate") == "1"
+}
+
+// spectatorReadPump is readPump for spectators. It only reads from the
+// connection so that control frames are handled, and stops with an error
+// when the client sends a message.
+func spectatorReadPump(errSig *errorSignal, read readFromConn) {
+	if _, _, err := read(); err != nil {
+		errSig.send(err)
+		return
+	}
+	errSig.send(&invalidMessageError{errSpectator})
+}
+
+// connectionCounts holds the number of players and spectators that are
+// connected.
+type connectionCounts struct {
+	Players    int `json:"players"`
+	Spectators int `json:"spectators"`
+}
+
+// connectionLimiter counts connections and enforces config.maxPlayers and
+// config.maxSpectators, which count players and spectators separately. Its
+// methods can be called concurrently.
+type connectionLimiter struct {
+	mu            sync.Mutex
+	counts        connectionCounts
+	maxPlayers    int
+	maxSpectators int
+}
+
+func newConnectionLimiter(cfg *config) *connectionLimiter {
+	return &connectionLimiter{maxPlayers: cfg.maxPlayers, maxSpectators: cfg.maxSpectators}
+}
+
+// acquire counts a new connection, returning false without counting it if the
+// limit for its kind has been reached. A limit of 0 means no limit.
+func (cl *connectionLimiter) acquire(spectator bool) bool {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	if spectator {
+		if cl.maxSpectators > 0 && cl.counts.Spectators >= cl.maxSpectators {
+			return false
+		}
+		cl.counts.Spectators++
+		return true
+	}
+	if cl.maxPlayers > 0 && cl.counts.Players >= cl.maxPlayers {
+		return false
+	}
+	cl.counts.Players++
+	return true
+}
+
+// release stops counting a connection once it has closed.
+func (cl *connectionLimiter) release(spectator bool) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	if spectator {
+		cl.counts.Spectators--
+	} else {
+		cl.counts.Players--
+	}
+}
+
+// snapshot returns the current counts.
+func (cl *connectionLimiter) snapshot() connectionCounts {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return cl.counts
+}
@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func Test_connectionLimiter(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.maxPlayers = 1
+	cl := newConnectionLimiter(cfg)
+	if !cl.acquire(false) {
+		t.Fatalf("Expected room for a player")
+	}
+	if cl.acquire(false) {
+		t.Errorf("Expected the player limit to be reached")
+	}
+	// Spectators are counted separately, and unlimited by default.
+	for i := 0; i < 3; i++ {
+		if !cl.acquire(true) {
+			t.Fatalf("Expected room for a spectator")
+		}
+	}
+	if got, want := cl.snapshot(), (connectionCounts{1, 3}); got != want {
+		t.Errorf("Expected %v but got %v", want, got)
+	}
+	cl.release(false)
+	if !cl.acquire(false) {
+		t.Errorf("Expected room for a player after one disconnected")
+	}
+
+	cfg.maxSpectators = 1
+	cl = newConnectionLimiter(cfg)
+	if !cl.acquire(true) || cl.acquire(true) {
+		t.Errorf("Expected the spectator limit to be one")
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// subscribe is sent by a client to receive only the cells in a region of the
+// grid. A nil region subscribes to the entire grid.
+type subscribe struct {
+	li     *listener
+	region *region
+}
+
+// subscribed carries a new subscription from gol to hub, along with copies of
+// the grid and ages as of the last diff, from which hub sends the cells that
+// have entered the Listener's region.
+type subscribed struct {
+	li     *listener
+	region *region
+	g      *grid
+	ages   *ageGrid
+}
+
+// broadcastDiff broadcasts a diff. It carries the diff itself, along with the
+// ants, so that hub can filter it for Listeners that have subscribed to a
+// region.
+type broadcastDiff struct {
+	broadcast
+	df   diff
+	ants []ant
+	// filtered holds the part of the diff in each region that a Listener has
+	// subscribed to, and regional holds the message for each region and diff
+	// format.
+	filtered map[region]diff
+	regional map[regionFormat]*lazyMessage
+}
+
+// regionFormat identifies the message sent to Listeners that have subscribed
+// to the same region and use the same diff format.
+type regionFormat struct {
+	r    region
+	caps capabilities
+}
+
+// regionVariant returns the part of the diff in region r, and the message to
+// send to a Listener that has subscribed to r.
+func (b *broadcastDiff) regionVariant(li *listener, r region) (diff, *lazyMessage) {
+	df, ok := b.filtered[r]
+	if !ok {
+		if b.filtered == nil {
+			b.filtered = make(map[region]diff)
+		}
+		df = filterDiff(b.df, r)
+		b.filtered[r] = df
+	}
+	key := regionFormat{r, li.caps.diffFormat()}
+	if m, ok := b.regional[key]; ok {
+		return df, m
+	}
+	if b.regional == nil {
+		b.regional = make(map[regionFormat]*lazyMessage)
+	}
+	ants := b.ants
+	m := newLazyMessage(func() []byte { return marshalDiff(df, ants, key.caps) })
+	b.regional[key] = m
+	return df, m
+}
+
+// contains reports whether cell (x, y) is in the region.
+func (r region) contains(x int, y int) bool {
+	return x >= r.x && x < r.x+r.height && y >= r.y && y < r.y+r.width
+}
+
+// filterDiff returns the part of a diff that is in a region.
+func filterDiff(df diff, r region) diff {
+	filtered := make(diff)
+	for x, ydiff := range df {
+		for y, s := range ydiff {
+			if r.contains(x, y) {
+				getOrMakeYDiff(filtered, x)[y] = s
+			}
+		}
+	}
+	return filtered
+}
+
+// subscribedMessage is the JSON representation of the cells that entered a
+// client's region when it subscribed.
+type subscribedMessage struct {
+	Type     string                         `json:"type"`
+	Viewport viewportJSON                   `json:"viewport"`
+	Cells    map[string]map[int]interface{} `json:"cells"`
+}
+
+// marshalSubscribed encodes the cells that are in region r but not in region
+// old, for a client with the given capabilities. Dead cells are included, since
+// the client's copies of them may be out of date.
+func marshalSubscribed(r region, old region, g *grid, ages *ageGrid, caps capabilities) []byte {
+	cells := make(map[string]map[int]interface{})
+	for x := r.x; x < r.x+r.height; x++ {
+		var row map[int]interface{}
+		for y := r.y; y < r.y+r.width; y++ {
+			if old.contains(x, y) {
+				continue
+			}
+			if row == nil {
+				row = make(map[int]interface{})
+				cells[strconv.Itoa(x)] = row
+			}
+			if caps.ages {
+				row[y] = agedCell(g[x][y], ages[x][y])
+			} else {
+				row[y] = g[x][y]
+			}
+		}
+	}
+	viewport := viewportJSON{int64(r.x), int64(r.y), int64(r.width), int64(r.height)}
+	message, _ := json.Marshal(&subscribedMessage{"subscribed", viewport, cells})
+	return message
+}
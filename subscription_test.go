@@ -0,0 +1,61 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_filterDiff(t *testing.T) {
+	df := diff{0: {0: "#aaaaaa", 5: "#aaaaaa"}, 3: {2: ""}, 4: {2: "#bbbbbb"}}
+	actual := filterDiff(df, region{x: 0, y: 0, width: 3, height: 4})
+	expected := diff{0: {0: "#aaaaaa"}, 3: {2: ""}}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected %v but got %v", expected, actual)
+	}
+}
+
+// Only the cells that are in the new region but not the old one should be
+// sent, along with their ages for clients with the ages capability.
+func Test_marshalSubscribed(t *testing.T) {
+	g, ages := &grid{}, &ageGrid{}
+	g[1][1] = "#aaaaaa"
+	ages[1][1] = 7
+	old := region{x: 0, y: 0, width: 2, height: 1}
+	r := region{x: 0, y: 0, width: 2, height: 2}
+
+	json := string(marshalSubscribed(r, old, g, ages, capabilities{}))
+	expected := "{\"type\":\"subscribed\",\"viewport\":{\"x\":0,\"y\":0,\"width\":2,\"height\":2},\"cells\":{\"1\":{\"0\":\"\",\"1\":\"#aaaaaa\"}}}"
+	if json != expected {
+		t.Errorf("Got incorrect JSON: %v", json)
+	}
+
+	json = string(marshalSubscribed(r, old, g, ages, capabilities{ages: true}))
+	expected = "{\"type\":\"subscribed\",\"viewport\":{\"x\":0,\"y\":0,\"width\":2,\"height\":2},\"cells\":{\"1\":{\"0\":\"\",\"1\":[\"#aaaaaa\",7]}}}"
+	if json != expected {
+		t.Errorf("Got incorrect JSON: %v", json)
+	}
+}
+
+// Listeners that have subscribed to the same region with the same diff format
+// should share one message.
+func Test_broadcastDiffRegionVariant(t *testing.T) {
+	b := &broadcastDiff{df: diff{0: {0: "#aaaaaa"}, 5: {5: "#bbbbbb"}}}
+	r := region{x: 0, y: 0, width: 2, height: 2}
+	v1 := &listener{caps: capabilities{}}
+	v1Messages := &listener{caps: capabilities{messages: true}}
+	v2 := &listener{caps: capabilities{version: protocolV2}}
+
+	df, m := b.regionVariant(v1, r)
+	if expected := (diff{0: {0: "#aaaaaa"}}); !reflect.DeepEqual(df, expected) {
+		t.Errorf("Expected %v but got %v", expected, df)
+	}
+	if _, shared := b.regionVariant(v1Messages, r); shared != m {
+		t.Errorf("Expected Listeners with the same diff format to share a message")
+	}
+	if _, other := b.regionVariant(v2, r); other == m {
+		t.Errorf("Expected Listeners with different diff formats not to share a message")
+	}
+	if json := string(m.bytes()); json != "{\"0\":{\"0\":\"#aaaaaa\"}}" {
+		t.Errorf("Got incorrect JSON: %v", json)
+	}
+}
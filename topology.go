@@ -0,0 +1,136 @@
+package main
+
+import "fmt"
+
+// topology determines how the edges of the grid are joined together, and
+// thereby which cells neighbor the cells at the edges.
+type topology string
+
+const (
+	// torus joins the top and bottom edges, and the left and right edges.
+	torus topology = "torus"
+	// bounded doesn't join any edges. Cells beyond the edges are always dead.
+	bounded topology = "bounded"
+	// klein joins the left and right edges as a torus does, but joins the top
+	// and bottom edges with a half-twist, so that leaving the grid through the
+	// top edge near the left lands near the right of the bottom edge.
+	klein topology = "klein"
+	// crossSurface joins both pairs of edges with a half-twist, forming a
+	// projective plane.
+	crossSurface topology = "cross-surface"
+	// plane has no edges. Rather than the grid, the world is an unbounded
+	// plane of chunks, which gol evolves as a planeBoard. See world.
+	plane topology = "plane"
+)
+
+// topologies lists the valid topologies.
+var topologies = []topology{torus, bounded, klein, crossSurface, plane}
+
+// parseTopology checks that a name refers to a known topology.
+func parseTopology(name string) (topology, error) {
+	for _, t := range topologies {
+		if string(t) == name {
+			return t, nil
+		}
+	}
+	return "", fmt.Errorf("unknown topology (%v)", name)
+}
+
+// wrap maps a position that may lie beyond the edges of the grid to the cell
+// that it refers to. ok is false if the position doesn't refer to any cell.
+// For code that works on the grid, such as exports, plane behaves as bounded,
+// and the grid stands for the part of the plane that starts at the origin.
+func (t topology) wrap(x int, y int) (p point, ok bool) {
+	if t == bounded || t == plane {
+		return point{x, y}, x >= 0 && x < gridDimX && y >= 0 && y < gridDimY
+	}
+	// Crossing the top or bottom edge an odd number of times mirrors Y if
+	// those edges are twisted.
+	kx := floorDiv(x, gridDimX)
+	x -= kx * gridDimX
+	if kx%2 != 0 && (t == klein || t == crossSurface) {
+		y = gridDimY - 1 - y
+	}
+	// Likewise for the left and right edges and X.
+	ky := floorDiv(y, gridDimY)
+	y -= ky * gridDimY
+	if ky%2 != 0 && t == crossSurface {
+		x = gridDimX - 1 - x
+	}
+	return point{x, y}, true
+}
+
+// mirrors reports whether wrap maps a position across an odd number of twisted
+// edges, so that the cell it refers to is seen mirrored from the position.
+func (t topology) mirrors(x int, y int) bool {
+	if t != klein && t != crossSurface {
+		return false
+	}
+	mirrored := false
+	kx := floorDiv(x, gridDimX)
+	if kx%2 != 0 {
+		mirrored = true
+		y = gridDimY - 1 - y
+	}
+	if ky := floorDiv(y, gridDimY); ky%2 != 0 && t == crossSurface {
+		mirrored = !mirrored
+	}
+	return mirrored
+}
+
+// floorDiv divides a by b, rounding toward negative infinity.
+func floorDiv(a int, b int) int {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
+
+// worldInfo describes the grid to clients, so that they can lay out cells and
+// pan across joined edges correctly.
+//
+// On the plane, Width and Height are 0, and ChunkSize is the size of the
+// chunks that subscriptions are made of.
+type worldInfo struct {
+	Width        int              `json:"width"`
+	Height       int              `json:"height"`
+	ChunkSize    int              `json:"chunkSize,omitempty"`
+	Topology     topology         `json:"topology"`
+	Rule         string           `json:"rule"`
+	Neighborhood neighborhoodKind `json:"neighborhood"`
+	Radius       int              `json:"radius"`
+	// Hexagonal tells clients to lay out the grid as hexagons. See
+	// hexagonal.
+	Hexagonal bool `json:"hexagonal"`
+}
+
+// newWorldInfo returns the worldInfo for a config.
+func newWorldInfo(cfg *config) worldInfo {
+	if cfg.topology == plane {
+		return worldInfo{
+			ChunkSize:    chunkSize,
+			Topology:     cfg.topology,
+			Rule:         cfg.rule.notation,
+			Neighborhood: cfg.neighborhood.kind,
+			Radius:       cfg.neighborhood.radius,
+			Hexagonal:    cfg.neighborhood.kind == hexagonal,
+		}
+	}
+	return worldInfo{
+		Width:        gridDimY,
+		Height:       gridDimX,
+		Topology:     cfg.topology,
+		Rule:         cfg.rule.notation,
+		Neighborhood: cfg.neighborhood.kind,
+		Radius:       cfg.neighborhood.radius,
+		Hexagonal:    cfg.neighborhood.kind == hexagonal,
+	}
+}
+
+// worldMessage is the JSON representation of a worldInfo sent to clients with
+// the world capability.
+type worldMessage struct {
+	Type string `json:"type"`
+	worldInfo
+}
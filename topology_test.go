@@ -0,0 +1,170 @@
+package main
+
+import "testing"
+
+func Test_parseTopology(t *testing.T) {
+	for _, name := range []string{"torus", "bounded", "klein", "cross-surface"} {
+		if topo, err := parseTopology(name); err != nil || string(topo) != name {
+			t.Errorf("Expected %q to be parsed but got %q, %v", name, topo, err)
+		}
+	}
+	if _, err := parseTopology("sphere"); err == nil {
+		t.Errorf("Expected an unknown topology to be rejected")
+	}
+}
+
+func Test_wrap(t *testing.T) {
+	const maxX, maxY = gridDimX - 1, gridDimY - 1
+	cases := []struct {
+		topo topology
+		x, y int
+		want point
+		ok   bool
+	}{
+		{torus, 5, 6, point{5, 6}, true},
+		{torus, -1, 6, point{maxX, 6}, true},
+		{torus, gridDimX, 6, point{0, 6}, true},
+		{torus, 5, -1, point{5, maxY}, true},
+		{torus, -1, -1, point{maxX, maxY}, true},
+		{torus, gridDimX, gridDimY, point{0, 0}, true},
+
+		{bounded, 5, 6, point{5, 6}, true},
+		{bounded, -1, 6, point{}, false},
+		{bounded, 5, gridDimY, point{}, false},
+		{bounded, -1, -1, point{}, false},
+
+		// Crossing the top or bottom edge mirrors Y.
+		{klein, -1, 6, point{maxX, maxY - 6}, true},
+		{klein, gridDimX, 6, point{0, maxY - 6}, true},
+		// Crossing the left or right edge doesn't.
+		{klein, 5, -1, point{5, maxY}, true},
+		{klein, 5, gridDimY, point{5, 0}, true},
+		{klein, -1, -1, point{maxX, 0}, true},
+		{klein, gridDimX, gridDimY, point{0, maxY}, true},
+
+		// Crossing any edge mirrors the other axis.
+		{crossSurface, -1, 6, point{maxX, maxY - 6}, true},
+		{crossSurface, 5, -1, point{maxX - 5, maxY}, true},
+		{crossSurface, 5, gridDimY, point{maxX - 5, 0}, true},
+		{crossSurface, -1, -1, point{0, 0}, true},
+		{crossSurface, gridDimX, gridDimY, point{maxX, maxY}, true},
+	}
+	for _, c := range cases {
+		p, ok := c.topo.wrap(c.x, c.y)
+		if ok != c.ok || (ok && p != c.want) {
+			t.Errorf("Expected %v to wrap (%v, %v) to %v, %v but got %v, %v",
+				c.topo, c.x, c.y, c.want, c.ok, p, ok)
+		}
+	}
+}
+
+// neighborsTest counts the neighbors of (x, y) in a grid where only the given
+// cells are live.
+func neighborsTest(topo topology, x int, y int, live ...point) int {
+	g := &grid{}
+	for _, p := range live {
+		g[p.x][p.y] = "#aaaaaa"
+	}
+	rs := testRuleset()
+	rs.topology = topo
+	n, _, _ := neighbors(g, x, y, rs)
+	return n
+}
+
+func Test_neighborsTopologyEdge(t *testing.T) {
+	const maxX, maxY = gridDimX - 1, gridDimY - 1
+	// Cells on the bottom edge, at the left and right of the column that
+	// mirrors column 10.
+	live := []point{{maxX, 10}, {maxX, maxY - 10}}
+	cases := []struct {
+		topo topology
+		want int
+	}{
+		{torus, 1},
+		{bounded, 0},
+		{klein, 1},
+		{crossSurface, 1},
+	}
+	for _, c := range cases {
+		if n := neighborsTest(c.topo, 0, 10, live...); n != c.want {
+			t.Errorf("Expected (0, 10) to have %v neighbors on a %v but got %v",
+				c.want, c.topo, n)
+		}
+	}
+	// On a torus, the neighbor is the cell directly across the edge.
+	if n := neighborsTest(torus, 0, 11, live...); n != 1 {
+		t.Errorf("Expected (0, 11) to have 1 neighbor on a torus but got %v", n)
+	}
+	// On a Klein bottle, it is the cell in the mirrored column.
+	if n := neighborsTest(klein, 0, maxY-11, live...); n != 1 {
+		t.Errorf("Expected (0, %v) to have 1 neighbor on a Klein bottle but got %v", maxY-11, n)
+	}
+	// The left and right edges of a Klein bottle are joined without a twist.
+	if n := neighborsTest(klein, 10, 0, point{10, maxY}); n != 1 {
+		t.Errorf("Expected (10, 0) to have 1 neighbor on a Klein bottle but got %v", n)
+	}
+	// The left and right edges of a cross-surface are twisted.
+	if n := neighborsTest(crossSurface, 10, 0, point{10, maxY}); n != 0 {
+		t.Errorf("Expected (10, 0) to have no neighbors on a cross-surface but got %v", n)
+	}
+	if n := neighborsTest(crossSurface, 10, 0, point{maxX - 10, maxY}); n != 1 {
+		t.Errorf("Expected (10, 0) to have 1 neighbor on a cross-surface but got %v", n)
+	}
+}
+
+func Test_neighborsTopologyCorner(t *testing.T) {
+	const maxX, maxY = gridDimX - 1, gridDimY - 1
+	corners := []point{{0, 0}, {0, maxY}, {maxX, 0}, {maxX, maxY}}
+	cases := []struct {
+		topo topology
+		want int
+	}{
+		// The other three corners are all diagonal neighbors across the edges.
+		{torus, 3},
+		// Only the three cells inside the grid, none of which are live.
+		{bounded, 0},
+		// (0, maxY) across the left edge, and (maxX, maxY) mirrored across
+		// the top edge, and (maxX, 0) at the diagonal.
+		{klein, 3},
+		// Both twists meet at (0, 0), which is its own diagonal neighbor, and
+		// the mirrored (maxX, maxY) is adjacent across both edges.
+		{crossSurface, 3},
+	}
+	for _, c := range cases {
+		if n := neighborsTest(c.topo, 0, 0, corners...); n != c.want {
+			t.Errorf("Expected (0, 0) to have %v neighbors on a %v but got %v",
+				c.want, c.topo, n)
+		}
+	}
+}
+
+// An ant should turn around at the edge of a bounded grid.
+func Test_moveAntsBounded(t *testing.T) {
+	g, df := &grid{}, make(diff)
+	a := &ant{0, 5, north, "#aaaaaa", false}
+
+	moveAnts([]*ant{a}, "NN", bounded, g, df)
+
+	if *a != (ant{0, 5, south, "#aaaaaa", false}) {
+		t.Errorf("Expected ant to turn around but got %+v", *a)
+	}
+}
+
+func Test_moveAntsTwisted(t *testing.T) {
+	g, df := &grid{}, make(diff)
+	a := &ant{0, 5, north, "#aaaaaa", false}
+
+	moveAnts([]*ant{a}, "NN", klein, g, df)
+
+	if *a != (ant{gridDimX - 1, gridDimY - 6, north, "#aaaaaa", true}) {
+		t.Fatalf("Expected ant to cross the twisted edge but got %+v", *a)
+	}
+
+	moveAnts([]*ant{a}, "RL", klein, g, make(diff))
+
+	// The grid is mirrored across the edge, so a right turn there is a left
+	// turn as seen on the grid.
+	if *a != (ant{gridDimX - 1, gridDimY - 7, west, "#aaaaaa", true}) {
+		t.Errorf("Expected ant to turn left as seen on the grid but got %+v", *a)
+	}
+}
@@ -8,12 +8,26 @@ import (
 
 var hexColorCode = regexp.MustCompile(`\A#[0-9a-f]{6}\z`)
 
+// validateDiff checks a diff sent by a client.
 func validateDiff(df diff) error {
+	return validateDiffCells(df, validateCell)
+}
+
+// validateServerDiff checks a diff sent by the server, such as one read from
+// a recorded session. Unlike a client, the server may kill cells and set them
+// to dying states.
+func validateServerDiff(df diff) error {
+	return validateDiffCells(df, validateServerCell)
+}
+
+// validateDiffCells checks that a diff is not empty and lies within the grid,
+// and checks each of its cell values with check.
+func validateDiffCells(df diff, check func(v species) error) error {
 	if len(df) == 0 {
 		return errors.New("diff is empty")
 	}
 	for x := range df {
-		if x >= gridDimX {
+		if x < 0 || x >= gridDimX {
 			return errors.New("diff exceeds grid's X dimension")
 		}
 		ydiff := df[x]
@@ -21,14 +35,39 @@ func validateDiff(df diff) error {
 			return errors.New("diff includes an X coordinate with no Y coordinate")
 		}
 		for y, v := range ydiff {
-			if y >= gridDimY {
+			if y < 0 || y >= gridDimY {
 				return errors.New("diff exceeds grid's Y dimension")
 			}
-			if !hexColorCode.MatchString(v) {
-				return fmt.Errorf("diff contains a cell value that is not a "+
-					"hexadecimal color code (%v)", v)
+			if err := check(v); err != nil {
+				return err
 			}
 		}
 	}
 	return nil
 }
+
+// validateCell checks a cell value in a client diff.
+func validateCell(v species) error {
+	if _, state := cellState(v); state > 1 {
+		return fmt.Errorf("diff sets a cell to a dying state, which only "+
+			"the server may do (%v)", v)
+	}
+	if !hexColorCode.MatchString(v) {
+		return fmt.Errorf("diff contains a cell value that is not a "+
+			"hexadecimal color code (%v)", v)
+	}
+	return nil
+}
+
+// validateServerCell checks a cell value in a server diff, which may be dead,
+// live, or dying.
+func validateServerCell(v species) error {
+	if v == "" {
+		return nil
+	}
+	s, state := cellState(v)
+	if state < 1 || (state == 1 && s != v) || !hexColorCode.MatchString(s) {
+		return fmt.Errorf("diff contains a malformed cell value (%v)", v)
+	}
+	return nil
+}
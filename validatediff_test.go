@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func Test_validateDiff(t *testing.T) {
+	if err := validateDiff(diff{0: {gridDimY - 1: "#aaaaaa"}, gridDimX - 1: {0: "#aaaaaa"}}); err != nil {
+		t.Errorf("Expected cells at the edges of the grid to be valid but got %v", err)
+	}
+	invalid := []diff{
+		{},
+		{0: {}},
+		{-1: {0: "#aaaaaa"}},
+		{0: {-1: "#aaaaaa"}},
+		{gridDimX: {0: "#aaaaaa"}},
+		{0: {gridDimY: "#aaaaaa"}},
+		{0: {0: ""}},
+		{0: {0: "#aaaaaa:2"}},
+	}
+	for _, df := range invalid {
+		if err := validateDiff(df); err == nil {
+			t.Errorf("Expected %v to be rejected", df)
+		}
+	}
+}